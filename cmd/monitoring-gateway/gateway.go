@@ -2,21 +2,31 @@ package main
 
 import (
 	"context"
-	"net/url"
+	"net"
+	"os"
 	"time"
 
+	"github.com/alecthomas/units"
 	extflag "github.com/efficientgo/tools/extkingpin"
 	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
 	"github.com/oklog/run"
 	opentracing "github.com/opentracing/opentracing-go"
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/common/model"
 	"github.com/thanos-io/thanos/pkg/component"
+	"github.com/thanos-io/thanos/pkg/extgrpc"
 	"github.com/thanos-io/thanos/pkg/extkingpin"
 	"github.com/thanos-io/thanos/pkg/extprom"
+	"github.com/thanos-io/thanos/pkg/info/infopb"
 	"github.com/thanos-io/thanos/pkg/prober"
 	httpserver "github.com/thanos-io/thanos/pkg/server/http"
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"k8s.io/client-go/kubernetes"
+	kconfig "sigs.k8s.io/controller-runtime/pkg/client/config"
 
 	monitoringgateway "github.com/WhizardTelemetry/whizard/pkg/monitoring-gateway"
 )
@@ -31,26 +41,66 @@ type gatewayConfig struct {
 	tenantsFilePath    string
 	tenantsFileContent string
 	refreshInterval    *model.Duration
+	tenantsService     string
 
-	tenantHeader    string
-	tenantLabelName string
+	limitsFilePath        string
+	limitsFileContent     string
+	limitsRefreshInterval *model.Duration
+
+	accessPolicyFilePath        string
+	accessPolicyFileContent     string
+	accessPolicyRefreshInterval *model.Duration
+
+	hashringsFilePath        string
+	hashringsRefreshInterval *model.Duration
+
+	gatewayConfigFilePath        string
+	gatewayConfigContent         string
+	gatewayConfigRefreshInterval *model.Duration
+
+	tenantHeader           string
+	tenantLabelName        string
+	tenantLabelEnforcement string
+
+	authClientCert bool
+
+	haTracker       bool
+	haTrackerConfig monitoringgateway.HATrackerConfig
+
+	authKubernetes       bool
+	kubernetesAuthConfig monitoringgateway.KubernetesAuthConfig
+	oidcConfig           monitoringgateway.OIDCConfig
+
+	auditConfig monitoringgateway.AuditConfig
+	otlpConfig  monitoringgateway.OTLPConfig
+
+	otlpGRPCAddress    string
+	grpcMaxRecvMsgSize units.Base2Bytes
+
+	storeAPIGRPCAddress string
+	usageRetention      time.Duration
 
 	ExternalRemoteWrites struct {
 		ConfigPathOrContent extflag.PathOrContent
+		WALDir              string
 	}
 
-	queryConfig       *monitoringgateway.QueryConfig
-	rulesQueryConfig  *monitoringgateway.RulesQueryConfig
-	remoteWriteConfig *monitoringgateway.RemoteWriteConfig
+	queryConfig        *monitoringgateway.QueryConfig
+	rulesQueryConfig   *monitoringgateway.RulesQueryConfig
+	alertmanagerConfig *monitoringgateway.AlertmanagerConfig
+	remoteWriteConfig  *monitoringgateway.RemoteWriteConfig
+	storeConfig        *monitoringgateway.StoreConfig
 }
 
 func registerGateway(app *extkingpin.App) {
 	cmd := app.Command(Gateway.String(), "Proxy and forward query and remote write API requests to thanos.")
 
 	conf := &gatewayConfig{
-		queryConfig:       &monitoringgateway.QueryConfig{},
-		rulesQueryConfig:  &monitoringgateway.RulesQueryConfig{},
-		remoteWriteConfig: &monitoringgateway.RemoteWriteConfig{},
+		queryConfig:        &monitoringgateway.QueryConfig{},
+		rulesQueryConfig:   &monitoringgateway.RulesQueryConfig{},
+		alertmanagerConfig: &monitoringgateway.AlertmanagerConfig{},
+		remoteWriteConfig:  &monitoringgateway.RemoteWriteConfig{},
+		storeConfig:        &monitoringgateway.StoreConfig{},
 	}
 	conf.registerFlag(cmd)
 
@@ -60,6 +110,7 @@ func registerGateway(app *extkingpin.App) {
 			g,
 			logger,
 			reg,
+			tracer,
 			conf,
 			Gateway,
 		)
@@ -70,6 +121,7 @@ func runGateway(
 	g *run.Group,
 	logger log.Logger,
 	reg *prometheus.Registry,
+	tracer opentracing.Tracer,
 	conf *gatewayConfig,
 	comp component.Component,
 ) error {
@@ -87,80 +139,79 @@ func runGateway(
 	)
 
 	options := &monitoringgateway.Options{
-		TenantHeader:    conf.tenantHeader,
-		TenantLabelName: conf.tenantLabelName,
-		EnabledQueryUI:  conf.debugEnabledUI,
+		ExternalRWWALDir: conf.ExternalRemoteWrites.WALDir,
+		EnabledQueryUI:   conf.debugEnabledUI,
+		Audit:            conf.auditConfig,
+		UsageRetention:   conf.usageRetention,
 	}
 
-	if conf.queryConfig.DownstreamURL != "" {
-		downstreamURL, err := url.Parse(conf.queryConfig.DownstreamURL)
+	var authenticators []monitoringgateway.Authenticator
+	if conf.authClientCert {
+		authenticators = append(authenticators, monitoringgateway.NewCertAuthenticator())
+	}
+	if conf.oidcConfig.JWKSFile != "" || conf.oidcConfig.JWKSURL != "" {
+		oidcAuthenticator, err := monitoringgateway.NewOIDCAuthenticator(log.With(logger, "component", "oidc-authenticator"), conf.oidcConfig)
 		if err != nil {
-			return errors.Wrap(err, "setup query downstream service")
+			return errors.Wrap(err, "setup oidc authenticator")
 		}
-		downstreamTripperConfContentYaml, err := conf.queryConfig.DownstreamTripperConfig.TripperPathOrContent.Content()
+		authenticators = append(authenticators, oidcAuthenticator)
+	}
+	if conf.authKubernetes {
+		client, err := newKubernetesClient()
 		if err != nil {
 			return err
 		}
-		downstreamTripper, err := monitoringgateway.ParseTransportConfiguration(downstreamTripperConfContentYaml)
+		kubernetesAuthenticator, err := monitoringgateway.NewKubernetesAuthenticator(log.With(logger, "component", "kubernetes-authenticator"), client, conf.kubernetesAuthConfig)
 		if err != nil {
-			return err
+			return errors.Wrap(err, "setup kubernetes authenticator")
 		}
-		options.QueryProxy = monitoringgateway.NewSingleHostReverseProxy(downstreamURL, downstreamTripper)
+		authenticators = append(authenticators, kubernetesAuthenticator)
+	}
+	if len(authenticators) > 0 {
+		options.Authenticator = monitoringgateway.NewUnionAuthenticator(authenticators...)
 	}
 
-	if conf.rulesQueryConfig.DownstreamURL != "" {
-		downstreamURL, err := url.Parse(conf.rulesQueryConfig.DownstreamURL)
-		if err != nil {
-			return errors.Wrap(err, "setup rules query downstream service")
-		}
-		downstreamTripperConfContentYaml, err := conf.rulesQueryConfig.DownstreamTripperConfig.TripperPathOrContent.Content()
-		if err != nil {
-			return err
+	if conf.haTracker {
+		var client kubernetes.Interface
+		if conf.haTrackerConfig.LeaseNamespace != "" {
+			var err error
+			if client, err = newKubernetesClient(); err != nil {
+				return err
+			}
 		}
-		downstreamTripper, err := monitoringgateway.ParseTransportConfiguration(downstreamTripperConfContentYaml)
+		haTracker, err := monitoringgateway.NewHATracker(log.With(logger, "component", "ha-tracker"), reg, conf.haTrackerConfig, client)
 		if err != nil {
-			return err
+			return errors.Wrap(err, "setup ha tracker")
 		}
-		options.RulesQueryProxy = monitoringgateway.NewSingleHostReverseProxy(downstreamURL, downstreamTripper)
+		options.HATracker = haTracker
 	}
 
-	if conf.remoteWriteConfig.DownstreamURL != "" {
-		downstreamURL, err := url.Parse(conf.remoteWriteConfig.DownstreamURL)
+	var storeConn *grpc.ClientConn
+	if conf.storeConfig.Address != "" {
+		sc := conf.storeConfig
+		dialOpts, err := extgrpc.StoreClientGRPCOpts(logger, reg, tracer, sc.TLSSecure, sc.TLSSkipVerify, sc.TLSCert, sc.TLSKey, sc.TLSCA, sc.ServerName)
 		if err != nil {
-			return errors.Wrap(err, "setup remote write downstream service")
-		}
-		downstreamTripperConfContentYaml, err := conf.remoteWriteConfig.DownstreamTripperConfig.TripperPathOrContent.Content()
-		if err != nil {
-			return err
+			return errors.Wrap(err, "setup store client")
 		}
-		downstreamTripper, err := monitoringgateway.ParseTransportConfiguration(downstreamTripperConfContentYaml)
+		storeConn, err = grpc.NewClient(sc.Address, dialOpts...)
 		if err != nil {
-			return err
+			return errors.Wrap(err, "setup store client")
 		}
-		options.RemoteWriteProxy = monitoringgateway.NewSingleHostReverseProxy(downstreamURL, downstreamTripper)
-	}
-
-	content, err := conf.ExternalRemoteWrites.ConfigPathOrContent.Content()
-	if err != nil {
-		return err
-	}
-	rwsCfg, err := monitoringgateway.LoadExternalRemoteWriteConfig("", string(content))
-	if err != nil {
-		return err
-	}
-	clients, err := monitoringgateway.NewExternalRemoteWriteClients(rwsCfg)
-	if err != nil {
-		return err
+		options.StoreClient = storepb.NewStoreClient(storeConn)
+		options.StoreInfoClient = infopb.NewInfoClient(storeConn)
 	}
 
-	options.ExternalRWClients = clients
-
-	if conf.tenantsFileContent != "" || conf.tenantsFilePath != "" {
+	if conf.tenantsFileContent != "" || conf.tenantsFilePath != "" || conf.tenantsService != "" {
 		options.EnabledTenantsAdmission = true
 	}
 
 	webhandler := monitoringgateway.NewHandler(logger, reg, options)
 
+	if err := runGatewayConfig(g, logger, reg, conf, webhandler); err != nil {
+		webhandler.Stop()
+		return err
+	}
+
 	srv.Handle("/", webhandler.Router())
 
 	//
@@ -173,12 +224,49 @@ func runGateway(
 		defer statusProber.NotHealthy(err)
 
 		srv.Shutdown(err)
+		webhandler.Stop()
+		if storeConn != nil {
+			storeConn.Close()
+		}
 	})
 
+	if conf.otlpGRPCAddress != "" {
+		if err := runGRPCServer(g, logger, conf, "otlp", conf.otlpGRPCAddress, webhandler.RegisterOTLPGRPCServer); err != nil {
+			return err
+		}
+	}
+	if conf.storeAPIGRPCAddress != "" {
+		if conf.storeConfig.Address == "" {
+			return errors.New("the StoreAPI is served from the store address, which is not given")
+		}
+		if err := runGRPCServer(g, logger, conf, "storeapi", conf.storeAPIGRPCAddress, webhandler.RegisterStoreAPIServer); err != nil {
+			return err
+		}
+	}
+
 	updates := make(chan monitoringgateway.AdmissionControlConfig, 1)
 
-	// The config file path is given initializing config watcher.
-	if conf.tenantsFilePath != "" {
+	if conf.tenantsService != "" {
+		// The tenants are watched by an informer instead of read from the config file.
+		cfg, err := kconfig.GetConfig()
+		if err != nil {
+			close(updates)
+			return errors.Wrap(err, "failed to get kubeconfig")
+		}
+		ti, err := monitoringgateway.NewTenantInformer(log.With(logger, "component", "tenant-informer"), reg, cfg, conf.tenantsService)
+		if err != nil {
+			close(updates)
+			return errors.Wrap(err, "failed to initialize tenant informer")
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		g.Add(func() error {
+			return ti.Run(ctx, updates)
+		}, func(error) {
+			cancel()
+		})
+	} else if conf.tenantsFilePath != "" {
+		// The config file path is given initializing config watcher.
 		cw, err := monitoringgateway.NewConfigWatcher(log.With(logger, "component", "config-watcher"), reg, conf.tenantsFilePath, *conf.refreshInterval)
 		if err != nil {
 			return errors.Wrap(err, "failed to initialize config watcher")
@@ -222,6 +310,16 @@ func runGateway(
 		})
 	}
 
+	if err := runLimitsConfig(g, logger, reg, conf, webhandler); err != nil {
+		return err
+	}
+	if err := runAccessPolicyConfig(g, logger, reg, conf, webhandler); err != nil {
+		return err
+	}
+	if err := runHashringsConfig(g, logger, reg, conf, webhandler); err != nil {
+		return err
+	}
+
 	cancel := make(chan struct{})
 	g.Add(func() error {
 
@@ -251,22 +349,333 @@ func runGateway(
 	return nil
 }
 
+func newKubernetesClient() (kubernetes.Interface, error) {
+	cfg, err := kconfig.GetConfig()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get kubernetes config")
+	}
+	client, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create kubernetes client")
+	}
+	return client, nil
+}
+
+// runGRPCServer serves the services registered by register over gRPC, secured by the TLS configuration of the HTTP server.
+func runGRPCServer(g *run.Group, logger log.Logger, conf *gatewayConfig, name, address string, register func(*grpc.Server)) error {
+	opts := []grpc.ServerOption{grpc.MaxRecvMsgSize(int(conf.grpcMaxRecvMsgSize))}
+	if *conf.httpTLSConfig != "" {
+		tlsConfig, err := monitoringgateway.LoadWebTLSConfig(*conf.httpTLSConfig)
+		if err != nil {
+			return errors.Wrapf(err, "setup %s grpc server tls", name)
+		}
+		if tlsConfig != nil {
+			opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+		}
+	}
+	s := grpc.NewServer(opts...)
+	register(s)
+
+	g.Add(func() error {
+		l, err := net.Listen("tcp", address)
+		if err != nil {
+			return errors.Wrapf(err, "listen %s grpc address", name)
+		}
+		level.Info(logger).Log("msg", "listening for gRPC requests", "service", name, "address", address)
+		return s.Serve(l)
+	}, func(error) {
+		s.GracefulStop()
+	})
+	return nil
+}
+
+// runGatewayConfig applies the gateway configuration, and keeps it up to date if it is given by a file.
+// A configuration that fails to apply is skipped, so that the last good one stays in effect.
+func runGatewayConfig(g *run.Group, logger log.Logger, reg *prometheus.Registry, conf *gatewayConfig, webhandler *monitoringgateway.Handler) error {
+	if conf.gatewayConfigFilePath == "" {
+		var (
+			cf  monitoringgateway.GatewayConfig
+			err error
+		)
+		if len(conf.gatewayConfigContent) > 0 {
+			cf, err = monitoringgateway.ParseGatewayConfig([]byte(conf.gatewayConfigContent))
+		} else {
+			cf, err = conf.gatewayConfigFromFlags()
+		}
+		if err != nil {
+			return errors.Wrap(err, "failed to validate gateway configuration")
+		}
+		return webhandler.ApplyConfig(cf)
+	}
+
+	gw, err := monitoringgateway.NewGatewayConfigWatcher(log.With(logger, "component", "gateway-config-watcher"), reg, conf.gatewayConfigFilePath, *conf.gatewayConfigRefreshInterval)
+	if err != nil {
+		return errors.Wrap(err, "failed to initialize gateway config watcher")
+	}
+	content, err := os.ReadFile(conf.gatewayConfigFilePath)
+	if err != nil {
+		gw.Stop()
+		return errors.Wrap(err, "failed to read gateway configuration file")
+	}
+	cf, err := monitoringgateway.ParseGatewayConfig(content)
+	if err != nil {
+		gw.Stop()
+		return errors.Wrap(err, "failed to validate gateway configuration file")
+	}
+	// Apply the configuration before serving, so that no request is served without the downstreams.
+	if err := webhandler.ApplyConfig(cf); err != nil {
+		gw.Stop()
+		return errors.Wrap(err, "failed to apply gateway configuration file")
+	}
+
+	// A configuration that fails to apply is reported by the reload metrics, and the last applied one stays in effect.
+	ctx, cancel := context.WithCancel(context.Background())
+	g.Add(func() error {
+		return monitoringgateway.ApplyFromWatcher(ctx, gw, webhandler.ApplyConfig)
+	}, func(error) {
+		cancel()
+	})
+	return nil
+}
+
+// gatewayConfigFromFlags returns the gateway configuration given by the downstream, external remote write and tenant flags.
+func (gc *gatewayConfig) gatewayConfigFromFlags() (monitoringgateway.GatewayConfig, error) {
+	c := monitoringgateway.GatewayConfig{
+		Tenant: monitoringgateway.TenantConfig{
+			Header:           gc.tenantHeader,
+			LabelName:        gc.tenantLabelName,
+			LabelEnforcement: monitoringgateway.TenantLabelEnforcement(gc.tenantLabelEnforcement),
+		},
+	}
+
+	var err error
+	if c.Query, err = downstreamConfig(gc.queryConfig.DownstreamURL, gc.queryConfig.TripperPathOrContent); err != nil {
+		return c, errors.Wrap(err, "setup query downstream service")
+	}
+	if c.RulesQuery, err = downstreamConfig(gc.rulesQueryConfig.DownstreamURL, gc.rulesQueryConfig.TripperPathOrContent); err != nil {
+		return c, errors.Wrap(err, "setup rules query downstream service")
+	}
+	if c.Alertmanager, err = downstreamConfig(gc.alertmanagerConfig.DownstreamURL, gc.alertmanagerConfig.TripperPathOrContent); err != nil {
+		return c, errors.Wrap(err, "setup alertmanager downstream service")
+	}
+	if c.RemoteWrite.DownstreamConfig, err = downstreamConfig(gc.remoteWriteConfig.DownstreamURL, gc.remoteWriteConfig.TripperPathOrContent); err != nil {
+		return c, errors.Wrap(err, "setup remote write downstream service")
+	}
+	c.RemoteWrite.ProtobufMessage = monitoringgateway.RemoteWriteProtoMsg(gc.remoteWriteConfig.ProtobufMessage)
+	c.OTLP = gc.otlpConfig
+
+	content, err := gc.ExternalRemoteWrites.ConfigPathOrContent.Content()
+	if err != nil {
+		return c, err
+	}
+	if c.ExternalRemoteWrites, err = monitoringgateway.LoadExternalRemoteWriteConfig("", string(content)); err != nil {
+		return c, err
+	}
+	return c, c.Validate()
+}
+
+func downstreamConfig(downstreamURL string, tripperPathOrContent extflag.PathOrContent) (monitoringgateway.DownstreamConfig, error) {
+	content, err := tripperPathOrContent.Content()
+	if err != nil {
+		return monitoringgateway.DownstreamConfig{}, err
+	}
+	tripperConfig, err := monitoringgateway.ParseDownstreamTripperConfig(content)
+	if err != nil {
+		return monitoringgateway.DownstreamConfig{}, err
+	}
+	return monitoringgateway.DownstreamConfig{URL: downstreamURL, TripperConfig: tripperConfig}, nil
+}
+
+// runLimitsConfig loads the tenant limits, and keeps them up to date if they are given by a file.
+func runLimitsConfig(g *run.Group, logger log.Logger, reg *prometheus.Registry, conf *gatewayConfig, webhandler *monitoringgateway.Handler) error {
+	if conf.limitsFilePath == "" {
+		if len(conf.limitsFileContent) == 0 {
+			return nil
+		}
+		cf, err := monitoringgateway.ParseLimitsConfig([]byte(conf.limitsFileContent))
+		if err != nil {
+			return errors.Wrap(err, "failed to validate limits configuration content")
+		}
+		return webhandler.SetLimits(cf)
+	}
+
+	lw, err := monitoringgateway.NewLimitsConfigWatcher(log.With(logger, "component", "limits-config-watcher"), reg, conf.limitsFilePath, *conf.limitsRefreshInterval)
+	if err != nil {
+		return errors.Wrap(err, "failed to initialize limits config watcher")
+	}
+	if err := lw.ValidateConfig(); err != nil {
+		lw.Stop()
+		return errors.Wrap(err, "failed to validate limits configuration file")
+	}
+
+	updates := make(chan monitoringgateway.LimitsConfig, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	g.Add(func() error {
+		return monitoringgateway.ConfigFromWatcher(ctx, updates, lw)
+	}, func(error) {
+		cancel()
+	})
+	g.Add(func() error {
+		for c := range updates {
+			if err := webhandler.SetLimits(c); err != nil {
+				return errors.Wrap(err, "failed to set tenant limits in gateway")
+			}
+		}
+		return nil
+	}, func(error) {
+		cancel()
+	})
+	return nil
+}
+
+// runAccessPolicyConfig loads the access policies, and keeps them up to date if they are given by a file.
+// Policies that fail to apply are skipped, so that the last good ones stay in effect.
+func runAccessPolicyConfig(g *run.Group, logger log.Logger, reg *prometheus.Registry, conf *gatewayConfig, webhandler *monitoringgateway.Handler) error {
+	if conf.accessPolicyFilePath == "" {
+		if len(conf.accessPolicyFileContent) == 0 {
+			return nil
+		}
+		cf, err := monitoringgateway.ParseAccessPolicyConfig([]byte(conf.accessPolicyFileContent))
+		if err != nil {
+			return errors.Wrap(err, "failed to validate access policy configuration content")
+		}
+		return webhandler.SetAccessPolicies(cf)
+	}
+
+	aw, err := monitoringgateway.NewAccessPolicyConfigWatcher(log.With(logger, "component", "access-policy-config-watcher"), reg, conf.accessPolicyFilePath, *conf.accessPolicyRefreshInterval)
+	if err != nil {
+		return errors.Wrap(err, "failed to initialize access policy config watcher")
+	}
+	if err := aw.ValidateConfig(); err != nil {
+		aw.Stop()
+		return errors.Wrap(err, "failed to validate access policy configuration file")
+	}
+
+	updates := make(chan monitoringgateway.AccessPolicyConfig, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	g.Add(func() error {
+		return monitoringgateway.ConfigFromWatcher(ctx, updates, aw)
+	}, func(error) {
+		cancel()
+	})
+	g.Add(func() error {
+		for c := range updates {
+			if err := webhandler.SetAccessPolicies(c); err != nil {
+				level.Error(logger).Log("msg", "failed to set access policies in gateway", "err", err)
+			}
+		}
+		return nil
+	}, func(error) {
+		cancel()
+	})
+	return nil
+}
+
+// runHashringsConfig keeps the hashrings of the ingesters up to date, if they are given by a file.
+func runHashringsConfig(g *run.Group, logger log.Logger, reg *prometheus.Registry, conf *gatewayConfig, webhandler *monitoringgateway.Handler) error {
+	if conf.hashringsFilePath == "" {
+		return nil
+	}
+
+	hw, err := monitoringgateway.NewHashringsConfigWatcher(log.With(logger, "component", "hashrings-config-watcher"), reg, conf.hashringsFilePath, *conf.hashringsRefreshInterval)
+	if err != nil {
+		return errors.Wrap(err, "failed to initialize hashrings config watcher")
+	}
+	if err := hw.ValidateConfig(); err != nil {
+		hw.Stop()
+		return errors.Wrap(err, "failed to validate hashrings configuration file")
+	}
+
+	updates := make(chan []monitoringgateway.HashringConfig, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	g.Add(func() error {
+		return monitoringgateway.ConfigFromWatcher(ctx, updates, hw)
+	}, func(error) {
+		cancel()
+	})
+	g.Add(func() error {
+		for c := range updates {
+			if err := webhandler.SetHashrings(c); err != nil {
+				level.Error(logger).Log("msg", "failed to set hashrings in gateway", "err", err)
+			}
+		}
+		return nil
+	}, func(error) {
+		cancel()
+	})
+	return nil
+}
+
 func (gc *gatewayConfig) registerFlag(cmd extkingpin.FlagClause) {
 	gc.httpBindAddr, gc.httpGracePeriod, gc.httpTLSConfig = monitoringgateway.RegisterHTTPFlags(cmd)
 
 	cmd.Flag("debug.enable-ui", "If true, Gateway will proxy and expose Thanos Query UI for debugging.").Default("false").BoolVar(&gc.debugEnabledUI)
 
+	cmd.Flag("gateway.config-file", "Path to YAML file that contains the downstreams, the external remote-write targets and the tenant settings. A watcher is initialized to watch changes and apply them dynamically. If set, the flags of these settings are ignored.").PlaceHolder("<path>").StringVar(&gc.gatewayConfigFilePath)
+	cmd.Flag("gateway.config", "Alternative to 'gateway.config-file' flag (lower priority). Content of YAML file that contains the downstreams, the external remote-write targets and the tenant settings.").PlaceHolder("<content>").StringVar(&gc.gatewayConfigContent)
+	gc.gatewayConfigRefreshInterval = extkingpin.ModelDuration(cmd.Flag("gateway.config-file-refresh-interval", "Refresh interval to re-read the gateway configuration file. (used as a fallback)").Default("1m"))
+
 	cmd.Flag("tenant.header", "HTTP header to determine tenant for write requests.").Default("WHIZARD-TENANT").StringVar(&gc.tenantHeader)
 	cmd.Flag("tenant.label-name", "Label name through which the tenant will be announced.").Default("tenant_id").StringVar(&gc.tenantLabelName)
+	cmd.Flag("tenant.label-enforcement", "How the tenant label of the series written by a tenant is enforced. 'reject' rejects requests with series whose tenant label differs from the tenant, 'overwrite' overwrites it. It applies to the resource and data point attributes of OTLP requests as well.").
+		Default(string(monitoringgateway.TenantLabelEnforcementNone)).EnumVar(&gc.tenantLabelEnforcement, string(monitoringgateway.TenantLabelEnforcementNone), string(monitoringgateway.TenantLabelEnforcementReject), string(monitoringgateway.TenantLabelEnforcementOverwrite))
+	cmd.Flag("auth.client-cert", "If true, requests of a tenant are authenticated by the common name of the client certificate, which must equal the tenant.").Default("false").BoolVar(&gc.authClientCert)
+	cmd.Flag("auth.oidc.issuer-url", "Issuer that bearer tokens must be issued by. Required if OIDC bearer tokens are authenticated.").Default("").StringVar(&gc.oidcConfig.IssuerURL)
+	cmd.Flag("auth.oidc.audience", "Audience that bearer tokens must be issued for, e.g. the client ID of the gateway. Required if OIDC bearer tokens are authenticated, unless 'auth.oidc.skip-audience-check' is set.").Default("").StringVar(&gc.oidcConfig.Audience)
+	cmd.Flag("auth.oidc.skip-audience-check", "If true, the audience of bearer tokens is not verified, so that tokens issued by the issuer for any client are accepted.").Default("false").BoolVar(&gc.oidcConfig.SkipAudienceCheck)
+	cmd.Flag("auth.oidc.jwks-file", "Path to the JSON Web Key Set of the issuer. If this or 'auth.oidc.jwks-url' is set, requests of a tenant are authenticated by OIDC bearer tokens.").PlaceHolder("<path>").StringVar(&gc.oidcConfig.JWKSFile)
+	cmd.Flag("auth.oidc.jwks-url", "Alternative to 'auth.oidc.jwks-file' flag (lower priority). URL of the JSON Web Key Set of the issuer.").PlaceHolder("<url>").StringVar(&gc.oidcConfig.JWKSURL)
+	cmd.Flag("auth.oidc.tenant-claim", "Claim of the bearer token that holds the tenant, or the list of tenants, the caller is allowed to access.").Default("tenants").StringVar(&gc.oidcConfig.TenantClaim)
+	cmd.Flag("auth.oidc.username-claim", "Claim of the bearer token that identifies the caller.").Default("sub").StringVar(&gc.oidcConfig.UsernameClaim)
+	cmd.Flag("auth.kubernetes", "If true, bearer tokens, e.g. of ServiceAccounts, are authenticated by the Kubernetes TokenReview API, and the access to a tenant is authorized by a SubjectAccessReview of the verb get for reads and create for writes on the tenant as object of the resource given by 'auth.kubernetes.resource-group' and 'auth.kubernetes.resource'.").Default("false").BoolVar(&gc.authKubernetes)
+	cmd.Flag("auth.kubernetes.audiences", "Audiences that bearer tokens must be issued for (repeatable). If empty, the audience of the Kubernetes API server is expected.").StringsVar(&gc.kubernetesAuthConfig.Audiences)
+	cmd.Flag("auth.kubernetes.resource-group", "API group of the virtual resource whose objects are the tenants in SubjectAccessReviews.").Default("monitoring.whizard.io").StringVar(&gc.kubernetesAuthConfig.ResourceGroup)
+	cmd.Flag("auth.kubernetes.resource", "Virtual resource whose objects are the tenants in SubjectAccessReviews.").Default("tenants").StringVar(&gc.kubernetesAuthConfig.Resource)
+	cmd.Flag("auth.kubernetes.cache-ttl", "How long the results of TokenReviews and SubjectAccessReviews are cached. 0 disables the cache.").Default("1m").DurationVar(&gc.kubernetesAuthConfig.CacheTTL)
+
+	cmd.Flag("audit.enabled", "If true, requests of tenants are logged with the tenant, endpoint, query, time range, status, duration and response size.").Default("false").BoolVar(&gc.auditConfig.Enabled)
+	cmd.Flag("audit.sample-ratio", "Ratio of requests, between 0 and 1, that are logged to the audit log.").Default("1").Float64Var(&gc.auditConfig.SampleRatio)
+	cmd.Flag("audit.slow-query-threshold", "Requests that take at least this long are logged to the audit log regardless of the sample ratio. 0 disables it.").Default("0s").DurationVar(&gc.auditConfig.SlowQueryThreshold)
+
+	cmd.Flag("otlp.grpc-address", "Listen host:port for OTLP gRPC requests, e.g. 0.0.0.0:4317. The tenant is taken from the metadata named like the tenant header, or from the common name of the client certificate. TLS is configured by the 'http.config' flag. If empty, OTLP is served over HTTP only.").Default("").StringVar(&gc.otlpGRPCAddress)
+	cmd.Flag("grpc.max-recv-msg-size", "Maximum size of the messages received by the gRPC servers of OTLP and the StoreAPI, e.g. of OTLP export requests.").Default("32MiB").BytesVar(&gc.grpcMaxRecvMsgSize)
+	gc.otlpConfig.Translate = cmd.Flag("otlp.enable-translation", "If true, OTLP requests are translated into remote write requests in the gateway, so that they are handled like remote write requests, subject to the limits of the tenant and forwarded to the external remote-write targets as well. Otherwise they are proxied to the remote write downstream as they are, with the tenant attributes enforced only.").Default("true").Bool()
+	gc.otlpConfig.EnableTargetInfo = cmd.Flag("otlp.enable-target-info", "If true, the resource attributes of translated OTLP requests are converted into the target_info metric.").Default("true").Bool()
+	cmd.Flag("otlp.promote-resource-attributes", "Resource attributes added as labels to the series of translated OTLP requests (repeatable).").StringsVar(&gc.otlpConfig.PromoteResourceAttributes)
+
+	cmd.Flag("ha-tracker.enable", "If true, the samples of HA pairs of Prometheus are deduplicated: one replica per cluster of a tenant is elected, and the samples of the other replicas are dropped until the elected replica fails over.").Default("false").BoolVar(&gc.haTracker)
+	cmd.Flag("ha-tracker.cluster-label", "Label that identifies the cluster of a HA pair.").Default(monitoringgateway.DefaultHAClusterLabel).StringVar(&gc.haTrackerConfig.ClusterLabel)
+	cmd.Flag("ha-tracker.replica-label", "Label that identifies the replica of a HA pair. It is removed from the accepted series.").Default(monitoringgateway.DefaultHAReplicaLabel).StringVar(&gc.haTrackerConfig.ReplicaLabel)
+	cmd.Flag("ha-tracker.update-timeout", "How often the time the elected replica was last seen is updated in the shared state.").Default("15s").DurationVar(&gc.haTrackerConfig.UpdateTimeout)
+	cmd.Flag("ha-tracker.failover-timeout", "How long the elected replica may be absent before another replica is elected. It must be greater than 'ha-tracker.update-timeout'.").Default("30s").DurationVar(&gc.haTrackerConfig.FailoverTimeout)
+	cmd.Flag("ha-tracker.lease-namespace", "Namespace of the Kubernetes Leases the elected replicas are shared through between gateway replicas. If empty, the elected replicas are kept in memory, which only suits a single gateway.").Default("").StringVar(&gc.haTrackerConfig.LeaseNamespace)
+	cmd.Flag("usage.retention", "Time window the ingestion usage of tenants is kept for, which is the longest window served by the usage API.").Default("24h").DurationVar(&gc.usageRetention)
+
 	cmd.Flag("tenant.admission-control-config-file", "Path to file that contains the configuration. A watcher is initialized to watch changes and update the dynamically.").PlaceHolder("<path>").StringVar(&gc.tenantsFilePath)
 	cmd.Flag("tenant.admission-control-config", "Alternative to 'tenant.admission-control-config-file' flag (lower priority). Content of file that contains the configuration.").PlaceHolder("<content>").StringVar(&gc.tenantsFileContent)
 	gc.refreshInterval = extkingpin.ModelDuration(cmd.Flag("tenant.admission-control-config-file-refresh-interval", "Refresh interval to re-read the configuration file. (used as a fallback)").Default("1m"))
+	cmd.Flag("tenant.admission-control-service", "Service, given as <namespace>.<name>, whose Tenant objects are admitted. If set, the Tenant objects are watched in Kubernetes instead of reading the configuration file, so that tenants are admitted as soon as they are created.").PlaceHolder("<service>").StringVar(&gc.tenantsService)
+
+	cmd.Flag("tenant.limits-config-file", "Path to YAML file that contains the default and per-tenant limits, such as ingestion and query limits. A watcher is initialized to watch changes and update them dynamically.").PlaceHolder("<path>").StringVar(&gc.limitsFilePath)
+	cmd.Flag("tenant.limits-config", "Alternative to 'tenant.limits-config-file' flag (lower priority). Content of YAML file that contains the default and per-tenant limits.").PlaceHolder("<content>").StringVar(&gc.limitsFileContent)
+	gc.limitsRefreshInterval = extkingpin.ModelDuration(cmd.Flag("tenant.limits-config-file-refresh-interval", "Refresh interval to re-read the limits configuration file. (used as a fallback)").Default("1m"))
+
+	cmd.Flag("tenant.access-policy-config-file", "Path to YAML file that contains the access policies, which restrict the series an identity may query within a tenant by label matchers, e.g. namespace=~\"team-a-.*\". A watcher is initialized to watch changes and update them dynamically.").PlaceHolder("<path>").StringVar(&gc.accessPolicyFilePath)
+	cmd.Flag("tenant.access-policy-config", "Alternative to 'tenant.access-policy-config-file' flag (lower priority). Content of YAML file that contains the access policies.").PlaceHolder("<content>").StringVar(&gc.accessPolicyFileContent)
+	gc.accessPolicyRefreshInterval = extkingpin.ModelDuration(cmd.Flag("tenant.access-policy-config-file-refresh-interval", "Refresh interval to re-read the access policy configuration file. (used as a fallback)").Default("1m"))
+
+	cmd.Flag("ingester.hashrings-file", "Path to the hashrings configuration file of the router, e.g. mounted from its hashrings ConfigMap. The ingesters of a tenant are looked up in it to serve the TSDB status of the tenant. A watcher is initialized to watch changes and update them dynamically.").PlaceHolder("<path>").StringVar(&gc.hashringsFilePath)
+	gc.hashringsRefreshInterval = extkingpin.ModelDuration(cmd.Flag("ingester.hashrings-file-refresh-interval", "Refresh interval to re-read the hashrings configuration file. (used as a fallback)").Default("1m"))
 
 	gc.ExternalRemoteWrites.ConfigPathOrContent = *extflag.RegisterPathOrContent(cmd, "external-remote-writes.config", "Path to YAML config for the external remote-write configurations, that specify servers where received remote-write requests should be forwarded to.", extflag.WithEnvSubstitution())
+	cmd.Flag("external-remote-writes.wal-dir", "Directory to persist the requests queued for the external remote-write targets, so that they are sent after a restart. If empty, queued requests are kept in memory only.").PlaceHolder("<path>").StringVar(&gc.ExternalRemoteWrites.WALDir)
 
 	gc.queryConfig.RegisterFlag(cmd)
 	gc.rulesQueryConfig.RegisterFlag(cmd)
+	gc.alertmanagerConfig.RegisterFlag(cmd)
 	gc.remoteWriteConfig.RegisterFlag(cmd)
+	gc.storeConfig.RegisterFlag(cmd)
+	cmd.Flag("store-api.grpc-address", "Listen host:port for StoreAPI requests, e.g. 0.0.0.0:10901. The StoreAPI of the 'store.address' flag is served to authenticated callers for their tenants only. TLS is configured by the 'http.config' flag. If empty, the StoreAPI is not served.").Default("").StringVar(&gc.storeAPIGRPCAddress)
 }
 
 var (
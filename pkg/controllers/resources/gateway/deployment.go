@@ -5,6 +5,7 @@ import (
 	"encoding/hex"
 	"fmt"
 	"net/url"
+	"path/filepath"
 	"reflect"
 	"time"
 
@@ -46,10 +47,11 @@ func (g *Gateway) deployment() (runtime.Object, resources.Operation, error) {
 				Labels: g.labels(),
 			},
 			Spec: corev1.PodSpec{
-				NodeSelector:    g.gateway.Spec.NodeSelector,
-				Tolerations:     g.gateway.Spec.Tolerations,
-				Affinity:        g.gateway.Spec.Affinity,
-				SecurityContext: g.gateway.Spec.SecurityContext,
+				ServiceAccountName: g.name(),
+				NodeSelector:       g.gateway.Spec.NodeSelector,
+				Tolerations:        g.gateway.Spec.Tolerations,
+				Affinity:           g.gateway.Spec.Affinity,
+				SecurityContext:    g.gateway.Spec.SecurityContext,
 			},
 		},
 	}
@@ -108,6 +110,14 @@ func (g *Gateway) deployment() (runtime.Object, resources.Operation, error) {
 		container.Args = append(container.Args, "--tenant.label-name="+g.Service.Spec.TenantLabelName)
 	}
 
+	// The OTLP requests are translated by the gateway, so it converts them like the ingesters would.
+	if g.Service.Spec.IngesterTemplateSpec.OtlpEnableTargetInfo != nil && !*g.Service.Spec.IngesterTemplateSpec.OtlpEnableTargetInfo {
+		container.Args = append(container.Args, "--no-otlp.enable-target-info")
+	}
+	for _, attr := range g.Service.Spec.IngesterTemplateSpec.OtlpResourceAttributes {
+		container.Args = append(container.Args, "--otlp.promote-resource-attributes="+attr)
+	}
+
 	if g.gateway.Spec.DebugMode {
 		container.Args = append(container.Args, "--debug.enable-ui")
 	}
@@ -237,12 +247,32 @@ func (g *Gateway) deployment() (runtime.Object, resources.Operation, error) {
 	}
 
 	// write to router
-	routerAddr, err := g.remoteWriteAddress()
+	r, err := g.router()
 	if err != nil {
 		return nil, "", err
 	}
+	routerAddr := r.RemoteWriteAddr()
 	container.Args = append(container.Args, fmt.Sprintf("--remote-write.address=%s", routerAddr))
 
+	// look up the ingesters of the tenants in the hashrings of the router
+	hashringsVolume := corev1.Volume{
+		Name: "hashrings-config",
+		VolumeSource: corev1.VolumeSource{
+			ConfigMap: &corev1.ConfigMapVolumeSource{
+				LocalObjectReference: corev1.LocalObjectReference{
+					Name: r.HashringsConfigMapName(),
+				},
+			},
+		},
+	}
+	d.Spec.Template.Spec.Volumes = append(d.Spec.Template.Spec.Volumes, hashringsVolume)
+	container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+		Name:      hashringsVolume.Name,
+		MountPath: hashringsConfigDir,
+		ReadOnly:  true,
+	})
+	container.Args = append(container.Args, "--ingester.hashrings-file="+filepath.Join(hashringsConfigDir, router.HashringsFile))
+
 	url, err := url.Parse(routerAddr)
 	if err != nil {
 		return nil, "", fmt.Errorf("invalid router address: %s", queryAddr)
@@ -371,27 +401,22 @@ func (g *Gateway) queryAddress() (string, error) {
 	return "", nil
 }
 
-func (g *Gateway) remoteWriteAddress() (string, error) {
+func (g *Gateway) router() (*router.Router, error) {
 	routerList := &v1alpha1.RouterList{}
 	if err := g.Client.List(g.Context, routerList, client.MatchingLabels(util.ManagedLabelBySameService(g.gateway))); err != nil {
-		return "", err
+		return nil, err
 	}
 
 	if len(routerList.Items) > 0 {
 		if len(routerList.Items) > 1 {
-			return "", fmt.Errorf("more than one router defined for service %s/%s", g.Service.Name, g.Service.Namespace)
+			return nil, fmt.Errorf("more than one router defined for service %s/%s", g.Service.Name, g.Service.Namespace)
 		}
 
 		o := routerList.Items[0]
-		r, err := router.New(g.BaseReconciler, &o)
-		if err != nil {
-			return "", err
-		}
-
-		return r.RemoteWriteAddr(), nil
+		return router.New(g.BaseReconciler, &o)
 	}
 
-	return "", fmt.Errorf("no router defined for service %s/%s", g.Service.Name, g.Service.Namespace)
+	return nil, fmt.Errorf("no router defined for service %s/%s", g.Service.Name, g.Service.Namespace)
 }
 
 type config struct {
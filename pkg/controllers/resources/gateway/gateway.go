@@ -11,7 +11,8 @@ import (
 )
 
 const (
-	secretsDir = "/etc/gateway/secrets"
+	secretsDir         = "/etc/gateway/secrets"
+	hashringsConfigDir = "/etc/whizard/hashrings"
 )
 
 type Gateway struct {
@@ -69,6 +70,9 @@ func (g *Gateway) HttpsAddr() string {
 
 func (g *Gateway) Reconcile() error {
 	return g.ReconcileResources([]resources.Resource{
+		g.serviceAccount,
+		g.clusterRole,
+		g.clusterRoleBinding,
 		g.deployment,
 		g.service,
 		g.tenantsAdmissionConfigMap,
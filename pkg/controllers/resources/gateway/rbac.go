@@ -0,0 +1,69 @@
+package gateway
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	"github.com/WhizardTelemetry/whizard/pkg/api/monitoring/v1alpha1"
+	"github.com/WhizardTelemetry/whizard/pkg/controllers/resources"
+)
+
+// clusterRoleName returns the name of the ClusterRole of the gateway, which is cluster-scoped and so qualified with
+// the namespace of the gateway.
+func (g *Gateway) clusterRoleName() string {
+	return fmt.Sprintf("%s-%s", g.Service.Namespace, g.name())
+}
+
+func (g *Gateway) serviceAccount() (runtime.Object, resources.Operation, error) {
+	var sa = &corev1.ServiceAccount{ObjectMeta: g.meta(g.name())}
+
+	if g.gateway == nil {
+		return sa, resources.OperationDelete, nil
+	}
+
+	return sa, resources.OperationCreateOrUpdate, ctrl.SetControllerReference(g.gateway, sa, g.Scheme)
+}
+
+// clusterRole allows the gateway to watch the Tenant objects, which are cluster-scoped, to admit tenants by the
+// tenant informer.
+func (g *Gateway) clusterRole() (runtime.Object, resources.Operation, error) {
+	var role = &rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: g.clusterRoleName(), Labels: g.labels()}}
+
+	if g.gateway == nil {
+		return role, resources.OperationDelete, nil
+	}
+
+	role.Rules = []rbacv1.PolicyRule{{
+		APIGroups: []string{v1alpha1.GroupVersion.Group},
+		Resources: []string{"tenants"},
+		Verbs:     []string{"get", "list", "watch"},
+	}}
+
+	return role, resources.OperationCreateOrUpdate, nil
+}
+
+func (g *Gateway) clusterRoleBinding() (runtime.Object, resources.Operation, error) {
+	var binding = &rbacv1.ClusterRoleBinding{ObjectMeta: metav1.ObjectMeta{Name: g.clusterRoleName(), Labels: g.labels()}}
+
+	if g.gateway == nil {
+		return binding, resources.OperationDelete, nil
+	}
+
+	binding.RoleRef = rbacv1.RoleRef{
+		APIGroup: rbacv1.GroupName,
+		Kind:     "ClusterRole",
+		Name:     g.clusterRoleName(),
+	}
+	binding.Subjects = []rbacv1.Subject{{
+		Kind:      rbacv1.ServiceAccountKind,
+		Name:      g.name(),
+		Namespace: g.Service.Namespace,
+	}}
+
+	return binding, resources.OperationCreateOrUpdate, nil
+}
@@ -21,7 +21,7 @@ import (
 
 func (r *Router) hashringsConfigMap() (runtime.Object, resources.Operation, error) {
 
-	var cm = &corev1.ConfigMap{ObjectMeta: r.meta(r.name("hashrings-config"))}
+	var cm = &corev1.ConfigMap{ObjectMeta: r.meta(r.HashringsConfigMapName())}
 
 	if r.router == nil {
 		return cm, resources.OperationDelete, nil
@@ -83,7 +83,7 @@ func (r *Router) hashringsConfigMap() (runtime.Object, resources.Operation, erro
 		return nil, resources.OperationCreateOrUpdate, err
 	}
 	cm.Data = map[string]string{
-		hashringsFile: string(hashringBytes),
+		HashringsFile: string(hashringBytes),
 	}
 
 	return cm, resources.OperationCreateOrUpdate, ctrl.SetControllerReference(r.router, cm, r.Scheme)
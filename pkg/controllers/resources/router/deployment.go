@@ -64,7 +64,7 @@ func (r *Router) deployment() (runtime.Object, resources.Operation, error) {
 		VolumeSource: corev1.VolumeSource{
 			ConfigMap: &corev1.ConfigMapVolumeSource{
 				LocalObjectReference: corev1.LocalObjectReference{
-					Name: r.name("hashrings-config"),
+					Name: r.HashringsConfigMapName(),
 				},
 			},
 		},
@@ -148,7 +148,7 @@ func (r *Router) deployment() (runtime.Object, resources.Operation, error) {
 		container.Args = append(container.Args, "--log.format="+r.router.Spec.LogFormat)
 	}
 	container.Args = append(container.Args, fmt.Sprintf("--label=%s=\"$(POD_NAME)\"", constants.ReceiveReplicaLabelName))
-	container.Args = append(container.Args, "--receive.hashrings-file="+filepath.Join(configDir, hashringsFile))
+	container.Args = append(container.Args, "--receive.hashrings-file="+filepath.Join(configDir, HashringsFile))
 	if r.router.Spec.ReplicationFactor != nil {
 		container.Args = append(container.Args, fmt.Sprintf("--receive.replication-factor=%d", *r.router.Spec.ReplicationFactor))
 	}
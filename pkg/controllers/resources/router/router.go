@@ -12,7 +12,7 @@ import (
 
 const (
 	configDir       = "/etc/whizard"
-	hashringsFile   = "hashrings.json"
+	HashringsFile   = "hashrings.json"
 	envoyConfigFile = "envoy.yaml"
 )
 
@@ -66,6 +66,11 @@ func (r *Router) RemoteWriteHTTPSAddr() string {
 		r.name(constants.ServiceNameSuffix), r.Service.Namespace, constants.RemoteWritePort)
 }
 
+// HashringsConfigMapName returns the name of the ConfigMap that holds the hashrings file of the router.
+func (r *Router) HashringsConfigMapName() string {
+	return r.name("hashrings-config")
+}
+
 func (r *Router) Reconcile() error {
 	return r.ReconcileResources([]resources.Resource{
 		r.hashringsConfigMap,
@@ -64,9 +64,7 @@ func NewServer(logger log.Logger, opt *Options) *Server {
 	s.router.Get(labels, s.wrap())
 	s.router.Get(labelValues, s.wrap())
 	s.router.Get(rules, s.wrap())
-	// do provide /api/v1/alerts because thanos does not support alerts filtering as of v0.28.0
-	// please filtering alerts by /api/v1/rules
-	// s.router.Get(alerts, s.wrap(alerts))
+	s.router.Get(alerts, s.wrap())
 
 	s.router.Post(receive, s.wrap())
 	s.router.Post(otlp, s.wrap())
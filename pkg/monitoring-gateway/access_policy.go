@@ -0,0 +1,139 @@
+package monitoringgateway
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql/parser"
+	"gopkg.in/yaml.v2"
+)
+
+// AccessPolicy restricts the series its subjects may access within tenants to the series selected by its matchers.
+type AccessPolicy struct {
+	Name string `yaml:"name"`
+	// Subjects are the names of the identities the policy applies to, e.g. the subjects of tokens.
+	Subjects []string `yaml:"subjects"`
+	// Tenants are the tenants the policy applies to. The policy applies to all tenants if it is empty.
+	Tenants []string `yaml:"tenants,omitempty"`
+	// Matchers are the label matchers enforced on the queries of the subjects, e.g. namespace=~"team-a-.*".
+	Matchers []string `yaml:"matchers"`
+
+	matchers []*labels.Matcher
+}
+
+// AccessPolicyConfig holds the access policies. The first policy that applies to an identity and a tenant is
+// enforced, identities without policy for a tenant access all of its series.
+type AccessPolicyConfig struct {
+	Policies []AccessPolicy `yaml:"policies,omitempty"`
+}
+
+// ParseAccessPolicyConfig parses the raw access policy configuration content and returns an AccessPolicyConfig.
+func ParseAccessPolicyConfig(content []byte) (AccessPolicyConfig, error) {
+	var config AccessPolicyConfig
+	if err := yaml.UnmarshalStrict(content, &config); err != nil {
+		return AccessPolicyConfig{}, errors.Wrap(err, "parsing access policy config YAML")
+	}
+	for i := range config.Policies {
+		if err := config.Policies[i].parse(); err != nil {
+			return AccessPolicyConfig{}, errors.Wrapf(err, "parsing access policy %s", config.Policies[i].Name)
+		}
+	}
+	return config, nil
+}
+
+func (p *AccessPolicy) parse() error {
+	if p.Name == "" {
+		return errors.New("the name must be set")
+	}
+	if len(p.Subjects) == 0 {
+		return errors.New("at least one subject must be set")
+	}
+	if len(p.Matchers) == 0 {
+		return errors.New("at least one matcher must be set")
+	}
+
+	p.matchers = p.matchers[:0]
+	for _, s := range p.Matchers {
+		m, err := parser.ParseMetricSelector("{" + s + "}")
+		if err != nil {
+			return errors.Wrapf(err, "parsing matcher %s", s)
+		}
+		if len(m) != 1 {
+			return errors.Errorf("matcher %s must be a single label matcher", s)
+		}
+		// The matchers are enforced by label name, so a label may only be matched once.
+		if slices.ContainsFunc(p.matchers, func(pm *labels.Matcher) bool { return pm.Name == m[0].Name }) {
+			return errors.Errorf("label %s is matched more than once", m[0].Name)
+		}
+		p.matchers = append(p.matchers, m[0])
+	}
+	return nil
+}
+
+func (p *AccessPolicy) appliesTo(subject, tenant string) bool {
+	return slices.Contains(p.Subjects, subject) && (len(p.Tenants) == 0 || slices.Contains(p.Tenants, tenant))
+}
+
+// policyMatchers returns the matchers enforced on the queries of the identity to the tenants.
+// All tenants of a tenant set must be accessed under the same policy, as the matchers apply to all of their series.
+func (c *AccessPolicyConfig) policyMatchers(identity *Identity, tenants []string) ([]*labels.Matcher, error) {
+	if c == nil || identity == nil {
+		return nil, nil
+	}
+
+	var policy *AccessPolicy
+	for i, tenant := range tenants {
+		var p *AccessPolicy
+		for j := range c.Policies {
+			if c.Policies[j].appliesTo(identity.Name, tenant) {
+				p = &c.Policies[j]
+				break
+			}
+		}
+		if i > 0 && p != policy {
+			return nil, fmt.Errorf("%s accesses the tenants %s under different access policies", identity.Name, strings.Join(tenants, tenantSetSeparator))
+		}
+		policy = p
+	}
+	if policy == nil {
+		return nil, nil
+	}
+	return policy.matchers, nil
+}
+
+// NewAccessPolicyConfigWatcher creates a new ConfigWatcher for the access policy configuration.
+func NewAccessPolicyConfigWatcher(logger log.Logger, reg prometheus.Registerer, path string, interval model.Duration) (*ConfigWatcher[AccessPolicyConfig], error) {
+	return newConfigWatcher(logger, reg, "whizard_gateway_access_policy_config", path, interval, ParseAccessPolicyConfig)
+}
+
+// SetAccessPolicies replaces the access policies enforced by the gateway.
+func (h *Handler) SetAccessPolicies(c AccessPolicyConfig) error {
+	tenantLabelName := h.upstreams.Load().tenantLabelName
+	for _, p := range c.Policies {
+		for _, m := range p.matchers {
+			if m.Name == tenantLabelName {
+				return errors.Errorf("access policy %s must not match the tenant label %s", p.Name, tenantLabelName)
+			}
+		}
+	}
+	level.Info(h.logger).Log("msg", "updating access policies", "policies", len(c.Policies))
+	h.accessPolicies.Store(&c)
+	return nil
+}
+
+// queryMatchers returns the matchers enforced on the queries of the request, that is the tenant matcher and the
+// matchers of the access policy of the caller.
+func (h *Handler) queryMatchers(requestInfo *RequestInfo) ([]*labels.Matcher, error) {
+	ms, err := h.accessPolicies.Load().policyMatchers(requestInfo.Identity, requestInfo.Tenants)
+	if err != nil {
+		return nil, err
+	}
+	return append([]*labels.Matcher{h.tenantMatcher(requestInfo.Tenants)}, ms...), nil
+}
@@ -0,0 +1,170 @@
+package monitoringgateway
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestAccessPolicyQuery(t *testing.T) {
+	var gotQuery, gotMatch string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		gotQuery, gotMatch = req.URL.Query().Get("query"), req.URL.Query().Get("match[]")
+	}))
+	defer upstream.Close()
+
+	upstreamURL, _ := url.Parse(upstream.URL)
+	h := NewHandler(nil, prometheus.NewRegistry(), &Options{
+		TenantLabelName: "tenant_id",
+		QueryProxy:      NewSingleHostReverseProxy(upstreamURL, http.DefaultTransport),
+		Authenticator: tokenAuthenticator{
+			"Bearer alice": {Name: "alice", Tenants: []string{"a", "b"}},
+			"Bearer bob":   {Name: "bob", Tenants: []string{"a"}},
+		},
+	})
+
+	c, err := ParseAccessPolicyConfig([]byte(`
+policies:
+- name: team-a
+  subjects: [alice]
+  tenants: [a]
+  matchers: ['namespace=~"team-a-.*"']
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.SetAccessPolicies(c); err != nil {
+		t.Fatal(err)
+	}
+
+	serve := func(token, target string) int {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.Header.Set("Authorization", token)
+		rec := httptest.NewRecorder()
+		h.Router().ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := serve("Bearer alice", `/a/api/v1/query?query=up{namespace="team-b-x"}`); code != http.StatusOK {
+		t.Fatalf("unexpected status %d", code)
+	}
+	if want := `up{namespace="team-b-x",namespace=~"team-a-.*",tenant_id="a"}`; gotQuery != want {
+		t.Fatalf("expected query %s, got %s", want, gotQuery)
+	}
+	if code := serve("Bearer alice", `/a/api/v1/series?match[]=up`); code != http.StatusOK {
+		t.Fatalf("unexpected status %d", code)
+	}
+	if want := `{__name__="up",tenant_id="a",namespace=~"team-a-.*"}`; gotMatch != want {
+		t.Fatalf("expected matcher %s, got %s", want, gotMatch)
+	}
+
+	// Other subjects and tenants are not restricted.
+	if code := serve("Bearer bob", `/a/api/v1/query?query=up`); code != http.StatusOK {
+		t.Fatalf("unexpected status %d", code)
+	}
+	if want := `up{tenant_id="a"}`; gotQuery != want {
+		t.Fatalf("expected query %s, got %s", want, gotQuery)
+	}
+	if code := serve("Bearer alice", `/b/api/v1/query?query=up`); code != http.StatusOK {
+		t.Fatalf("unexpected status %d", code)
+	}
+	if want := `up{tenant_id="b"}`; gotQuery != want {
+		t.Fatalf("expected query %s, got %s", want, gotQuery)
+	}
+
+	if code := serve("Bearer alice", `/a|b/api/v1/query?query=up`); code != http.StatusForbidden {
+		t.Fatalf("expected status %d for tenants under different policies, got %d", http.StatusForbidden, code)
+	}
+
+	for _, content := range []string{
+		"policies: [{name: a, subjects: [alice]}]",
+		"policies: [{name: a, subjects: [alice], matchers: ['namespace=\"a\"', 'namespace=\"b\"']}]",
+		"policies: [{name: a, subjects: [alice], matchers: ['up']}]",
+	} {
+		if _, err := ParseAccessPolicyConfig([]byte(content)); err == nil {
+			t.Fatalf("expected error for %q", content)
+		}
+	}
+	c, _ = ParseAccessPolicyConfig([]byte("policies: [{name: a, subjects: [alice], matchers: ['tenant_id=\"b\"']}]"))
+	if err := h.SetAccessPolicies(c); err == nil {
+		t.Fatal("expected error for a policy matching the tenant label")
+	}
+}
+
+func TestAccessPolicyRulesAndAlerts(t *testing.T) {
+	rules := `{"status":"success","data":{"groups":[{"name":"g","rules":[
+		{"type":"recording","name":"r","labels":{"tenant_id":"a"}},
+		{"type":"alerting","name":"A","labels":{"tenant_id":"a"},"alerts":[
+			{"labels":{"alertname":"A","tenant_id":"a","namespace":"team-a-x"},"annotations":{},"state":"firing","value":"1"},
+			{"labels":{"alertname":"A","tenant_id":"a","namespace":"team-b-x"},"annotations":{},"state":"firing","value":"1"}
+		]},
+		{"type":"alerting","name":"B","labels":{"tenant_id":"a"},"alerts":[
+			{"labels":{"alertname":"B","tenant_id":"a","namespace":"team-b-x"},"annotations":{},"state":"firing","value":"1"}
+		]}
+	]}]}}`
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		_, _ = w.Write([]byte(rules))
+	}))
+	defer upstream.Close()
+
+	upstreamURL, _ := url.Parse(upstream.URL)
+	h := NewHandler(nil, prometheus.NewRegistry(), &Options{
+		TenantLabelName: "tenant_id",
+		QueryProxy:      NewSingleHostReverseProxy(upstreamURL, http.DefaultTransport),
+		Authenticator:   tokenAuthenticator{"Bearer alice": {Name: "alice", Tenants: []string{"a"}}},
+	})
+	c, err := ParseAccessPolicyConfig([]byte(`
+policies:
+- name: team-a
+  subjects: [alice]
+  tenants: [a]
+  matchers: ['namespace=~"team-a-.*"']
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.SetAccessPolicies(c); err != nil {
+		t.Fatal(err)
+	}
+
+	serve := func(target string, v interface{}) {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.Header.Set("Authorization", "Bearer alice")
+		rec := httptest.NewRecorder()
+		h.Router().ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("unexpected status %d for %s: %s", rec.Code, target, rec.Body)
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var alerts alertsResponse
+	serve("/a/api/v1/alerts", &alerts)
+	if len(alerts.Data.Alerts) != 1 || alerts.Data.Alerts[0].Labels["namespace"] != "team-a-x" {
+		t.Fatalf("expected the alert of namespace team-a-x only, got %+v", alerts.Data.Alerts)
+	}
+
+	var res struct {
+		Data struct {
+			Groups []struct {
+				Rules []struct {
+					Name   string  `json:"name"`
+					Alerts []alert `json:"alerts"`
+				} `json:"rules"`
+			} `json:"groups"`
+		} `json:"data"`
+	}
+	serve("/a/api/v1/rules", &res)
+	if len(res.Data.Groups) != 1 || len(res.Data.Groups[0].Rules) != 1 {
+		t.Fatalf("expected only the rule with an alert of namespace team-a-x, got %+v", res.Data.Groups)
+	}
+	if r := res.Data.Groups[0].Rules[0]; r.Name != "A" || len(r.Alerts) != 1 || r.Alerts[0].Labels["namespace"] != "team-a-x" {
+		t.Fatalf("unexpected rule %+v", r)
+	}
+}
@@ -0,0 +1,223 @@
+package monitoringgateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/go-kit/log/level"
+	"github.com/gorilla/mux"
+	"github.com/prometheus/prometheus/model/labels"
+)
+
+const (
+	alertmanagerPrefix          = "/alertmanager"
+	apiAlertmanagerPrefix       = "/api/v2"
+	apiTenantAlertmanagerPrefix = "/{tenant_id}" + alertmanagerPrefix + apiAlertmanagerPrefix
+
+	epAMAlerts      = "/alerts"
+	epAMAlertGroups = "/alerts/groups"
+	epAMSilences    = "/silences"
+	epAMSilence     = "/silence/{silence_id}"
+
+	filterParam = "filter"
+)
+
+// silenceMatcher is a matcher of a silence in the Alertmanager API.
+type silenceMatcher struct {
+	Name    string `json:"name"`
+	Value   string `json:"value"`
+	IsRegex bool   `json:"isRegex"`
+	// IsEqual defaults to true.
+	IsEqual *bool `json:"isEqual,omitempty"`
+}
+
+func (m silenceMatcher) equals(lm *labels.Matcher) bool {
+	isEqual := m.IsEqual == nil || *m.IsEqual
+	return m.Name == lm.Name && m.Value == lm.Value &&
+		m.IsRegex == (lm.Type == labels.MatchRegexp || lm.Type == labels.MatchNotRegexp) &&
+		isEqual == (lm.Type == labels.MatchEqual || lm.Type == labels.MatchRegexp)
+}
+
+// silence has the fields of a silence in the Alertmanager API that tenants are checked by.
+type silence struct {
+	ID       string           `json:"id,omitempty"`
+	Matchers []silenceMatcher `json:"matchers"`
+}
+
+// belongsTo reports whether the silence belongs to one of the tenants, that is whether it has an equal matcher of the
+// tenant label with one of the tenants, and whether it has all the enforced access policy matchers.
+func (s *silence) belongsTo(tenantLabelName string, tenants []string, policyMatchers []*labels.Matcher) bool {
+	if !slices.ContainsFunc(tenants, func(tenant string) bool {
+		return slices.ContainsFunc(s.Matchers, func(m silenceMatcher) bool {
+			return m.equals(labels.MustNewMatcher(labels.MatchEqual, tenantLabelName, tenant))
+		})
+	}) {
+		return false
+	}
+	for _, pm := range policyMatchers {
+		if !slices.ContainsFunc(s.Matchers, func(m silenceMatcher) bool { return m.equals(pm) }) {
+			return false
+		}
+	}
+	return true
+}
+
+// addTenantAlertmanagerHandler adds the handlers of the Alertmanager API of a tenant. Alerts and silences are filtered
+// by the tenant matcher, and silences can only be created, updated and expired if they have the tenant matcher.
+func (h *Handler) addTenantAlertmanagerHandler() {
+	h.router.Path(apiTenantAlertmanagerPrefix + epAMAlerts).Methods(http.MethodGet).HandlerFunc(h.wrap(h.alertmanagerRead))
+	h.router.Path(apiTenantAlertmanagerPrefix + epAMAlertGroups).Methods(http.MethodGet).HandlerFunc(h.wrap(h.alertmanagerRead))
+	h.router.Path(apiTenantAlertmanagerPrefix + epAMSilences).Methods(http.MethodGet).HandlerFunc(h.wrap(h.alertmanagerRead))
+	h.router.Path(apiTenantAlertmanagerPrefix + epAMSilences).Methods(http.MethodPost).HandlerFunc(h.wrap(withSingleTenant(h.postSilence)))
+	h.router.Path(apiTenantAlertmanagerPrefix + epAMSilence).Methods(http.MethodGet).HandlerFunc(h.wrap(h.silenceByID))
+	h.router.Path(apiTenantAlertmanagerPrefix + epAMSilence).Methods(http.MethodDelete).HandlerFunc(h.wrap(withSingleTenant(h.silenceByID)))
+}
+
+// alertmanagerProxy returns the proxy to the Alertmanager and strips the prefix of the Alertmanager API from the path
+// of the request, or responds with an error if the Alertmanager is not configured.
+func (h *Handler) alertmanagerProxy(w http.ResponseWriter, req *http.Request) (*httputil.ReverseProxy, bool) {
+	proxy := h.upstreams.Load().alertmanagerProxy
+	if proxy == nil {
+		http.Error(w, "The alertmanager target is not configured for the server", http.StatusNotAcceptable)
+		return nil, false
+	}
+	req.URL.Path = strings.TrimPrefix(req.URL.Path, alertmanagerPrefix)
+	return proxy, true
+}
+
+// alertmanagerRead adds the tenant matcher and the access policy matchers of the caller to the filters of alerts and
+// silences.
+func (h *Handler) alertmanagerRead(w http.ResponseWriter, req *http.Request) {
+	proxy, ok := h.alertmanagerProxy(w, req)
+	if !ok {
+		return
+	}
+
+	requestInfo, _ := requestInfoFrom(req.Context())
+	matchers, err := h.queryMatchers(requestInfo)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	q := req.URL.Query()
+	for _, m := range matchers {
+		q.Add(filterParam, m.String())
+	}
+	req.URL.RawQuery = q.Encode()
+	proxy.ServeHTTP(w, req)
+}
+
+func (h *Handler) postSilence(w http.ResponseWriter, req *http.Request) {
+	proxy, ok := h.alertmanagerProxy(w, req)
+	if !ok {
+		return
+	}
+
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var s silence
+	if err := json.Unmarshal(body, &s); err != nil {
+		http.Error(w, fmt.Sprintf("decoding silence: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	requestInfo, _ := requestInfoFrom(req.Context())
+	policyMatchers, err := h.accessPolicies.Load().policyMatchers(requestInfo.Identity, requestInfo.Tenants)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	tenantLabelName := h.upstreams.Load().tenantLabelName
+	if !s.belongsTo(tenantLabelName, requestInfo.Tenants, policyMatchers) {
+		err := fmt.Errorf("the silence must have the matcher %s=%q and the matchers of the access policy", tenantLabelName, requestInfo.TenantId)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// A silence is updated by its ID, so the existing silence must belong to the tenant as well.
+	if s.ID != "" && !h.checkSilence(w, req, proxy, s.ID, policyMatchers) {
+		return
+	}
+
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.ContentLength = int64(len(body))
+	proxy.ServeHTTP(w, req)
+}
+
+// silenceByID passes requests for a silence by its ID to the Alertmanager if the silence belongs to the tenants.
+func (h *Handler) silenceByID(w http.ResponseWriter, req *http.Request) {
+	proxy, ok := h.alertmanagerProxy(w, req)
+	if !ok {
+		return
+	}
+	requestInfo, _ := requestInfoFrom(req.Context())
+	policyMatchers, err := h.accessPolicies.Load().policyMatchers(requestInfo.Identity, requestInfo.Tenants)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if h.checkSilence(w, req, proxy, mux.Vars(req)["silence_id"], policyMatchers) {
+		proxy.ServeHTTP(w, req)
+	}
+}
+
+// checkSilence fetches the silence from the Alertmanager and responds with not found unless it belongs to the tenants
+// of the request.
+func (h *Handler) checkSilence(w http.ResponseWriter, req *http.Request, proxy *httputil.ReverseProxy, id string, policyMatchers []*labels.Matcher) bool {
+	requestInfo, _ := requestInfoFrom(req.Context())
+
+	s, code, err := fetchSilence(req.Context(), proxy, id)
+	if err != nil {
+		level.Error(h.logger).Log("msg", "failed to get silence", "tenant", requestInfo.TenantId, "silence", id, "err", err)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return false
+	}
+	if code == http.StatusNotFound || (code/100 == 2 && !s.belongsTo(h.upstreams.Load().tenantLabelName, requestInfo.Tenants, policyMatchers)) {
+		http.Error(w, fmt.Sprintf("silence %s not found", id), http.StatusNotFound)
+		return false
+	}
+	if code/100 != 2 {
+		http.Error(w, fmt.Sprintf("getting silence %s: unexpected status %d", id, code), http.StatusBadGateway)
+		return false
+	}
+	return true
+}
+
+// fetchSilence gets the silence from the Alertmanager, and returns the status code of the response.
+func fetchSilence(ctx context.Context, proxy *httputil.ReverseProxy, id string) (*silence, int, error) {
+	outreq, err := http.NewRequestWithContext(ctx, http.MethodGet, apiAlertmanagerPrefix+"/silence/"+url.PathEscape(id), nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	proxy.Director(outreq)
+
+	transport := proxy.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	resp, err := transport.RoundTrip(outreq)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return nil, resp.StatusCode, nil
+	}
+	var s silence
+	if err := json.NewDecoder(resp.Body).Decode(&s); err != nil {
+		return nil, 0, fmt.Errorf("decoding silence: %w", err)
+	}
+	return &s, resp.StatusCode, nil
+}
@@ -0,0 +1,105 @@
+package monitoringgateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestAlertmanagerProxy(t *testing.T) {
+	var gotRequests []string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/api/v2/silence/a-silence":
+			if req.Method == http.MethodGet {
+				_, _ = w.Write([]byte(`{"id":"a-silence","matchers":[{"name":"tenant_id","value":"a","isRegex":false,"isEqual":true}]}`))
+				return
+			}
+		case "/api/v2/silence/b-silence":
+			if req.Method == http.MethodGet {
+				_, _ = w.Write([]byte(`{"id":"b-silence","matchers":[{"name":"tenant_id","value":"b","isRegex":false}]}`))
+				return
+			}
+		}
+		gotRequests = append(gotRequests, req.Method+" "+req.URL.Path+" "+req.URL.Query().Get("filter"))
+	}))
+	defer upstream.Close()
+
+	upstreamURL, _ := url.Parse(upstream.URL)
+	h := NewHandler(nil, prometheus.NewRegistry(), &Options{
+		TenantLabelName:   "tenant_id",
+		AlertmanagerProxy: NewSingleHostReverseProxy(upstreamURL, http.DefaultTransport),
+	})
+
+	serve := func(method, target, body string) int {
+		rec := httptest.NewRecorder()
+		h.Router().ServeHTTP(rec, httptest.NewRequest(method, target, strings.NewReader(body)))
+		return rec.Code
+	}
+
+	for _, tc := range []struct {
+		method, target, body string
+		code                 int
+	}{
+		{http.MethodGet, "/a/alertmanager/api/v2/alerts", "", http.StatusOK},
+		{http.MethodGet, "/a/alertmanager/api/v2/silences", "", http.StatusOK},
+		{http.MethodPost, "/a/alertmanager/api/v2/silences", `{"matchers":[{"name":"tenant_id","value":"a","isRegex":false}]}`, http.StatusOK},
+		{http.MethodPost, "/a/alertmanager/api/v2/silences", `{"matchers":[{"name":"alertname","value":"Watchdog","isRegex":false}]}`, http.StatusBadRequest},
+		{http.MethodPost, "/a/alertmanager/api/v2/silences", `{"matchers":[{"name":"tenant_id","value":"a|b","isRegex":true}]}`, http.StatusBadRequest},
+		{http.MethodPost, "/a/alertmanager/api/v2/silences", `{"id":"b-silence","matchers":[{"name":"tenant_id","value":"a","isRegex":false}]}`, http.StatusNotFound},
+		{http.MethodDelete, "/a/alertmanager/api/v2/silence/a-silence", "", http.StatusOK},
+		{http.MethodDelete, "/a/alertmanager/api/v2/silence/b-silence", "", http.StatusNotFound},
+		{http.MethodGet, "/a/alertmanager/api/v2/silence/b-silence", "", http.StatusNotFound},
+	} {
+		if code := serve(tc.method, tc.target, tc.body); code != tc.code {
+			t.Fatalf("expected status %d for %s %s %s, got %d", tc.code, tc.method, tc.target, tc.body, code)
+		}
+	}
+
+	want := []string{
+		`GET /api/v2/alerts tenant_id="a"`,
+		`GET /api/v2/silences tenant_id="a"`,
+		`POST /api/v2/silences `,
+		`DELETE /api/v2/silence/a-silence `,
+	}
+	if diff := cmp.Diff(want, gotRequests); diff != "" {
+		t.Fatal(diff)
+	}
+}
+
+func TestAlertmanagerAccessVerb(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		_, _ = w.Write([]byte(`{"id":"a-silence","matchers":[{"name":"tenant_id","value":"a","isRegex":false}]}`))
+	}))
+	defer upstream.Close()
+
+	readOnly := &Identity{Name: "reader", authorize: func(tenant, verb string) bool { return tenant == "a" && verb == verbRead }}
+	upstreamURL, _ := url.Parse(upstream.URL)
+	h := NewHandler(nil, prometheus.NewRegistry(), &Options{
+		TenantLabelName:   "tenant_id",
+		AlertmanagerProxy: NewSingleHostReverseProxy(upstreamURL, http.DefaultTransport),
+		Authenticator:     tokenAuthenticator{"Bearer reader": readOnly},
+	})
+
+	for _, tc := range []struct {
+		method, target, body string
+		code                 int
+	}{
+		{http.MethodGet, "/a/alertmanager/api/v2/silences", "", http.StatusOK},
+		{http.MethodPost, "/a/alertmanager/api/v2/silences", `{"matchers":[{"name":"tenant_id","value":"a","isRegex":false}]}`, http.StatusForbidden},
+		{http.MethodDelete, "/a/alertmanager/api/v2/silence/a-silence", "", http.StatusForbidden},
+	} {
+		req := httptest.NewRequest(tc.method, tc.target, strings.NewReader(tc.body))
+		req.Header.Set("Authorization", "Bearer reader")
+		rec := httptest.NewRecorder()
+		h.Router().ServeHTTP(rec, req)
+		if rec.Code != tc.code {
+			t.Fatalf("expected status %d for %s %s of a read-only caller, got %d", tc.code, tc.method, tc.target, rec.Code)
+		}
+	}
+}
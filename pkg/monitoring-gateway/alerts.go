@@ -0,0 +1,204 @@
+package monitoringgateway
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/prometheus/model/labels"
+)
+
+// alert has the format of an active alert in the Prometheus alerts and rules API.
+type alert struct {
+	Labels          map[string]string `json:"labels"`
+	Annotations     map[string]string `json:"annotations"`
+	State           string            `json:"state"`
+	ActiveAt        *time.Time        `json:"activeAt,omitempty"`
+	KeepFiringSince *time.Time        `json:"keepFiringSince,omitempty"`
+	Value           string            `json:"value"`
+}
+
+type rulesResponse struct {
+	Status    string `json:"status"`
+	ErrorType string `json:"errorType,omitempty"`
+	Error     string `json:"error,omitempty"`
+	Data      struct {
+		Groups []struct {
+			Rules []struct {
+				Type   string  `json:"type"`
+				Alerts []alert `json:"alerts"`
+			} `json:"rules"`
+		} `json:"groups"`
+	} `json:"data"`
+}
+
+type alertsResponse struct {
+	Status string `json:"status"`
+	Data   struct {
+		Alerts []alert `json:"alerts"`
+	} `json:"data"`
+}
+
+// alerts serves the active alerts of the tenant in the format of the Prometheus alerts API.
+// Thanos does not support filtering alerts, so they are collected from the alerting rules of the tenant.
+func (h *Handler) alerts(w http.ResponseWriter, req *http.Request) {
+	up := h.upstreams.Load()
+	proxy := up.rulesQueryProxy
+	if proxy == nil {
+		proxy = up.queryProxy
+	}
+	if proxy == nil {
+		http.Error(w, "The query target is not configured for the server", http.StatusNotAcceptable)
+		return
+	}
+
+	ctx := req.Context()
+	requestInfo, _ := requestInfoFrom(ctx)
+
+	limits := h.queryLimits(requestInfo.Tenants)
+	matchers, err := h.queryMatchers(requestInfo)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	release, ok := h.acquireQuerySlots(w, requestInfo.Tenants)
+	if !ok {
+		return
+	}
+	defer release()
+	req, cancel := withQueryTimeout(req, limits.QueryTimeout)
+	defer cancel()
+
+	q := url.Values{"type": {"alert"}}
+	q.Set(matchersParam, matchersToString(matchers...))
+
+	outreq := req.Clone(req.Context())
+	outreq.RequestURI = ""
+	outreq.Body = nil
+	outreq.ContentLength = 0
+	outreq.URL.Path = apiGlobalPrefix + epRules
+	outreq.URL.RawQuery = q.Encode()
+	proxy.Director(outreq)
+
+	transport := proxy.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	resp, err := transport.RoundTrip(outreq)
+	if err != nil {
+		level.Error(h.logger).Log("msg", "failed to query rules", "tenant", requestInfo.TenantId, "err", err)
+		writeAPIError(w, http.StatusBadGateway, errorUnavailable, err)
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		// Pass through the error of the rules API.
+		w.Header().Set("Content-Type", resp.Header.Get("Content-Type"))
+		w.WriteHeader(resp.StatusCode)
+		_, _ = io.Copy(w, resp.Body)
+		return
+	}
+
+	var rules rulesResponse
+	if err := json.NewDecoder(resp.Body).Decode(&rules); err != nil {
+		writeAPIError(w, http.StatusBadGateway, errorUnavailable, fmt.Errorf("decoding rules response: %w", err))
+		return
+	}
+
+	var res alertsResponse
+	res.Status = "success"
+	res.Data.Alerts = filterTenantAlerts(&rules, matchers)
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(res)
+}
+
+// filterTenantAlerts returns the active alerts of the alerting rules whose labels match all the matchers enforced on the
+// request, as the rules API only filters the rules by their own labels.
+func filterTenantAlerts(rules *rulesResponse, matchers []*labels.Matcher) []alert {
+	alerts := []alert{}
+	for _, g := range rules.Data.Groups {
+		for _, r := range g.Rules {
+			if r.Type != "alerting" {
+				continue
+			}
+			for _, a := range r.Alerts {
+				if matchesAll(a.Labels, matchers) {
+					alerts = append(alerts, a)
+				}
+			}
+		}
+	}
+	return alerts
+}
+
+// matchesAll reports whether the labels match all the matchers, a missing label matches as an empty value.
+func matchesAll(lbls map[string]string, matchers []*labels.Matcher) bool {
+	for _, m := range matchers {
+		if !m.Matches(lbls[m.Name]) {
+			return false
+		}
+	}
+	return true
+}
+
+// filterRules filters a response of the rules API by the matchers enforced on the request. The alerts of the rules
+// are filtered by their labels, and the rules are kept if their labels or any of their alerts match. The response is
+// handled generically, so that the fields of the response that are not filtered are passed through.
+func filterRules(body []byte, matchers []*labels.Matcher) ([]byte, error) {
+	var res map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&res); err != nil {
+		return nil, fmt.Errorf("decoding rules response: %w", err)
+	}
+
+	data, _ := res["data"].(map[string]interface{})
+	groups, _ := data["groups"].([]interface{})
+	for _, g := range groups {
+		group, ok := g.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		rules, _ := group["rules"].([]interface{})
+		kept := []interface{}{}
+		for _, r := range rules {
+			rule, ok := r.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			alerts, hasAlerts := rule["alerts"].([]interface{})
+			keptAlerts := []interface{}{}
+			for _, a := range alerts {
+				if alert, ok := a.(map[string]interface{}); ok && matchesAll(stringMap(alert["labels"]), matchers) {
+					keptAlerts = append(keptAlerts, a)
+				}
+			}
+			if hasAlerts {
+				rule["alerts"] = keptAlerts
+			}
+			if matchesAll(stringMap(rule["labels"]), matchers) || len(keptAlerts) > 0 {
+				kept = append(kept, rule)
+			}
+		}
+		group["rules"] = kept
+	}
+	return json.Marshal(res)
+}
+
+func stringMap(v interface{}) map[string]string {
+	m, _ := v.(map[string]interface{})
+	result := make(map[string]string, len(m))
+	for k, v := range m {
+		if s, ok := v.(string); ok {
+			result[k] = s
+		}
+	}
+	return result
+}
@@ -0,0 +1,56 @@
+package monitoringgateway
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestAlerts(t *testing.T) {
+	rules := `{"status":"success","data":{"groups":[{"rules":[
+		{"type":"recording"},
+		{"type":"alerting","alerts":[
+			{"labels":{"alertname":"A","tenant_id":"t1"},"annotations":{},"state":"firing","value":"1"},
+			{"labels":{"alertname":"A","tenant_id":"t2"},"annotations":{},"state":"firing","value":"1"}
+		]}
+	]}]}}`
+
+	var gotQuery url.Values
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/api/v1/rules" {
+			t.Errorf("unexpected path %s", req.URL.Path)
+		}
+		gotQuery = req.URL.Query()
+		_, _ = w.Write([]byte(rules))
+	}))
+	defer upstream.Close()
+
+	upstreamURL, _ := url.Parse(upstream.URL)
+	h := NewHandler(nil, prometheus.NewRegistry(), &Options{
+		TenantLabelName: "tenant_id",
+		RulesQueryProxy: NewSingleHostReverseProxy(upstreamURL, http.DefaultTransport),
+	})
+
+	rec := httptest.NewRecorder()
+	h.Router().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/t1/api/v1/alerts", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d: %s", rec.Code, rec.Body.String())
+	}
+
+	if diff := cmp.Diff(url.Values{"type": {"alert"}, "match[]": {`{tenant_id="t1"}`}}, gotQuery); diff != "" {
+		t.Fatal(diff)
+	}
+
+	var res alertsResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Data.Alerts) != 1 || res.Data.Alerts[0].Labels["tenant_id"] != "t1" {
+		t.Fatalf("unexpected alerts %+v", res.Data.Alerts)
+	}
+}
@@ -0,0 +1,187 @@
+package monitoringgateway
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math/rand/v2"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// AuditConfig configures the audit log of tenant requests.
+type AuditConfig struct {
+	// Enabled enables the audit log. The request metrics are recorded regardless.
+	Enabled bool
+	// SampleRatio is the ratio of requests that are logged, between 0 and 1.
+	SampleRatio float64
+	// SlowQueryThreshold is the duration from which on every request is logged regardless of the sample ratio.
+	// Zero disables it.
+	SlowQueryThreshold time.Duration
+}
+
+// unknownTenant is the tenant of the request metrics of requests that were not admitted, so that rejected requests
+// cannot add tenants to the metrics.
+const unknownTenant = "unknown"
+
+type auditTenantKeyType int
+
+const auditTenantKey auditTenantKeyType = iota
+
+// auditedParams are the request parameters recorded in the audit log.
+var auditedParams = []string{"query", matchersParam, "time", startParam, endParam, stepParam}
+
+type requestMetrics struct {
+	requests *prometheus.CounterVec
+	errors   *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+}
+
+func newRequestMetrics(reg prometheus.Registerer) *requestMetrics {
+	return &requestMetrics{
+		requests: promauto.With(reg).NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "whizard_gateway_requests_total",
+				Help: "Total number of tenant requests, labeled by tenant, endpoint and code.",
+			},
+			[]string{"tenant", "endpoint", "code"},
+		),
+		errors: promauto.With(reg).NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "whizard_gateway_request_errors_total",
+				Help: "Total number of tenant requests that failed with a server error, labeled by tenant and endpoint.",
+			},
+			[]string{"tenant", "endpoint"},
+		),
+		duration: promauto.With(reg).NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "whizard_gateway_request_duration_seconds",
+				Help:    "Duration of tenant requests, labeled by tenant and endpoint.",
+				Buckets: []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120},
+			},
+			[]string{"tenant", "endpoint"},
+		),
+	}
+}
+
+// withAudit records the request metrics of a tenant request, and logs the request to the audit log.
+// The request metrics are labeled by the tenant only if the tenant is known, see withAdmittedTenant.
+func (h *Handler) withAudit(f http.HandlerFunc) http.HandlerFunc {
+	logger := log.With(h.logger, "component", "audit")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var (
+			start          = time.Now()
+			tenant         = mux.Vars(req)["tenant_id"]
+			admittedTenant = unknownTenant
+			endpoint       = requestEndpoint(req)
+			params         url.Values
+		)
+		if h.options.Audit.Enabled {
+			params = auditParams(req)
+		}
+
+		sw := &statusResponseWriter{ResponseWriter: w, code: http.StatusOK}
+		f.ServeHTTP(sw, req.WithContext(context.WithValue(req.Context(), auditTenantKey, &admittedTenant)))
+		duration := time.Since(start)
+
+		h.requestMetrics.requests.WithLabelValues(admittedTenant, endpoint, strconv.Itoa(sw.code)).Inc()
+		if sw.code/100 == 5 {
+			h.requestMetrics.errors.WithLabelValues(admittedTenant, endpoint).Inc()
+		}
+		h.requestMetrics.duration.WithLabelValues(admittedTenant, endpoint).Observe(duration.Seconds())
+
+		if !h.options.Audit.Enabled {
+			return
+		}
+		threshold := h.options.Audit.SlowQueryThreshold
+		slow := threshold > 0 && duration >= threshold
+		if !slow && rand.Float64() >= h.options.Audit.SampleRatio {
+			return
+		}
+
+		keyvals := []interface{}{
+			"msg", "request",
+			"tenant", tenant,
+			"endpoint", endpoint,
+			"method", req.Method,
+			"remote_addr", req.RemoteAddr,
+		}
+		for _, p := range auditedParams {
+			if vs, ok := params[p]; ok {
+				keyvals = append(keyvals, p, strings.Join(vs, ","))
+			}
+		}
+		keyvals = append(keyvals,
+			"status", sw.code,
+			"duration", duration,
+			"response_bytes", sw.bytes,
+			"slow", slow,
+		)
+		level.Info(logger).Log(keyvals...)
+	})
+}
+
+// withAdmittedTenant records the tenant of the request for the request metrics of withAudit. It wraps the handler after
+// the tenants admission and the authorization, and records the tenant only if it was admitted or the caller was
+// authenticated for it, so that the tenants of the metrics are known tenants.
+func withAdmittedTenant(f http.HandlerFunc, admission bool) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		tenant, ok := req.Context().Value(auditTenantKey).(*string)
+		if requestInfo, found := requestInfoFrom(req.Context()); ok && found && (admission || requestInfo.Identity != nil) {
+			*tenant = requestInfo.TenantId
+		}
+
+		f.ServeHTTP(w, req)
+	})
+}
+
+// requestEndpoint returns the route of the request without the tenant prefix, e.g. /api/v1/query.
+func requestEndpoint(req *http.Request) string {
+	route := mux.CurrentRoute(req)
+	if route == nil {
+		return req.URL.Path
+	}
+	tpl, err := route.GetPathTemplate()
+	if err != nil {
+		return req.URL.Path
+	}
+	return strings.TrimPrefix(tpl, "/{tenant_id}")
+}
+
+// auditParams returns the parameters of the request, including those of a form body.
+// The body is restored, so that it can be read again by the handler.
+func auditParams(req *http.Request) url.Values {
+	params := req.URL.Query()
+	if req.Method != http.MethodPost || req.Body == nil {
+		return params
+	}
+	if mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type")); mediaType != "application/x-www-form-urlencoded" {
+		return params
+	}
+
+	body, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return params
+	}
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		return params
+	}
+	for k, vs := range form {
+		params[k] = append(params[k], vs...)
+	}
+	return params
+}
@@ -0,0 +1,82 @@
+package monitoringgateway
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestAudit(t *testing.T) {
+	var gotQuery string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if err := req.ParseForm(); err != nil {
+			t.Error(err)
+		}
+		gotQuery = req.Form.Get("query")
+		_, _ = w.Write([]byte(`{"status":"success"}`))
+	}))
+	defer upstream.Close()
+	upstreamURL, _ := url.Parse(upstream.URL)
+
+	var buf bytes.Buffer
+	h := NewHandler(log.NewLogfmtLogger(&buf), prometheus.NewRegistry(), &Options{
+		TenantLabelName: "tenant_id",
+		QueryProxy:      NewSingleHostReverseProxy(upstreamURL, http.DefaultTransport),
+		Audit:           AuditConfig{Enabled: true, SampleRatio: 1},
+	})
+
+	form := url.Values{"query": {"up"}, "time": {"10"}}
+	req := httptest.NewRequest(http.MethodPost, "/a/api/v1/query", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	h.Router().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d: %s", rec.Code, rec.Body.String())
+	}
+	if gotQuery != `up{tenant_id="a"}` {
+		t.Fatalf("expected the form body to reach the upstream, got query %q", gotQuery)
+	}
+	for _, want := range []string{"tenant=a", "endpoint=/api/v1/query", "query=up", "time=10", "status=200", "response_bytes=20"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("expected %q in audit log %q", want, buf.String())
+		}
+	}
+	// Without tenants admission or authentication, any tenant may be addressed, so the tenant is not known.
+	if got := testutil.ToFloat64(h.requestMetrics.requests.WithLabelValues(unknownTenant, "/api/v1/query", "200")); got != 1 {
+		t.Fatalf("expected 1 request, got %v", got)
+	}
+
+	// Rejected requests are recorded with an unknown tenant, whichever tenant they address.
+	h = NewHandler(log.NewNopLogger(), prometheus.NewRegistry(), &Options{
+		TenantLabelName:         "tenant_id",
+		QueryProxy:              NewSingleHostReverseProxy(upstreamURL, http.DefaultTransport),
+		EnabledTenantsAdmission: true,
+	})
+	h.tenantsAdmissionMap.Store("a", true)
+	rec = httptest.NewRecorder()
+	h.Router().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/a/api/v1/query?query=up", nil))
+	if got := testutil.ToFloat64(h.requestMetrics.requests.WithLabelValues("a", "/api/v1/query", "200")); got != 1 {
+		t.Fatalf("expected 1 request of the admitted tenant, got %v", got)
+	}
+	for _, tenant := range []string{"b", "c"} {
+		rec = httptest.NewRecorder()
+		h.Router().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/"+tenant+"/api/v1/query?query=up", nil))
+		if rec.Code != http.StatusForbidden {
+			t.Fatalf("expected status %d, got %d", http.StatusForbidden, rec.Code)
+		}
+	}
+	if got := testutil.ToFloat64(h.requestMetrics.requests.WithLabelValues(unknownTenant, "/api/v1/query", "403")); got != 2 {
+		t.Fatalf("expected 2 rejected requests, got %v", got)
+	}
+	if got := testutil.CollectAndCount(h.requestMetrics.requests); got != 2 {
+		t.Fatalf("expected the rejected requests in a single series, got %d series", got)
+	}
+}
@@ -4,11 +4,79 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"slices"
+	"strings"
 	"sync"
 )
 
-var errInvalidCert = errors.New("invalid cert")
+var errUnauthenticated = errors.New("unauthenticated")
 
+// Identity is an authenticated caller of the gateway.
+type Identity struct {
+	// Name identifies the caller, e.g. the subject of a token or the common name of a certificate.
+	Name string
+	// Groups are the groups of the caller, if known.
+	Groups []string
+	// Tenants are the tenants the caller is allowed to access.
+	Tenants []string
+
+	// authorize decides whether the caller is allowed to access a tenant with a verb. It takes the place of the tenants if set.
+	authorize func(tenant, verb string) bool
+}
+
+// The verbs of the access to a tenant, named like the verbs of Kubernetes RBAC.
+const (
+	verbRead  = "get"
+	verbWrite = "create"
+)
+
+// CanAccess reports whether the caller is allowed to access the tenant with the verb.
+func (i *Identity) CanAccess(tenant, verb string) bool {
+	if i.authorize != nil {
+		return i.authorize(tenant, verb)
+	}
+	return slices.Contains(i.Tenants, tenant)
+}
+
+// accessVerb returns the verb of the access to the tenant by the request, that is write for remote write and OTLP
+// requests and changes of silences, and read otherwise.
+func accessVerb(req *http.Request) string {
+	if strings.HasSuffix(req.URL.Path, epReceive) || strings.HasSuffix(req.URL.Path, epOTLP) {
+		return verbWrite
+	}
+	if strings.HasPrefix(req.URL.Path, alertmanagerPrefix+apiAlertmanagerPrefix) && req.Method != http.MethodGet {
+		return verbWrite
+	}
+	return verbRead
+}
+
+// Authenticator authenticates the caller of a request.
+type Authenticator interface {
+	// AuthenticateRequest returns the identity of the caller, and false if the request could not be authenticated.
+	AuthenticateRequest(req *http.Request) (*Identity, bool)
+}
+
+// unionAuthenticator authenticates a request with the first of its authenticators that succeeds.
+type unionAuthenticator []Authenticator
+
+// NewUnionAuthenticator returns an Authenticator that tries the given authenticators in order.
+func NewUnionAuthenticator(authenticators ...Authenticator) Authenticator {
+	if len(authenticators) == 1 {
+		return authenticators[0]
+	}
+	return unionAuthenticator(authenticators)
+}
+
+func (u unionAuthenticator) AuthenticateRequest(req *http.Request) (*Identity, bool) {
+	for _, a := range u {
+		if identity, ok := a.AuthenticateRequest(req); ok {
+			return identity, true
+		}
+	}
+	return nil, false
+}
+
+// CertAuthenticator authenticates the tenant by the common name of the client certificate.
 type CertAuthenticator struct {
 }
 
@@ -16,33 +84,42 @@ func NewCertAuthenticator() *CertAuthenticator {
 	return &CertAuthenticator{}
 }
 
-func (cauth *CertAuthenticator) AuthenticateRequest(req *http.Request) (tenantId string, ok bool) {
+func (cauth *CertAuthenticator) AuthenticateRequest(req *http.Request) (*Identity, bool) {
 
-	if len(req.TLS.PeerCertificates) == 0 {
-		return "", false
+	if req.TLS == nil || len(req.TLS.PeerCertificates) == 0 {
+		return nil, false
 	}
 
-	return req.TLS.PeerCertificates[0].Subject.CommonName, true
+	cn := req.TLS.PeerCertificates[0].Subject.CommonName
+	return &Identity{Name: cn, Tenants: []string{cn}}, true
 }
 
-func withAuthorization(f http.HandlerFunc, certAuthenticator *CertAuthenticator) http.HandlerFunc {
+func withAuthorization(f http.HandlerFunc, authenticator Authenticator) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 
 		ctx := req.Context()
 
-		tenantId, ok := certAuthenticator.AuthenticateRequest(req)
+		identity, ok := authenticator.AuthenticateRequest(req)
 		if !ok {
-			http.Error(w, errInvalidCert.Error(), http.StatusUnauthorized)
+			http.Error(w, errUnauthenticated.Error(), http.StatusUnauthorized)
+			return
 		}
 
 		requestInfo, found := requestInfoFrom(ctx)
 		if !found {
-			http.Error(w, errInvalidCert.Error(), http.StatusUnauthorized)
+			http.Error(w, errUnauthenticated.Error(), http.StatusUnauthorized)
+			return
 		}
 
-		if tenantId != requestInfo.TenantId {
-			http.Error(w, errInvalidCert.Error(), http.StatusUnauthorized)
+		verb := accessVerb(req)
+		for _, tenant := range requestInfo.Tenants {
+			if !identity.CanAccess(tenant, verb) {
+				err := fmt.Errorf("%s is not allowed to access tenant %s", identity.Name, tenant)
+				http.Error(w, err.Error(), http.StatusForbidden)
+				return
+			}
 		}
+		requestInfo.Identity = identity
 
 		f.ServeHTTP(w, req)
 	})
@@ -52,15 +129,17 @@ func withTenantsAdmission(f http.HandlerFunc, tenantsAdmissionMap *sync.Map, ena
 	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 
 		requestInfo, found := requestInfoFrom(req.Context())
-		if !found || requestInfo.TenantId == "" {
+		if !found || len(requestInfo.Tenants) == 0 {
 			http.NotFound(w, req)
 			return
 		}
 		if enable {
-			if _, ok := tenantsAdmissionMap.Load(requestInfo.TenantId); !ok {
-				err := fmt.Errorf("tenant %s is not allowed to access", requestInfo.TenantId)
-				http.Error(w, err.Error(), http.StatusForbidden)
-				return
+			for _, tenant := range requestInfo.Tenants {
+				if _, ok := tenantsAdmissionMap.Load(tenant); !ok {
+					err := fmt.Errorf("tenant %s is not allowed to access", tenant)
+					http.Error(w, err.Error(), http.StatusForbidden)
+					return
+				}
 			}
 		}
 
@@ -1,15 +1,21 @@
 package monitoringgateway
 
 import (
+	"crypto/tls"
 	"net"
 	"net/http"
+	"os"
+	"path/filepath"
 	"reflect"
+	"slices"
 	"time"
 
 	extflag "github.com/efficientgo/tools/extkingpin"
 	"github.com/pkg/errors"
 	"github.com/prometheus/common/config"
 	"github.com/prometheus/common/model"
+	"github.com/prometheus/exporter-toolkit/web"
+	"github.com/prometheus/prometheus/model/relabel"
 	"github.com/thanos-io/thanos/pkg/extkingpin"
 
 	"gopkg.in/yaml.v2"
@@ -28,6 +34,37 @@ type ExternalRemoteWriteConfig struct {
 	BearerToken string `yaml:"bearer_token,omitempty" json:"bearer_token,omitempty"`
 	// TLSConfig to use to connect to the targets.
 	TLSConfig config.TLSConfig `yaml:"tls_config,omitempty" json:"tls_config,omitempty"`
+
+	// QueueConfig configures the queue of the requests to the targets, defaults to DefaultQueueConfig.
+	QueueConfig *QueueConfig `yaml:"queue_config,omitempty" json:"queue_config,omitempty"`
+
+	// Tenants selects the tenants whose data is forwarded to the targets. All tenants are forwarded if not set.
+	Tenants *TenantSelector `yaml:"tenants,omitempty" json:"tenants,omitempty"`
+	// WriteRelabelConfigs are applied to the series before they are forwarded to the targets.
+	WriteRelabelConfigs []*relabel.Config `yaml:"write_relabel_configs,omitempty" json:"write_relabel_configs,omitempty"`
+
+	// ProtobufMessage is the remote write message the targets support, defaults to prometheus.WriteRequest.
+	// Requests received as io.prometheus.write.v2.Request are only forwarded as such to targets supporting it.
+	ProtobufMessage RemoteWriteProtoMsg `yaml:"protobuf_message,omitempty" json:"protobuf_message,omitempty"`
+}
+
+// TenantSelector selects tenants by name.
+type TenantSelector struct {
+	// Include lists the selected tenants. All tenants are selected if empty.
+	Include []string `yaml:"include,omitempty" json:"include,omitempty"`
+	// Exclude lists the tenants that are never selected.
+	Exclude []string `yaml:"exclude,omitempty" json:"exclude,omitempty"`
+}
+
+// Selects reports whether the tenant is selected.
+func (s *TenantSelector) Selects(tenant string) bool {
+	if s == nil {
+		return true
+	}
+	if slices.Contains(s.Exclude, tenant) {
+		return false
+	}
+	return len(s.Include) == 0 || slices.Contains(s.Include, tenant)
 }
 
 // BasicAuth contains basic HTTP authentication credentials.
@@ -48,6 +85,30 @@ func RegisterHTTPFlags(cmd extkingpin.FlagClause) (httpBindAddr *string, httpGra
 	return httpBindAddr, httpGracePeriod, httpTLSConfig
 }
 
+// LoadWebTLSConfig loads the server TLS configuration of the web configuration file given by the http.config flag,
+// so that other servers are secured like the HTTP server. It returns nil if the file configures no TLS.
+func LoadWebTLSConfig(path string) (*tls.Config, error) {
+	content, err := os.ReadFile(filepath.Clean(path))
+	if err != nil {
+		return nil, err
+	}
+	c := &web.Config{
+		TLSConfig: web.TLSConfig{
+			MinVersion:               tls.VersionTLS12,
+			MaxVersion:               tls.VersionTLS13,
+			PreferServerCipherSuites: true,
+		},
+	}
+	if err := yaml.UnmarshalStrict(content, c); err != nil {
+		return nil, errors.Wrap(err, "parsing web config YAML file")
+	}
+	if c.TLSConfig.TLSCertPath == "" && c.TLSConfig.TLSCert == "" {
+		return nil, nil
+	}
+	c.TLSConfig.SetDirectory(filepath.Dir(path))
+	return web.ConfigToTLSConfig(&c.TLSConfig)
+}
+
 type QueryConfig struct {
 	DownstreamURL string
 
@@ -77,14 +138,58 @@ func (rc *RulesQueryConfig) RegisterFlag(cmd extflag.FlagClause) *RulesQueryConf
 	return rc
 }
 
-type RemoteWriteConfig struct {
+// AlertmanagerConfig configures the Alertmanager the requests to the Alertmanager API of the tenants are proxied to.
+type AlertmanagerConfig struct {
 	DownstreamURL string
+
+	DownstreamTripperConfig
+}
+
+func (ac *AlertmanagerConfig) RegisterFlag(cmd extflag.FlagClause) *AlertmanagerConfig {
+	cmd.Flag("alertmanager.address", "Address of the Alertmanager that the requests to /{tenant}/alertmanager/api/v2 are proxied to. Alerts and silences are filtered by the tenant, and silences must have the tenant matcher. If empty, the Alertmanager API is not served.").
+		PlaceHolder("<alertmanager>").StringVar(&ac.DownstreamURL)
+
+	ac.DownstreamTripperConfig.TripperPathOrContent = *extflag.RegisterPathOrContent(cmd, "alertmanager.config", "YAML file that contains downstream tripper configuration of the Alertmanager.", extflag.WithEnvSubstitution())
+
+	return ac
+}
+
+// StoreConfig configures the gRPC connection to a StoreAPI, e.g. of Thanos Query.
+type StoreConfig struct {
+	Address string
+
+	TLSSecure     bool
+	TLSSkipVerify bool
+	TLSCert       string
+	TLSKey        string
+	TLSCA         string
+	ServerName    string
+}
+
+func (sc *StoreConfig) RegisterFlag(cmd extflag.FlagClause) *StoreConfig {
+	cmd.Flag("store.address", "gRPC address of the StoreAPI, e.g. of Thanos Query, to serve remote read requests from.").
+		PlaceHolder("<store>").StringVar(&sc.Address)
+	cmd.Flag("store.grpc-client-tls-secure", "Use TLS when talking to the StoreAPI.").Default("false").BoolVar(&sc.TLSSecure)
+	cmd.Flag("store.grpc-client-tls-skip-verify", "Disable TLS certificate verification of the StoreAPI.").Default("false").BoolVar(&sc.TLSSkipVerify)
+	cmd.Flag("store.grpc-client-tls-cert", "TLS certificate to identify this client to the StoreAPI.").Default("").StringVar(&sc.TLSCert)
+	cmd.Flag("store.grpc-client-tls-key", "TLS key for the client's certificate.").Default("").StringVar(&sc.TLSKey)
+	cmd.Flag("store.grpc-client-tls-ca", "TLS CA certificates to verify the StoreAPI with.").Default("").StringVar(&sc.TLSCA)
+	cmd.Flag("store.grpc-client-server-name", "Server name to verify the hostname of the StoreAPI certificate with.").Default("").StringVar(&sc.ServerName)
+
+	return sc
+}
+
+type RemoteWriteConfig struct {
+	DownstreamURL   string
+	ProtobufMessage string
 	DownstreamTripperConfig
 }
 
 func (rwc *RemoteWriteConfig) RegisterFlag(cmd extflag.FlagClause) *RemoteWriteConfig {
 	cmd.Flag("remote-write.address", "Address to send remote write requests.").
 		PlaceHolder("<query>").StringVar(&rwc.DownstreamURL)
+	cmd.Flag("remote-write.protobuf-message", "The remote write message the downstream supports. Requests received as io.prometheus.write.v2.Request are translated into prometheus.WriteRequest unless the downstream supports them.").
+		Default(string(RemoteWriteProtoMsgV1)).EnumVar(&rwc.ProtobufMessage, string(RemoteWriteProtoMsgV1), string(RemoteWriteProtoMsgV2))
 
 	rwc.TripperPathOrContent = *extflag.RegisterPathOrContent(cmd, "remote-write.config", "YAML file that contains downstream tripper configuration. If your downstream URL is localhost or 127.0.0.1 then it is highly recommended to increase max_idle_conns_per_host to at least 100.", extflag.WithEnvSubstitution())
 
@@ -102,10 +207,32 @@ type DownstreamTripperConfig struct {
 	MaxConnsPerHost       *int                    `yaml:"max_conns_per_host"`
 	HTTPClientConfig      config.HTTPClientConfig `yaml:",inline"`
 
-	TripperPathOrContent extflag.PathOrContent
+	TripperPathOrContent extflag.PathOrContent `yaml:"-"`
+}
+
+// ParseDownstreamTripperConfig parses the YAML content of a downstream tripper configuration.
+// It returns nil if the content is empty.
+func ParseDownstreamTripperConfig(downstreamTripperConfContentYaml []byte) (*DownstreamTripperConfig, error) {
+	if len(downstreamTripperConfContentYaml) == 0 {
+		return nil, nil
+	}
+	tripperConfig := &DownstreamTripperConfig{}
+	if err := yaml.UnmarshalStrict(downstreamTripperConfContentYaml, tripperConfig); err != nil {
+		return nil, errors.Wrap(err, "parsing downstream tripper config YAML file")
+	}
+	return tripperConfig, nil
 }
 
 func ParseTransportConfiguration(downstreamTripperConfContentYaml []byte) (http.RoundTripper, error) {
+	tripperConfig, err := ParseDownstreamTripperConfig(downstreamTripperConfContentYaml)
+	if err != nil {
+		return nil, err
+	}
+	return newDownstreamTripper(tripperConfig)
+}
+
+// newDownstreamTripper creates the round tripper of a downstream, with defaults if tripperConfig is nil.
+func newDownstreamTripper(tripperConfig *DownstreamTripperConfig) (http.RoundTripper, error) {
 
 	downstreamTripper := &http.Transport{
 		Proxy: http.ProxyFromEnvironment,
@@ -121,11 +248,7 @@ func ParseTransportConfiguration(downstreamTripperConfContentYaml []byte) (http.
 		ExpectContinueTimeout: 1 * time.Second,
 	}
 
-	if len(downstreamTripperConfContentYaml) > 0 {
-		tripperConfig := &DownstreamTripperConfig{}
-		if err := yaml.UnmarshalStrict(downstreamTripperConfContentYaml, tripperConfig); err != nil {
-			return nil, errors.Wrap(err, "parsing downstream tripper config YAML file")
-		}
+	if tripperConfig != nil {
 		if !reflect.DeepEqual(tripperConfig.HTTPClientConfig, config.HTTPClientConfig{}) {
 			// todo: load DownstreamTripperConfig
 			rt, err := config.NewRoundTripperFromConfig(tripperConfig.HTTPClientConfig, "")
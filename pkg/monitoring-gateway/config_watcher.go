@@ -32,26 +32,50 @@ type AdmissionControlConfig struct {
 
 // ConfigWatcher is able to watch a file containing a configuration
 // for updates.
-type ConfigWatcher struct {
-	ch       chan AdmissionControlConfig
+type ConfigWatcher[T any] struct {
+	ch       chan T
 	path     string
 	interval time.Duration
 	logger   log.Logger
 	watcher  *fsnotify.Watcher
 
+	// parse turns the raw file content into a configuration.
+	parse func([]byte) (T, error)
+	// observe is called with every newly loaded configuration, if set.
+	observe func(T)
+	// apply applies every newly loaded configuration instead of sending it on the channel, if set.
+	apply func(T) error
+
 	successGauge         prometheus.Gauge
 	lastSuccessTimeGauge prometheus.Gauge
 	changesCounter       prometheus.Counter
 	errorCounter         prometheus.Counter
 	refreshCounter       prometheus.Counter
-	tenantsGauge         prometheus.Gauge
 
 	// lastLoadedConfigHash is the hash of the last successfully loaded configuration.
 	lastLoadedConfigHash float64
 }
 
-// NewConfigWatcher creates a new ConfigWatcher.
-func NewConfigWatcher(logger log.Logger, reg prometheus.Registerer, path string, interval model.Duration) (*ConfigWatcher, error) {
+// NewConfigWatcher creates a new ConfigWatcher for the tenants admission control configuration.
+func NewConfigWatcher(logger log.Logger, reg prometheus.Registerer, path string, interval model.Duration) (*ConfigWatcher[AdmissionControlConfig], error) {
+	cw, err := newConfigWatcher(logger, reg, "whizard_tenant_admission_config", path, interval, ParseConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	tenantsGauge := promauto.With(reg).NewGauge(
+		prometheus.GaugeOpts{
+			Name: "whizard_tenant_admission_tenants",
+			Help: "The number of tenants allowed.",
+		})
+	cw.observe = func(config AdmissionControlConfig) {
+		tenantsGauge.Set(float64(len(config.Tenants)))
+	}
+	return cw, nil
+}
+
+// newConfigWatcher creates a new ConfigWatcher whose metrics are prefixed by metricPrefix.
+func newConfigWatcher[T any](logger log.Logger, reg prometheus.Registerer, metricPrefix, path string, interval model.Duration, parse func([]byte) (T, error)) (*ConfigWatcher[T], error) {
 	if logger == nil {
 		logger = log.NewNopLogger()
 	}
@@ -64,49 +88,45 @@ func NewConfigWatcher(logger log.Logger, reg prometheus.Registerer, path string,
 		return nil, errors.Wrapf(err, "adding path %s to file watcher", path)
 	}
 
-	c := &ConfigWatcher{
-		ch:       make(chan AdmissionControlConfig),
+	c := &ConfigWatcher[T]{
+		ch:       make(chan T),
 		path:     path,
 		interval: time.Duration(interval),
 		logger:   logger,
 		watcher:  watcher,
+		parse:    parse,
 
 		successGauge: promauto.With(reg).NewGauge(
 			prometheus.GaugeOpts{
-				Name: "whizard_tenant_admission_config_last_reload_successful",
+				Name: metricPrefix + "_last_reload_successful",
 				Help: "Whether the last configuration file reload attempt was successful.",
 			}),
 		lastSuccessTimeGauge: promauto.With(reg).NewGauge(
 			prometheus.GaugeOpts{
-				Name: "whizard_tenant_admission_config_last_reload_success_timestamp_seconds",
+				Name: metricPrefix + "_last_reload_success_timestamp_seconds",
 				Help: "Timestamp of the last successful configuration file reload.",
 			}),
 		changesCounter: promauto.With(reg).NewCounter(
 			prometheus.CounterOpts{
-				Name: "whizard_tenant_admission_config_file_changes_total",
+				Name: metricPrefix + "_file_changes_total",
 				Help: "The number of times the configuration file has changed.",
 			}),
 		errorCounter: promauto.With(reg).NewCounter(
 			prometheus.CounterOpts{
-				Name: "whizard_tenant_admission_config_file_errors_total",
+				Name: metricPrefix + "_file_errors_total",
 				Help: "The number of errors watching the configuration file.",
 			}),
 		refreshCounter: promauto.With(reg).NewCounter(
 			prometheus.CounterOpts{
-				Name: "whizard_tenant_admission_config_file_refreshes_total",
+				Name: metricPrefix + "_file_refreshes_total",
 				Help: "The number of refreshes of the configuration file.",
 			}),
-		tenantsGauge: promauto.With(reg).NewGauge(
-			prometheus.GaugeOpts{
-				Name: "whizard_tenant_admission_tenants",
-				Help: "The number of tenants allowed.",
-			}),
 	}
 	return c, nil
 }
 
 // Run starts the ConfigWatcher until the given context is canceled.
-func (cw *ConfigWatcher) Run(ctx context.Context) {
+func (cw *ConfigWatcher[T]) Run(ctx context.Context) {
 	defer cw.Stop()
 
 	cw.refresh(ctx)
@@ -151,18 +171,18 @@ func (cw *ConfigWatcher) Run(ctx context.Context) {
 }
 
 // C returns a chan that gets configuration updates.
-func (cw *ConfigWatcher) C() <-chan AdmissionControlConfig {
+func (cw *ConfigWatcher[T]) C() <-chan T {
 	return cw.ch
 }
 
 // ValidateConfig returns an error if the configuration that's being watched is not valid.
-func (cw *ConfigWatcher) ValidateConfig() error {
-	_, _, err := loadConfig(cw.logger, cw.path)
+func (cw *ConfigWatcher[T]) ValidateConfig() error {
+	_, _, err := loadConfig(cw.logger, cw.path, cw.parse)
 	return err
 }
 
 // Stop shuts down the config watcher.
-func (cw *ConfigWatcher) Stop() {
+func (cw *ConfigWatcher[T]) Stop() {
 	level.Debug(cw.logger).Log("msg", "stopping configuration watcher...", "path", cw.path)
 
 	done := make(chan struct{})
@@ -189,12 +209,13 @@ func (cw *ConfigWatcher) Stop() {
 }
 
 // refresh reads the configured file and sends the configuration on the channel.
-func (cw *ConfigWatcher) refresh(ctx context.Context) {
+func (cw *ConfigWatcher[T]) refresh(ctx context.Context) {
 	cw.refreshCounter.Inc()
 
-	config, cfgHash, err := loadConfig(cw.logger, cw.path)
+	config, cfgHash, err := loadConfig(cw.logger, cw.path, cw.parse)
 	if err != nil {
 		cw.errorCounter.Inc()
+		cw.successGauge.Set(0)
 		level.Error(cw.logger).Log("msg", "failed to load configuration file", "err", err, "path", cw.path)
 		return
 	}
@@ -209,12 +230,26 @@ func (cw *ConfigWatcher) refresh(ctx context.Context) {
 	// Save the last known configuration.
 	cw.lastLoadedConfigHash = cfgHash
 
+	if cw.apply != nil {
+		// A configuration that fails to apply is applied again once the file changes.
+		if err := cw.apply(config); err != nil {
+			cw.successGauge.Set(0)
+			level.Error(cw.logger).Log("msg", "failed to apply configuration file", "err", err, "path", cw.path)
+			return
+		}
+	}
+
 	cw.successGauge.Set(1)
 	cw.lastSuccessTimeGauge.SetToCurrentTime()
 
-	cw.tenantsGauge.Set(float64(len(config.Tenants)))
+	if cw.observe != nil {
+		cw.observe(config)
+	}
 
 	level.Debug(cw.logger).Log("msg", "refreshed config")
+	if cw.apply != nil {
+		return
+	}
 	select {
 	case <-ctx.Done():
 		return
@@ -223,7 +258,7 @@ func (cw *ConfigWatcher) refresh(ctx context.Context) {
 	}
 }
 
-func ConfigFromWatcher(ctx context.Context, updates chan<- AdmissionControlConfig, cw *ConfigWatcher) error {
+func ConfigFromWatcher[T any](ctx context.Context, updates chan<- T, cw *ConfigWatcher[T]) error {
 	defer close(updates)
 	go cw.Run(ctx)
 
@@ -240,6 +275,14 @@ func ConfigFromWatcher(ctx context.Context, updates chan<- AdmissionControlConfi
 	}
 }
 
+// ApplyFromWatcher runs the ConfigWatcher and applies the loaded configurations until the given context is canceled.
+// The reload metrics of the watcher reflect whether the configurations were applied, not only whether they were parsed.
+func ApplyFromWatcher[T any](ctx context.Context, cw *ConfigWatcher[T], apply func(T) error) error {
+	cw.apply = apply
+	cw.Run(ctx)
+	return ctx.Err()
+}
+
 // ParseConfig parses the raw configuration content and returns a TenantConfig.
 func ParseConfig(content []byte) (AdmissionControlConfig, error) {
 	var config AdmissionControlConfig
@@ -248,19 +291,21 @@ func ParseConfig(content []byte) (AdmissionControlConfig, error) {
 }
 
 // loadConfig loads raw configuration content and returns a configuration.
-func loadConfig(logger log.Logger, path string) (AdmissionControlConfig, float64, error) {
+func loadConfig[T any](logger log.Logger, path string, parse func([]byte) (T, error)) (T, float64, error) {
+	var zero T
+
 	cfgContent, err := readFile(logger, path)
 	if err != nil {
-		return AdmissionControlConfig{}, 0, errors.Wrap(err, "failed to read configuration file")
+		return zero, 0, errors.Wrap(err, "failed to read configuration file")
 	}
 
 	if len(cfgContent) == 0 {
-		return AdmissionControlConfig{}, 0, errors.Wrap(errEmptyConfigurationFile, "configuration file is empty")
+		return zero, 0, errors.Wrap(errEmptyConfigurationFile, "configuration file is empty")
 	}
 
-	config, err := ParseConfig(cfgContent)
+	config, err := parse(cfgContent)
 	if err != nil {
-		return AdmissionControlConfig{}, 0, errors.Wrapf(errParseConfigurationFile, "failed to parse configuration file: %v", err)
+		return zero, 0, errors.Wrapf(errParseConfigurationFile, "failed to parse configuration file: %v", err)
 	}
 
 	return config, hashAsMetricValue(cfgContent), nil
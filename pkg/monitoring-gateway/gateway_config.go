@@ -0,0 +1,196 @@
+package monitoringgateway
+
+import (
+	"net/http/httputil"
+	"net/url"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	DefaultTenantHeader    = "WHIZARD-TENANT"
+	DefaultTenantLabelName = "tenant_id"
+)
+
+// GatewayConfig is the reloadable configuration of the gateway: the downstreams, the external remote write targets
+// and the tenant settings.
+type GatewayConfig struct {
+	Tenant TenantConfig `yaml:"tenant,omitempty"`
+
+	Query       DownstreamConfig            `yaml:"query,omitempty"`
+	RulesQuery  DownstreamConfig            `yaml:"rules_query,omitempty"`
+	RemoteWrite RemoteWriteDownstreamConfig `yaml:"remote_write,omitempty"`
+	OTLP        OTLPConfig                  `yaml:"otlp,omitempty"`
+	// Alertmanager is where the requests to the Alertmanager API of the tenants are proxied to.
+	Alertmanager DownstreamConfig `yaml:"alertmanager,omitempty"`
+
+	// ExternalRemoteWrites are the targets that received remote write requests are forwarded to as well.
+	ExternalRemoteWrites []ExternalRemoteWriteConfig `yaml:"external_remote_writes,omitempty"`
+}
+
+// TenantConfig configures how the tenant of a request is determined and announced.
+type TenantConfig struct {
+	// Header is the HTTP header to determine the tenant of write requests, defaults to DefaultTenantHeader.
+	Header string `yaml:"header,omitempty"`
+	// LabelName is the label name through which the tenant is announced, defaults to DefaultTenantLabelName.
+	LabelName string `yaml:"label_name,omitempty"`
+	// LabelEnforcement is how the tenant label of the series written by a tenant is enforced, defaults to none.
+	LabelEnforcement TenantLabelEnforcement `yaml:"label_enforcement,omitempty"`
+}
+
+// DownstreamConfig configures a downstream the gateway proxies requests to.
+type DownstreamConfig struct {
+	// URL of the downstream. Requests to it are rejected if empty.
+	URL string `yaml:"url,omitempty"`
+	// TripperConfig configures the HTTP transport to the downstream.
+	TripperConfig *DownstreamTripperConfig `yaml:"tripper_config,omitempty"`
+}
+
+// RemoteWriteDownstreamConfig configures the downstream remote write requests are proxied to.
+type RemoteWriteDownstreamConfig struct {
+	DownstreamConfig `yaml:",inline"`
+	// ProtobufMessage is the remote write message the downstream supports, defaults to prometheus.WriteRequest.
+	ProtobufMessage RemoteWriteProtoMsg `yaml:"protobuf_message,omitempty"`
+}
+
+// reverseProxy returns the reverse proxy to the downstream, or nil if the URL is empty.
+func (c DownstreamConfig) reverseProxy() (*httputil.ReverseProxy, error) {
+	if c.URL == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing downstream URL %q", c.URL)
+	}
+	tripper, err := newDownstreamTripper(c.TripperConfig)
+	if err != nil {
+		return nil, errors.Wrapf(err, "creating tripper of downstream %q", c.URL)
+	}
+	return NewSingleHostReverseProxy(u, tripper), nil
+}
+
+// ParseGatewayConfig parses and validates the YAML content of a gateway configuration.
+func ParseGatewayConfig(content []byte) (GatewayConfig, error) {
+	var c GatewayConfig
+	if err := yaml.UnmarshalStrict(content, &c); err != nil {
+		return GatewayConfig{}, errors.Wrap(err, "parsing gateway config YAML file")
+	}
+	if err := c.Validate(); err != nil {
+		return GatewayConfig{}, err
+	}
+	return c, nil
+}
+
+// Validate applies the defaults and returns an error if the configuration cannot be applied.
+func (c *GatewayConfig) Validate() error {
+	if c.Tenant.Header == "" {
+		c.Tenant.Header = DefaultTenantHeader
+	}
+	if c.Tenant.LabelName == "" {
+		c.Tenant.LabelName = DefaultTenantLabelName
+	}
+	if c.Tenant.LabelEnforcement == "" {
+		c.Tenant.LabelEnforcement = TenantLabelEnforcementNone
+	}
+	if err := c.Tenant.LabelEnforcement.Validate(); err != nil {
+		return errors.Wrap(err, "tenant")
+	}
+	if c.RemoteWrite.ProtobufMessage == "" {
+		c.RemoteWrite.ProtobufMessage = RemoteWriteProtoMsgV1
+	}
+	if err := c.RemoteWrite.ProtobufMessage.Validate(); err != nil {
+		return errors.Wrap(err, "remote_write")
+	}
+
+	for name, d := range map[string]DownstreamConfig{"query": c.Query, "rules_query": c.RulesQuery, "remote_write": c.RemoteWrite.DownstreamConfig, "alertmanager": c.Alertmanager} {
+		if _, err := d.reverseProxy(); err != nil {
+			return errors.Wrap(err, name)
+		}
+	}
+	for i := range c.ExternalRemoteWrites {
+		if c.ExternalRemoteWrites[i].URL == nil {
+			return errors.Errorf("external_remote_writes[%d]: url is required", i)
+		}
+		if _, err := newExternalRemoteWriteClient(&c.ExternalRemoteWrites[i]); err != nil {
+			return errors.Wrapf(err, "external_remote_writes[%d]", i)
+		}
+	}
+	if err := validateTargetNames(c.ExternalRemoteWrites); err != nil {
+		return errors.Wrap(err, "external_remote_writes")
+	}
+	return nil
+}
+
+// NewGatewayConfigWatcher creates a new ConfigWatcher for the gateway configuration.
+func NewGatewayConfigWatcher(logger log.Logger, reg prometheus.Registerer, path string, interval model.Duration) (*ConfigWatcher[GatewayConfig], error) {
+	return newConfigWatcher(logger, reg, "whizard_gateway_config", path, interval, ParseGatewayConfig)
+}
+
+// upstreams are the downstreams, external remote write queues and tenant settings of the handler,
+// which are replaced as a whole when the configuration is applied.
+type upstreams struct {
+	tenantHeader           string
+	tenantLabelName        string
+	tenantLabelEnforcement TenantLabelEnforcement
+
+	queryProxy          *httputil.ReverseProxy
+	rulesQueryProxy     *httputil.ReverseProxy
+	remoteWriteProxy    *httputil.ReverseProxy
+	remoteWriteProtoMsg RemoteWriteProtoMsg
+	otlp                OTLPConfig
+	alertmanagerProxy   *httputil.ReverseProxy
+
+	externalRWQueues []*remoteWriteQueue
+}
+
+// ApplyConfig replaces the downstreams, external remote write targets and tenant settings of the handler.
+// Requests in flight complete with the previous configuration. If the configuration cannot be applied,
+// the previous one stays in effect.
+func (h *Handler) ApplyConfig(c GatewayConfig) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+
+	up := &upstreams{
+		tenantHeader:           c.Tenant.Header,
+		tenantLabelName:        c.Tenant.LabelName,
+		tenantLabelEnforcement: c.Tenant.LabelEnforcement,
+		remoteWriteProtoMsg:    c.RemoteWrite.ProtobufMessage,
+		otlp:                   c.OTLP,
+	}
+	var err error
+	if up.queryProxy, err = c.Query.reverseProxy(); err != nil {
+		return errors.Wrap(err, "query")
+	}
+	if up.rulesQueryProxy, err = c.RulesQuery.reverseProxy(); err != nil {
+		return errors.Wrap(err, "rules_query")
+	}
+	if up.remoteWriteProxy, err = c.RemoteWrite.reverseProxy(); err != nil {
+		return errors.Wrap(err, "remote_write")
+	}
+	if up.alertmanagerProxy, err = c.Alertmanager.reverseProxy(); err != nil {
+		return errors.Wrap(err, "alertmanager")
+	}
+	var drainObsolete func()
+	if up.externalRWQueues, drainObsolete, err = h.queueManager.Update(c.ExternalRemoteWrites, c.Tenant.Header); err != nil {
+		return errors.Wrap(err, "updating external remote write queues")
+	}
+
+	h.upstreams.Store(up)
+	// The replaced queues are drained once the new ones take the requests.
+	drainObsolete()
+	level.Info(h.logger).Log("msg", "applied gateway configuration",
+		"query", c.Query.URL, "rules_query", c.RulesQuery.URL, "remote_write", c.RemoteWrite.URL, "alertmanager", c.Alertmanager.URL,
+		"external_remote_writes", len(up.externalRWQueues))
+	return nil
+}
+
+// Stop stops the queues of the external remote write targets.
+func (h *Handler) Stop() {
+	h.queueManager.Stop()
+}
@@ -0,0 +1,82 @@
+package monitoringgateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestParseGatewayConfig(t *testing.T) {
+	c, err := ParseGatewayConfig([]byte(`
+query:
+  url: http://query:10902
+  tripper_config:
+    max_idle_conns_per_host: 100
+external_remote_writes:
+- url: http://external:9090/api/v1/write
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(TenantConfig{Header: DefaultTenantHeader, LabelName: DefaultTenantLabelName, LabelEnforcement: TenantLabelEnforcementNone}, c.Tenant); diff != "" {
+		t.Fatal(diff)
+	}
+	if c.RemoteWrite.ProtobufMessage != RemoteWriteProtoMsgV1 {
+		t.Fatalf("unexpected protobuf message %s", c.RemoteWrite.ProtobufMessage)
+	}
+
+	for _, content := range []string{
+		"query: {url: http://query, unknown: 1}",
+		"remote_write: {url: http://receive, protobuf_message: unknown}",
+		"external_remote_writes: [{name: a}]",
+	} {
+		if _, err := ParseGatewayConfig([]byte(content)); err == nil {
+			t.Fatalf("expected error for %q", content)
+		}
+	}
+}
+
+func TestApplyConfig(t *testing.T) {
+	newUpstream := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			_, _ = w.Write([]byte(name))
+		}))
+	}
+	a, b := newUpstream("a"), newUpstream("b")
+	defer a.Close()
+	defer b.Close()
+
+	h := NewHandler(nil, prometheus.NewRegistry(), &Options{})
+	defer h.Stop()
+
+	query := func() string {
+		rec := httptest.NewRecorder()
+		h.Router().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/t/api/v1/query?query=up", nil))
+		return rec.Body.String()
+	}
+
+	if err := h.ApplyConfig(GatewayConfig{Query: DownstreamConfig{URL: a.URL}}); err != nil {
+		t.Fatal(err)
+	}
+	if got := query(); got != "a" {
+		t.Fatalf("expected response of a, got %q", got)
+	}
+
+	if err := h.ApplyConfig(GatewayConfig{Query: DownstreamConfig{URL: b.URL}}); err != nil {
+		t.Fatal(err)
+	}
+	if got := query(); got != "b" {
+		t.Fatalf("expected response of b, got %q", got)
+	}
+
+	// An invalid configuration keeps the previous one.
+	if err := h.ApplyConfig(GatewayConfig{Query: DownstreamConfig{URL: a.URL}, RemoteWrite: RemoteWriteDownstreamConfig{ProtobufMessage: "unknown"}}); err == nil {
+		t.Fatal("expected error for invalid configuration")
+	}
+	if got := query(); got != "b" {
+		t.Fatalf("expected response of b, got %q", got)
+	}
+}
@@ -0,0 +1,238 @@
+package monitoringgateway
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/prometheus/prometheus/promql/parser"
+)
+
+const (
+	reasonMaxSeriesPerMetric = "max_series_per_metric"
+
+	// activeSeriesIdleTimeout is how long a series is active after it was last written, for the limit of series per metric.
+	activeSeriesIdleTimeout = 10 * time.Minute
+)
+
+// DropRule drops the series of a tenant that match both the metric name regular expression and the label matchers,
+// if set, before they are forwarded.
+type DropRule struct {
+	// Name identifies the rule in the metrics of dropped samples.
+	Name string `yaml:"name"`
+	// MetricNameRegex is a regular expression the metric name of dropped series fully matches.
+	MetricNameRegex string `yaml:"metric_name_regex,omitempty"`
+	// Matchers are label matchers, e.g. pod=~"debug-.*", all of which the dropped series match.
+	Matchers []string `yaml:"matchers,omitempty"`
+
+	matchers []*labels.Matcher
+}
+
+// UnmarshalYAML parses the drop rule and its matchers.
+func (r *DropRule) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain DropRule
+	if err := unmarshal((*plain)(r)); err != nil {
+		return err
+	}
+	return errors.Wrapf(r.parse(), "parsing drop rule %s", r.Name)
+}
+
+func (r *DropRule) parse() error {
+	if r.Name == "" {
+		return errors.New("the name must be set")
+	}
+	if r.MetricNameRegex == "" && len(r.Matchers) == 0 {
+		return errors.New("the metric name regex or at least one matcher must be set")
+	}
+
+	r.matchers = r.matchers[:0]
+	if r.MetricNameRegex != "" {
+		m, err := labels.NewMatcher(labels.MatchRegexp, labels.MetricName, r.MetricNameRegex)
+		if err != nil {
+			return errors.Wrapf(err, "parsing metric name regex %s", r.MetricNameRegex)
+		}
+		r.matchers = append(r.matchers, m)
+	}
+	for _, s := range r.Matchers {
+		m, err := parser.ParseMetricSelector("{" + s + "}")
+		if err != nil {
+			return errors.Wrapf(err, "parsing matcher %s", s)
+		}
+		if len(m) != 1 {
+			return errors.Errorf("matcher %s must be a single label matcher", s)
+		}
+		r.matchers = append(r.matchers, m[0])
+	}
+	return nil
+}
+
+// matches reports whether the rule drops the series.
+func (r *DropRule) matches(lbls []prompb.Label) bool {
+	for _, m := range r.matchers {
+		if !m.Matches(labelValue(lbls, m.Name)) {
+			return false
+		}
+	}
+	return true
+}
+
+// labelValue returns the value of the label of the series, or an empty string if the series does not have the label.
+func labelValue(lbls []prompb.Label, name string) string {
+	for _, l := range lbls {
+		if l.Name == name {
+			return l.Value
+		}
+	}
+	return ""
+}
+
+// applyDropRules removes the series matched by one of the drop rules from the write request, and returns the number of
+// dropped samples by the first rule that matched the series.
+func applyDropRules(wreq *prompb.WriteRequest, rules []DropRule) map[string]int {
+	dropped := make(map[string]int)
+
+	kept := wreq.Timeseries[:0]
+	for _, ts := range wreq.Timeseries {
+		i := -1
+		for j := range rules {
+			if rules[j].matches(ts.Labels) {
+				i = j
+				break
+			}
+		}
+		if i < 0 {
+			kept = append(kept, ts)
+			continue
+		}
+		dropped[rules[i].Name] += len(ts.Samples) + len(ts.Histograms)
+	}
+	wreq.Timeseries = kept
+	return dropped
+}
+
+// seriesLimiter limits the number of active series per metric of tenants. A series is active if it was written within
+// activeSeriesIdleTimeout. The series are tracked per gateway replica, so the limit is enforced per replica as well.
+type seriesLimiter struct {
+	now func() time.Time
+
+	mtx     sync.Mutex
+	tenants map[string]*tenantSeries
+}
+
+// tenantSeries are the active series of a tenant.
+type tenantSeries struct {
+	mtx sync.Mutex
+	// metrics maps a metric name to the hashes of its series and when they were last written.
+	metrics    map[string]map[uint64]time.Time
+	lastPruned time.Time
+}
+
+func newSeriesLimiter() *seriesLimiter {
+	return &seriesLimiter{now: time.Now, tenants: make(map[string]*tenantSeries)}
+}
+
+// limit removes the new series of metrics which have the maximum number of active series from the write request, and
+// returns the number of dropped series and samples. The kept series become active when the returned commit function
+// is called, which is once the write request was accepted, so that rejected requests do not count against the limit.
+// A zero maximum disables the limit for the tenant.
+func (l *seriesLimiter) limit(tenant string, wreq *prompb.WriteRequest, maxSeries int) (series, samples int, commit func()) {
+	if maxSeries <= 0 {
+		l.mtx.Lock()
+		delete(l.tenants, tenant)
+		l.mtx.Unlock()
+		return 0, 0, func() {}
+	}
+
+	// Hash the series before locking the series of the tenant, so that concurrent requests of the tenant wait less.
+	var (
+		b      labels.ScratchBuilder
+		names  = make([]string, len(wreq.Timeseries))
+		hashes = make([]uint64, len(wreq.Timeseries))
+	)
+	for i, s := range wreq.Timeseries {
+		names[i], hashes[i] = seriesName(s.Labels), s.ToLabels(&b, nil).Hash()
+	}
+
+	now := l.now()
+	ts := l.tenantSeries(tenant, now)
+
+	ts.mtx.Lock()
+	defer ts.mtx.Unlock()
+
+	if now.Sub(ts.lastPruned) > time.Minute {
+		ts.prune(now.Add(-activeSeriesIdleTimeout))
+		ts.lastPruned = now
+	}
+
+	var (
+		kept       = wreq.Timeseries[:0]
+		keptNames  = names[:0]
+		keptHashes = hashes[:0]
+		// added are the new series of the request by metric name, which count against the limit as well.
+		added = make(map[string]map[uint64]struct{})
+	)
+	for i, s := range wreq.Timeseries {
+		name, hash := names[i], hashes[i]
+		active := ts.metrics[name]
+		if _, ok := active[hash]; !ok {
+			if _, ok := added[name][hash]; !ok {
+				if len(active)+len(added[name]) >= maxSeries {
+					series++
+					samples += len(s.Samples) + len(s.Histograms)
+					continue
+				}
+				if added[name] == nil {
+					added[name] = make(map[uint64]struct{})
+				}
+				added[name][hash] = struct{}{}
+			}
+		}
+		kept = append(kept, s)
+		keptNames = append(keptNames, name)
+		keptHashes = append(keptHashes, hash)
+	}
+	wreq.Timeseries = kept
+
+	return series, samples, func() {
+		ts.mtx.Lock()
+		defer ts.mtx.Unlock()
+
+		for i, name := range keptNames {
+			active, ok := ts.metrics[name]
+			if !ok {
+				active = make(map[uint64]time.Time)
+				ts.metrics[name] = active
+			}
+			active[keptHashes[i]] = now
+		}
+	}
+}
+
+// tenantSeries returns the active series of the tenant.
+func (l *seriesLimiter) tenantSeries(tenant string, now time.Time) *tenantSeries {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	ts, ok := l.tenants[tenant]
+	if !ok {
+		ts = &tenantSeries{metrics: make(map[string]map[uint64]time.Time), lastPruned: now}
+		l.tenants[tenant] = ts
+	}
+	return ts
+}
+
+// prune forgets the series that were not written since the given time.
+func (ts *tenantSeries) prune(before time.Time) {
+	for name, active := range ts.metrics {
+		for hash, last := range active {
+			if last.Before(before) {
+				delete(active, hash)
+			}
+		}
+		if len(active) == 0 {
+			delete(ts.metrics, name)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package monitoringgateway
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+func TestApplyDropRules(t *testing.T) {
+	content := `
+defaults:
+  drop_rules:
+  - name: debug
+    metric_name_regex: debug_.*
+  - name: debug-pods
+    matchers: ['pod=~"debug-.*"', 'namespace="default"']
+tenants:
+  a: {}
+`
+	c, err := ParseLimitsConfig([]byte(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	series := func(name, pod, namespace string) prompb.TimeSeries {
+		return prompb.TimeSeries{
+			Labels:  []prompb.Label{{Name: "__name__", Value: name}, {Name: "namespace", Value: namespace}, {Name: "pod", Value: pod}},
+			Samples: []prompb.Sample{{Value: 1}, {Value: 2}},
+		}
+	}
+	wreq := &prompb.WriteRequest{Timeseries: []prompb.TimeSeries{
+		series("debug_requests", "a", "default"),
+		series("requests", "debug-a", "default"),
+		series("requests", "debug-a", "kube-system"),
+		series("requests", "a", "default"),
+	}}
+
+	dropped := applyDropRules(wreq, c.ForTenant("a").DropRules)
+	if diff := cmp.Diff(map[string]int{"debug": 2, "debug-pods": 2}, dropped); diff != "" {
+		t.Fatal(diff)
+	}
+	want := []prompb.TimeSeries{series("requests", "debug-a", "kube-system"), series("requests", "a", "default")}
+	if diff := cmp.Diff(want, wreq.Timeseries); diff != "" {
+		t.Fatal(diff)
+	}
+
+	for _, content := range []string{
+		"defaults: {drop_rules: [{metric_name_regex: debug_.*}]}",
+		"defaults: {drop_rules: [{name: empty}]}",
+		"defaults: {drop_rules: [{name: invalid, matchers: ['pod=~\"(\"']}]}",
+	} {
+		if _, err := ParseLimitsConfig([]byte(content)); err == nil {
+			t.Fatalf("expected error for %s", content)
+		}
+	}
+}
+
+func TestSeriesLimiter(t *testing.T) {
+	now := time.Now()
+	l := newSeriesLimiter()
+	l.now = func() time.Time { return now }
+
+	writeRequest := func(names ...string) *prompb.WriteRequest {
+		wreq := &prompb.WriteRequest{}
+		for _, name := range names {
+			wreq.Timeseries = append(wreq.Timeseries, prompb.TimeSeries{
+				Labels:  []prompb.Label{{Name: "__name__", Value: "requests"}, {Name: "path", Value: name}},
+				Samples: []prompb.Sample{{Value: 1}},
+			})
+		}
+		return wreq
+	}
+
+	limit := func(tenant string, wreq *prompb.WriteRequest) (series, samples int) {
+		series, samples, commit := l.limit(tenant, wreq, 2)
+		commit()
+		return series, samples
+	}
+
+	// The series of requests that were not written do not count against the limit.
+	if series, _, _ := l.limit("a", writeRequest("x", "y"), 2); series != 0 {
+		t.Fatalf("expected no series dropped, got %d", series)
+	}
+	// New series of the same request count against the limit.
+	if series, _, _ := l.limit("a", writeRequest("x", "y", "z"), 2); series != 1 {
+		t.Fatalf("expected 1 new series dropped, got %d", series)
+	}
+
+	if series, _ := limit("a", writeRequest("a", "b")); series != 0 {
+		t.Fatalf("expected no series dropped, got %d", series)
+	}
+	wreq := writeRequest("a", "c")
+	if series, samples := limit("a", wreq); series != 1 || samples != 1 {
+		t.Fatalf("expected 1 new series dropped, got %d series and %d samples", series, samples)
+	}
+	if len(wreq.Timeseries) != 1 || wreq.Timeseries[0].Labels[1].Value != "a" {
+		t.Fatalf("expected the active series kept, got %v", wreq.Timeseries)
+	}
+	// Other tenants have their own series.
+	if series, _ := limit("b", writeRequest("c")); series != 0 {
+		t.Fatalf("expected no series dropped for another tenant, got %d", series)
+	}
+
+	// The series that were not written within the idle timeout are no longer active.
+	now = now.Add(activeSeriesIdleTimeout + time.Minute)
+	if series, _ := limit("a", writeRequest("c", "d")); series != 0 {
+		t.Fatalf("expected no series dropped after the idle timeout, got %d", series)
+	}
+}
@@ -0,0 +1,439 @@
+package monitoringgateway
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/prometheus/prompb"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	DefaultHAClusterLabel = "cluster"
+	DefaultHAReplicaLabel = "__replica__"
+
+	haTenantAnnotation  = "monitoring.whizard.io/ha-tenant"
+	haClusterAnnotation = "monitoring.whizard.io/ha-cluster"
+)
+
+// haElectionRetention is how many failover timeouts the elections of clusters that are not seen anymore are kept.
+const haElectionRetention = 2
+
+var errHAConflict = errors.New("the elected replica was changed concurrently")
+
+// HATrackerConfig configures the deduplication of the samples of HA pairs of Prometheus.
+type HATrackerConfig struct {
+	// ClusterLabel and ReplicaLabel are the labels that identify the cluster of a write request and its replica.
+	ClusterLabel string
+	ReplicaLabel string
+	// UpdateTimeout is how often the time the elected replica was last seen is updated in the shared state.
+	UpdateTimeout time.Duration
+	// FailoverTimeout is how long the elected replica may be absent before another replica of the cluster is elected.
+	// It must be greater than the update timeout.
+	FailoverTimeout time.Duration
+	// LeaseNamespace is the namespace of the Leases the elected replicas are shared through between gateways.
+	// If empty, the elected replicas are kept in memory.
+	LeaseNamespace string
+}
+
+// haElection is the elected replica of a cluster of a tenant.
+type haElection struct {
+	replica    string
+	receivedAt time.Time
+	// version is the version of the election in the store, for optimistic concurrency.
+	version string
+}
+
+// haStore stores the elected replicas.
+type haStore interface {
+	// get returns the election of the cluster of the tenant, or nil if there is none.
+	get(ctx context.Context, tenant, cluster string) (*haElection, error)
+	// put stores the election if the stored one still has its version, and returns errHAConflict otherwise.
+	put(ctx context.Context, tenant, cluster string, e *haElection) error
+}
+
+// HATracker elects one replica per cluster of a tenant, like the HA tracker of Cortex. Only the samples of the elected
+// replica are accepted, another replica is elected if the elected replica was not seen for the failover timeout.
+type HATracker struct {
+	logger log.Logger
+	config HATrackerConfig
+	store  haStore
+
+	mtx       sync.Mutex
+	elections map[[2]string]*haElection
+	// syncMtxs serialize the updates of the store per cluster of a tenant.
+	syncMtxs map[[2]string]*sync.Mutex
+	// lastPrune is when the elections of clusters that are not seen anymore were last removed.
+	lastPrune time.Time
+
+	electedReplica        *prometheus.GaugeVec
+	electedReplicaChanges *prometheus.CounterVec
+	dedupedSamples        *prometheus.CounterVec
+}
+
+// NewHATracker creates a new HATracker. The elected replicas are shared through Leases if the lease namespace is set.
+func NewHATracker(logger log.Logger, reg prometheus.Registerer, config HATrackerConfig, client kubernetes.Interface) (*HATracker, error) {
+	if logger == nil {
+		logger = log.NewNopLogger()
+	}
+	if config.ClusterLabel == "" {
+		config.ClusterLabel = DefaultHAClusterLabel
+	}
+	if config.ReplicaLabel == "" {
+		config.ReplicaLabel = DefaultHAReplicaLabel
+	}
+	if config.FailoverTimeout <= config.UpdateTimeout {
+		return nil, errors.Errorf("the failover timeout %s must be greater than the update timeout %s", config.FailoverTimeout, config.UpdateTimeout)
+	}
+
+	var store haStore = &memoryHAStore{elections: make(map[[2]string]haElection)}
+	if config.LeaseNamespace != "" {
+		if client == nil {
+			return nil, errors.New("a kubernetes client is required to share the elected replicas through leases")
+		}
+		store = &leaseHAStore{client: client, namespace: config.LeaseNamespace, failoverTimeout: config.FailoverTimeout}
+	}
+
+	return &HATracker{
+		logger:    logger,
+		config:    config,
+		store:     store,
+		elections: make(map[[2]string]*haElection),
+		syncMtxs:  make(map[[2]string]*sync.Mutex),
+		lastPrune: time.Now(),
+		electedReplica: promauto.With(reg).NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "whizard_gateway_ha_elected_replica",
+				Help: "The elected replica of a cluster of a tenant, whose samples are accepted, is 1.",
+			},
+			[]string{"tenant", "cluster", "replica"},
+		),
+		electedReplicaChanges: promauto.With(reg).NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "whizard_gateway_ha_elected_replica_changes_total",
+				Help: "Total number of times another replica of a cluster of a tenant was elected by this gateway.",
+			},
+			[]string{"tenant", "cluster"},
+		),
+		dedupedSamples: promauto.With(reg).NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "whizard_gateway_ha_deduplicated_samples_total",
+				Help: "Total number of samples of replicas that were not elected, which were dropped.",
+			},
+			[]string{"tenant", "cluster"},
+		),
+	}, nil
+}
+
+// haLabels returns the cluster and the replica of the write request, taken from its first series as all series of a
+// Prometheus replica carry its external labels.
+func (t *HATracker) haLabels(wreq *prompb.WriteRequest) (cluster, replica string) {
+	if len(wreq.Timeseries) == 0 {
+		return "", ""
+	}
+	for _, l := range wreq.Timeseries[0].Labels {
+		switch l.Name {
+		case t.config.ClusterLabel:
+			cluster = l.Value
+		case t.config.ReplicaLabel:
+			replica = l.Value
+		}
+	}
+	return cluster, replica
+}
+
+// dedupe reports whether the samples of the write request are accepted, that is whether they are not of a HA pair or
+// of the elected replica. The replica label is removed from the series of accepted requests of HA pairs, so that the
+// samples of the replicas make the same series.
+func (t *HATracker) dedupe(ctx context.Context, tenant string, wreq *prompb.WriteRequest, samples int) (accepted, changed bool, err error) {
+	cluster, replica := t.haLabels(wreq)
+	if cluster == "" || replica == "" {
+		return true, false, nil
+	}
+
+	if accepted, err = t.checkReplica(ctx, tenant, cluster, replica, time.Now()); err != nil || !accepted {
+		if err == nil {
+			t.dedupedSamples.WithLabelValues(tenant, cluster).Add(float64(samples))
+		}
+		return accepted, false, err
+	}
+	for i := range wreq.Timeseries {
+		wreq.Timeseries[i].Labels = removeLabel(wreq.Timeseries[i].Labels, t.config.ReplicaLabel)
+	}
+	return true, true, nil
+}
+
+func removeLabel(lbls []prompb.Label, name string) []prompb.Label {
+	for i, l := range lbls {
+		if l.Name == name {
+			return append(lbls[:i], lbls[i+1:]...)
+		}
+	}
+	return lbls
+}
+
+// checkReplica reports whether the replica is the elected replica of the cluster of the tenant, electing it if there is
+// none or the elected replica was not seen for the failover timeout.
+func (t *HATracker) checkReplica(ctx context.Context, tenant, cluster, replica string, now time.Time) (bool, error) {
+	key := [2]string{tenant, cluster}
+	if accepted, ok := t.cachedElection(key, replica, now); ok {
+		return accepted, nil
+	}
+
+	syncMtx := t.syncMtx(key)
+	syncMtx.Lock()
+	defer syncMtx.Unlock()
+	// Another request of the cluster may have updated the election while this one waited for the lock.
+	if accepted, ok := t.cachedElection(key, replica, now); ok {
+		return accepted, nil
+	}
+
+	for range 2 {
+		cur, err := t.store.get(ctx, tenant, cluster)
+		if err != nil {
+			return false, errors.Wrap(err, "getting the elected replica")
+		}
+		if cur != nil && cur.replica != replica && now.Sub(cur.receivedAt) < t.config.FailoverTimeout {
+			t.setElection(key, cur, now)
+			return false, nil
+		}
+
+		next := &haElection{replica: replica, receivedAt: now}
+		if cur != nil {
+			next.version = cur.version
+		}
+		err = t.store.put(ctx, tenant, cluster, next)
+		if err == errHAConflict {
+			continue
+		}
+		if err != nil {
+			return false, errors.Wrap(err, "updating the elected replica")
+		}
+		if cur == nil || cur.replica != replica {
+			level.Info(t.logger).Log("msg", "elected replica", "tenant", tenant, "cluster", cluster, "replica", replica)
+			t.electedReplicaChanges.WithLabelValues(tenant, cluster).Inc()
+		}
+		t.setElection(key, next, now)
+		return true, nil
+	}
+	return false, errHAConflict
+}
+
+// cachedElection reports whether the replica is accepted by the election of the cluster of the tenant this gateway
+// knows, and false if the store has to be checked. The store is only updated after the update timeout, and only read
+// once the elected replica may have failed over.
+func (t *HATracker) cachedElection(key [2]string, replica string, now time.Time) (accepted, ok bool) {
+	t.mtx.Lock()
+	e := t.elections[key]
+	t.mtx.Unlock()
+
+	if e == nil {
+		return false, false
+	}
+	if e.replica == replica && now.Sub(e.receivedAt) < t.config.UpdateTimeout {
+		return true, true
+	}
+	if e.replica != replica && now.Sub(e.receivedAt) < t.config.FailoverTimeout {
+		return false, true
+	}
+	return false, false
+}
+
+// syncMtx returns the mutex serializing the updates of the store for the cluster of a tenant.
+func (t *HATracker) syncMtx(key [2]string) *sync.Mutex {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	m, ok := t.syncMtxs[key]
+	if !ok {
+		m = &sync.Mutex{}
+		t.syncMtxs[key] = m
+	}
+	return m
+}
+
+func (t *HATracker) setElection(key [2]string, e *haElection, now time.Time) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	if prev := t.elections[key]; prev != nil && prev.replica != e.replica {
+		t.electedReplica.DeleteLabelValues(key[0], key[1], prev.replica)
+	}
+	t.elections[key] = e
+	t.electedReplica.WithLabelValues(key[0], key[1], e.replica).Set(1)
+
+	if now.Sub(t.lastPrune) >= t.config.FailoverTimeout {
+		t.prune(now.Add(-haElectionRetention * t.config.FailoverTimeout))
+		t.lastPrune = now
+	}
+}
+
+// prune removes the elections of the clusters whose elected replica was last seen before the given time, with their
+// mutexes and the series of the elected replica gauge, so that clusters that are not seen anymore are not kept. Any
+// replica of such a cluster is elected again once it is seen. It must be called with the mutex held.
+func (t *HATracker) prune(before time.Time) {
+	for key, e := range t.elections {
+		if !e.receivedAt.Before(before) {
+			continue
+		}
+		delete(t.elections, key)
+		t.electedReplica.DeleteLabelValues(key[0], key[1], e.replica)
+	}
+	// An update of the store that still holds a removed mutex is guarded by the version of the election.
+	for key := range t.syncMtxs {
+		if _, ok := t.elections[key]; !ok {
+			delete(t.syncMtxs, key)
+		}
+	}
+	// The elections kept in memory are removed as well, the Leases of the clusters are left in place.
+	if s, ok := t.store.(*memoryHAStore); ok {
+		s.prune(before)
+	}
+}
+
+// memoryHAStore keeps the elected replicas in memory, for a single gateway.
+type memoryHAStore struct {
+	mtx       sync.Mutex
+	elections map[[2]string]haElection
+}
+
+func (s *memoryHAStore) get(_ context.Context, tenant, cluster string) (*haElection, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	e, ok := s.elections[[2]string{tenant, cluster}]
+	if !ok {
+		return nil, nil
+	}
+	return &e, nil
+}
+
+// prune removes the elections whose elected replica was last seen before the given time.
+func (s *memoryHAStore) prune(before time.Time) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	for key, e := range s.elections {
+		if e.receivedAt.Before(before) {
+			delete(s.elections, key)
+		}
+	}
+}
+
+func (s *memoryHAStore) put(_ context.Context, tenant, cluster string, e *haElection) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	key := [2]string{tenant, cluster}
+	if s.elections[key].version != e.version {
+		return errHAConflict
+	}
+	v, _ := strconv.Atoi(e.version)
+	e.version = strconv.Itoa(v + 1)
+	s.elections[key] = *e
+	return nil
+}
+
+// leaseHAStore shares the elected replicas between gateways through a Lease per cluster of a tenant. The holder of the
+// Lease is the elected replica, and its renew time is when the replica was last seen.
+type leaseHAStore struct {
+	client          kubernetes.Interface
+	namespace       string
+	failoverTimeout time.Duration
+}
+
+// leaseName returns the name of the Lease of the cluster of the tenant, which are not valid names themselves.
+func leaseName(tenant, cluster string) string {
+	sum := sha256.Sum256([]byte(tenant + "/" + cluster))
+	return "whizard-ha-" + hex.EncodeToString(sum[:8])
+}
+
+func (s *leaseHAStore) get(ctx context.Context, tenant, cluster string) (*haElection, error) {
+	lease, err := s.client.CoordinationV1().Leases(s.namespace).Get(ctx, leaseName(tenant, cluster), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	e := &haElection{version: lease.ResourceVersion}
+	if lease.Spec.HolderIdentity != nil {
+		e.replica = *lease.Spec.HolderIdentity
+	}
+	if lease.Spec.RenewTime != nil {
+		e.receivedAt = lease.Spec.RenewTime.Time
+	}
+	return e, nil
+}
+
+func (s *leaseHAStore) put(ctx context.Context, tenant, cluster string, e *haElection) error {
+	leases := s.client.CoordinationV1().Leases(s.namespace)
+	renewTime := metav1.NewMicroTime(e.receivedAt)
+	durationSeconds := int32(s.failoverTimeout.Seconds())
+
+	if e.version == "" {
+		lease := &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        leaseName(tenant, cluster),
+				Namespace:   s.namespace,
+				Annotations: map[string]string{haTenantAnnotation: tenant, haClusterAnnotation: cluster},
+			},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       &e.replica,
+				AcquireTime:          &renewTime,
+				RenewTime:            &renewTime,
+				LeaseDurationSeconds: &durationSeconds,
+			},
+		}
+		created, err := leases.Create(ctx, lease, metav1.CreateOptions{})
+		if apierrors.IsAlreadyExists(err) {
+			return errHAConflict
+		}
+		if err != nil {
+			return err
+		}
+		e.version = created.ResourceVersion
+		return nil
+	}
+
+	lease, err := leases.Get(ctx, leaseName(tenant, cluster), metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if lease.ResourceVersion != e.version {
+		return errHAConflict
+	}
+	if lease.Spec.HolderIdentity == nil || *lease.Spec.HolderIdentity != e.replica {
+		lease.Spec.AcquireTime = &renewTime
+		transitions := int32(1)
+		if lease.Spec.LeaseTransitions != nil {
+			transitions = *lease.Spec.LeaseTransitions + 1
+		}
+		lease.Spec.LeaseTransitions = &transitions
+	}
+	lease.Spec.HolderIdentity = &e.replica
+	lease.Spec.RenewTime = &renewTime
+	lease.Spec.LeaseDurationSeconds = &durationSeconds
+
+	updated, err := leases.Update(ctx, lease, metav1.UpdateOptions{})
+	if apierrors.IsConflict(err) {
+		return errHAConflict
+	}
+	if err != nil {
+		return err
+	}
+	e.version = updated.ResourceVersion
+	return nil
+}
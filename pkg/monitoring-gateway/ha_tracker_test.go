@@ -0,0 +1,189 @@
+package monitoringgateway
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/prometheus/prompb"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestHATracker(t *testing.T) {
+	tracker, err := NewHATracker(nil, prometheus.NewRegistry(), HATrackerConfig{UpdateTimeout: 15 * time.Second, FailoverTimeout: 30 * time.Second}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	now := time.Now()
+	for _, tc := range []struct {
+		replica  string
+		after    time.Duration
+		accepted bool
+	}{
+		{replica: "a", accepted: true},
+		{replica: "b", after: time.Second, accepted: false},
+		{replica: "a", after: 20 * time.Second, accepted: true},
+		// The elected replica was last seen 29s ago.
+		{replica: "b", after: 49 * time.Second, accepted: false},
+		// The elected replica was last seen 31s ago, so it fails over.
+		{replica: "b", after: 51 * time.Second, accepted: true},
+		{replica: "a", after: 52 * time.Second, accepted: false},
+	} {
+		accepted, err := tracker.checkReplica(ctx, "tenant", "cluster", tc.replica, now.Add(tc.after))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if accepted != tc.accepted {
+			t.Fatalf("expected replica %s accepted %v after %s, got %v", tc.replica, tc.accepted, tc.after, accepted)
+		}
+	}
+	if v := testutil.ToFloat64(tracker.electedReplicaChanges.WithLabelValues("tenant", "cluster")); v != 2 {
+		t.Fatalf("expected 2 elected replica changes, got %v", v)
+	}
+	if v := testutil.ToFloat64(tracker.electedReplica.WithLabelValues("tenant", "cluster", "b")); v != 1 {
+		t.Fatalf("expected replica b elected, got %v", v)
+	}
+
+	// The election of a cluster that is not seen anymore is removed once another cluster is seen.
+	if accepted, err := tracker.checkReplica(ctx, "tenant", "other", "a", now.Add(5*time.Minute)); err != nil || !accepted {
+		t.Fatalf("expected replica a of another cluster accepted, got accepted %v err %v", accepted, err)
+	}
+	if _, ok := tracker.elections[[2]string{"tenant", "cluster"}]; ok || len(tracker.syncMtxs) != 1 {
+		t.Fatalf("expected the election of the cluster removed, got %d elections and %d mutexes", len(tracker.elections), len(tracker.syncMtxs))
+	}
+	if n := testutil.CollectAndCount(tracker.electedReplica); n != 1 {
+		t.Fatalf("expected 1 elected replica series, got %d", n)
+	}
+}
+
+// countingHAStore counts the reads of the store.
+type countingHAStore struct {
+	haStore
+	gets atomic.Int32
+}
+
+func (s *countingHAStore) get(ctx context.Context, tenant, cluster string) (*haElection, error) {
+	s.gets.Add(1)
+	return s.haStore.get(ctx, tenant, cluster)
+}
+
+func TestHATrackerConcurrentRequests(t *testing.T) {
+	tracker, err := NewHATracker(nil, prometheus.NewRegistry(), HATrackerConfig{UpdateTimeout: 15 * time.Second, FailoverTimeout: 30 * time.Second}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := &countingHAStore{haStore: tracker.store}
+	tracker.store = store
+
+	// Concurrent requests of a cluster update the store once, the others take the election it made.
+	now := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		for _, cluster := range []string{"a", "b"} {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if accepted, err := tracker.checkReplica(context.Background(), "tenant", cluster, "replica", now); err != nil || !accepted {
+					t.Errorf("expected the replica accepted, got %v, %v", accepted, err)
+				}
+			}()
+		}
+	}
+	wg.Wait()
+	if got := store.gets.Load(); got != 2 {
+		t.Fatalf("expected the store read once per cluster, got %d reads", got)
+	}
+}
+
+func TestHATrackerDedupe(t *testing.T) {
+	tracker, err := NewHATracker(nil, prometheus.NewRegistry(), HATrackerConfig{UpdateTimeout: 15 * time.Second, FailoverTimeout: 30 * time.Second}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeRequest := func(replica string) *prompb.WriteRequest {
+		return &prompb.WriteRequest{Timeseries: []prompb.TimeSeries{{
+			Labels:  []prompb.Label{{Name: "__name__", Value: "up"}, {Name: "__replica__", Value: replica}, {Name: "cluster", Value: "c"}},
+			Samples: []prompb.Sample{{Value: 1}},
+		}}}
+	}
+
+	wreq := writeRequest("a")
+	if accepted, changed, err := tracker.dedupe(context.Background(), "tenant", wreq, 1); err != nil || !accepted || !changed {
+		t.Fatalf("expected the elected replica accepted, got accepted %v changed %v err %v", accepted, changed, err)
+	}
+	want := []prompb.Label{{Name: "__name__", Value: "up"}, {Name: "cluster", Value: "c"}}
+	if diff := cmp.Diff(want, wreq.Timeseries[0].Labels); diff != "" {
+		t.Fatal(diff)
+	}
+
+	if accepted, _, err := tracker.dedupe(context.Background(), "tenant", writeRequest("b"), 1); err != nil || accepted {
+		t.Fatalf("expected the other replica dropped, got accepted %v err %v", accepted, err)
+	}
+	if v := testutil.ToFloat64(tracker.dedupedSamples.WithLabelValues("tenant", "c")); v != 1 {
+		t.Fatalf("expected 1 deduplicated sample, got %v", v)
+	}
+
+	// Series without the HA labels are accepted unchanged.
+	wreq = &prompb.WriteRequest{Timeseries: []prompb.TimeSeries{{Labels: []prompb.Label{{Name: "__name__", Value: "up"}}}}}
+	if accepted, changed, err := tracker.dedupe(context.Background(), "tenant", wreq, 1); err != nil || !accepted || changed {
+		t.Fatalf("expected series without HA labels accepted unchanged, got accepted %v changed %v err %v", accepted, changed, err)
+	}
+}
+
+func TestHATrackerLeases(t *testing.T) {
+	client := fake.NewClientset()
+	// The fake clientset does not set resource versions, which the leases are updated by.
+	var version int
+	setResourceVersion := func(action k8stesting.Action) (bool, runtime.Object, error) {
+		version++
+		action.(k8stesting.CreateAction).GetObject().(metav1.Object).SetResourceVersion(strconv.Itoa(version))
+		return false, nil, nil
+	}
+	client.PrependReactor("create", "leases", setResourceVersion)
+	client.PrependReactor("update", "leases", setResourceVersion)
+
+	config := HATrackerConfig{UpdateTimeout: 15 * time.Second, FailoverTimeout: 30 * time.Second, LeaseNamespace: "monitoring"}
+	// Two gateways share the elected replicas through the leases.
+	first, err := NewHATracker(nil, prometheus.NewRegistry(), config, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := NewHATracker(nil, prometheus.NewRegistry(), config, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	now := time.Now()
+	if accepted, err := first.checkReplica(ctx, "tenant", "cluster", "a", now); err != nil || !accepted {
+		t.Fatalf("expected replica a elected, got accepted %v err %v", accepted, err)
+	}
+	if accepted, err := second.checkReplica(ctx, "tenant", "cluster", "b", now.Add(time.Second)); err != nil || accepted {
+		t.Fatalf("expected replica b dropped, got accepted %v err %v", accepted, err)
+	}
+	if accepted, err := second.checkReplica(ctx, "tenant", "cluster", "b", now.Add(31*time.Second)); err != nil || !accepted {
+		t.Fatalf("expected replica b elected after the failover timeout, got accepted %v err %v", accepted, err)
+	}
+
+	lease, err := client.CoordinationV1().Leases("monitoring").Get(ctx, leaseName("tenant", "cluster"), metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *lease.Spec.HolderIdentity != "b" || *lease.Spec.LeaseTransitions != 1 {
+		t.Fatalf("expected lease held by b after 1 transition, got %s after %d", *lease.Spec.HolderIdentity, *lease.Spec.LeaseTransitions)
+	}
+	if accepted, err := first.checkReplica(ctx, "tenant", "cluster", "a", now.Add(32*time.Second)); err != nil || accepted {
+		t.Fatalf("expected replica a dropped after the failover, got accepted %v err %v", accepted, err)
+	}
+}
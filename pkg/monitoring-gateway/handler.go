@@ -7,9 +7,12 @@ import (
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"regexp"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
@@ -20,8 +23,14 @@ import (
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/common/route"
 	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/prometheus/prometheus/storage/remote"
 	extpromhttp "github.com/thanos-io/thanos/pkg/extprom/http"
+	"github.com/thanos-io/thanos/pkg/info/infopb"
+	"github.com/thanos-io/thanos/pkg/store/storepb"
 	"github.com/thanos-io/thanos/pkg/ui"
+
+	"github.com/WhizardTelemetry/whizard/pkg/constants"
 )
 
 const (
@@ -34,9 +43,11 @@ const (
 	epLabels      = "/labels"
 	epLabelValues = "/label/*path"
 	epReceive     = "/receive"
+	epRead        = "/read"
 	epOTLP        = "/otlp"
 	epRules       = "/rules"
 	epAlerts      = "/alerts"
+	epUsage       = "/usage"
 
 	epQueryUI = "/-/ui"
 )
@@ -44,13 +55,32 @@ const (
 type Options struct {
 	TenantHeader    string
 	TenantLabelName string
-
-	QueryProxy        *httputil.ReverseProxy
-	RulesQueryProxy   *httputil.ReverseProxy
-	RemoteWriteProxy  *httputil.ReverseProxy
-	ExternalRWClients []*remoteWriteClient
-
-	CertAuthenticator       *CertAuthenticator
+	// TenantLabelEnforcement is how the tenant label of the series written by a tenant is enforced.
+	TenantLabelEnforcement TenantLabelEnforcement
+
+	QueryProxy       *httputil.ReverseProxy
+	RulesQueryProxy  *httputil.ReverseProxy
+	RemoteWriteProxy *httputil.ReverseProxy
+	ExternalRWQueues []*remoteWriteQueue
+	// ExternalRWWALDir is the directory the requests queued for external remote write targets are persisted in.
+	ExternalRWWALDir string
+	// StoreClient is the StoreAPI that remote read requests are served from, e.g. of Thanos Query.
+	StoreClient storepb.StoreClient
+	// StoreInfoClient is the Info API of the StoreAPI, whose external labels are advertised to the StoreAPI callers.
+	StoreInfoClient infopb.InfoClient
+	// RemoteWriteProtoMsg is the remote write message supported by the remote write downstream.
+	RemoteWriteProtoMsg RemoteWriteProtoMsg
+	// OTLP configures how OTLP requests are handled.
+	OTLP OTLPConfig
+	// AlertmanagerProxy proxies the requests to the Alertmanager API of the tenants.
+	AlertmanagerProxy *httputil.ReverseProxy
+	// HATracker deduplicates the samples of HA pairs of Prometheus, if set.
+	HATracker *HATracker
+
+	Authenticator Authenticator
+	Audit         AuditConfig
+	// UsageRetention is the time window the usage of tenants is kept for, defaults to DefaultUsageRetention.
+	UsageRetention          time.Duration
 	EnabledTenantsAdmission bool
 	EnabledQueryUI          bool
 }
@@ -63,12 +93,32 @@ type Handler struct {
 
 	tenantsAdmissionMap *sync.Map
 
-	queryProxy        *httputil.ReverseProxy
-	rulesQueryProxy   *httputil.ReverseProxy
-	remoteWriteProxy  *httputil.ReverseProxy
-	externalRWClients []*remoteWriteClient
+	limits                atomic.Pointer[LimitsConfig]
+	accessPolicies        atomic.Pointer[AccessPolicyConfig]
+	ingestionRateLimiter  *tenantRateLimiter
+	ingestionBytesLimiter *tenantRateLimiter
+	seriesLimiter         *seriesLimiter
+	queryConcurrency      *tenantConcurrency
+
+	upstreams    atomic.Pointer[upstreams]
+	queueManager *QueueManager
+	storeClient  storepb.StoreClient
+
+	storeInfoClient infopb.InfoClient
+
+	hashrings      atomic.Pointer[[]HashringConfig]
+	ingesterClient *http.Client
+	// ingesterHTTPPort is the HTTP port of the ingesters, as the hashrings have their gRPC addresses.
+	ingesterHTTPPort int
+
+	requestMetrics *requestMetrics
+	usageTracker   *usageTracker
 
 	remoteWriteRequestsCounter *prometheus.CounterVec
+	acceptedSamplesCounter     *prometheus.CounterVec
+	rejectedSamplesCounter     *prometheus.CounterVec
+	droppedSamplesCounter      *prometheus.CounterVec
+	expensiveQueriesCounter    *prometheus.CounterVec
 }
 
 func NewHandler(logger log.Logger, reg *prometheus.Registry, o *Options) *Handler {
@@ -82,27 +132,81 @@ func NewHandler(logger log.Logger, reg *prometheus.Registry, o *Options) *Handle
 		router:              mux.NewRouter(),
 		tenantsAdmissionMap: &sync.Map{},
 		reg:                 reg,
-		queryProxy:          o.QueryProxy,
-		rulesQueryProxy:     o.RulesQueryProxy,
-		remoteWriteProxy:    o.RemoteWriteProxy,
-		externalRWClients:   o.ExternalRWClients,
+		queueManager:        NewQueueManager(log.With(logger, "component", "external-remote-write"), reg, o.ExternalRWWALDir),
+		storeClient:         o.StoreClient,
+		storeInfoClient:     o.StoreInfoClient,
+		ingesterClient:      &http.Client{Transport: http.DefaultTransport},
+		ingesterHTTPPort:    constants.HTTPPort,
+
+		ingestionRateLimiter:  newTenantRateLimiter(),
+		ingestionBytesLimiter: newTenantRateLimiter(),
+		seriesLimiter:         newSeriesLimiter(),
+		queryConcurrency:      newTenantConcurrency(),
+
+		requestMetrics: newRequestMetrics(reg),
+		usageTracker:   newUsageTracker(reg, o.UsageRetention),
 
 		remoteWriteRequestsCounter: promauto.With(reg).NewCounterVec(
 			prometheus.CounterOpts{
-				Name: "whizard_external_remote_write_requests_total",
-				Help: "Total number of remote write results, labeled by endpoint and code.",
+				Name: "whizard_gateway_remote_write_requests_total",
+				Help: "Total number of received remote write requests, labeled by tenant and remote write protocol version.",
+			},
+			[]string{"tenant", "version"},
+		),
+		acceptedSamplesCounter: promauto.With(reg).NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "whizard_gateway_accepted_samples_total",
+				Help: "Total number of samples accepted by remote write, labeled by tenant.",
+			},
+			[]string{"tenant"},
+		),
+		rejectedSamplesCounter: promauto.With(reg).NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "whizard_gateway_rejected_samples_total",
+				Help: "Total number of samples rejected by remote write, labeled by tenant and reason.",
 			},
-			[]string{"endpoint", "code"},
+			[]string{"tenant", "reason"},
 		),
+		droppedSamplesCounter: promauto.With(reg).NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "whizard_gateway_dropped_samples_total",
+				Help: "Total number of samples dropped by the drop rules of tenants, labeled by tenant and rule.",
+			},
+			[]string{"tenant", "rule"},
+		),
+		expensiveQueriesCounter: promauto.With(reg).NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "whizard_gateway_expensive_queries_total",
+				Help: "Total number of queries whose estimated cost exceeds the budget of the tenant, labeled by tenant and whether they were only counted in dry run mode.",
+			},
+			[]string{"tenant", "dry_run"},
+		),
+	}
+
+	h.upstreams.Store(&upstreams{
+		tenantHeader:           o.TenantHeader,
+		tenantLabelName:        o.TenantLabelName,
+		tenantLabelEnforcement: o.TenantLabelEnforcement,
+		queryProxy:             o.QueryProxy,
+		rulesQueryProxy:        o.RulesQueryProxy,
+		remoteWriteProxy:       o.RemoteWriteProxy,
+		remoteWriteProtoMsg:    o.RemoteWriteProtoMsg,
+		otlp:                   o.OTLP,
+		alertmanagerProxy:      o.AlertmanagerProxy,
+		externalRWQueues:       o.ExternalRWQueues,
+	})
+
+	if reg != nil {
+		reg.MustRegister(newLimitsCollector(&h.limits))
 	}
 
-	// do provide /api/v1/alerts because thanos does not support alerts filtering as of v0.28.0
-	// please filtering alerts by /api/v1/rules
-	// h.router.Get(epAlerts, h.wrap(h.matcher(matchersParam)))
+	h.addUsageHandler()
 	h.addGlobalProxyHandler()
 	h.addTenantQueryHandler()
 	h.addTenantRemoteWriteHandler()
 	h.addTenantOTLPHandler()
+	h.addTenantAlertmanagerHandler()
+	h.addTenantStatusHandler()
 
 	if o.EnabledQueryUI {
 		h.addQueryUIHandler()
@@ -118,24 +222,43 @@ func (h *Handler) addTenantQueryHandler() {
 	h.router.Path(apiTenantPrefix + epLabels).Methods(http.MethodGet).HandlerFunc(h.wrap(h.matcher(matchersParam)))
 	h.router.Path(apiTenantPrefix + epLabelValues).Methods(http.MethodGet).HandlerFunc(h.wrap(h.matcher(matchersParam)))
 	h.router.Path(apiTenantPrefix + epRules).Methods(http.MethodGet).HandlerFunc(h.wrap(h.matcher(matchersParam)))
+	h.router.Path(apiTenantPrefix + epAlerts).Methods(http.MethodGet).HandlerFunc(h.wrap(withSingleTenant(h.alerts)))
+	h.router.Path(apiTenantPrefix + epRead).Methods(http.MethodPost).HandlerFunc(h.wrap(h.remoteRead))
 }
 
 // addTenantRemoteWriteHandler adds a handler for receiving remote write requests, and supports forwarding them to external remote write targets.
 func (h *Handler) addTenantRemoteWriteHandler() {
-	h.router.Path(apiTenantPrefix + epReceive).Methods(http.MethodPost).HandlerFunc(h.wrap(h.remoteWrite))
+	h.router.Path(apiTenantPrefix + epReceive).Methods(http.MethodPost).HandlerFunc(h.wrap(withSingleTenant(h.remoteWrite)))
 }
 
 func (h *Handler) addTenantOTLPHandler() {
-	h.router.Path(apiTenantPrefix + epOTLP).Methods(http.MethodPost).HandlerFunc(h.wrap(h.otlpReceive))
+	h.router.Path(apiTenantPrefix + epOTLP).Methods(http.MethodPost).HandlerFunc(h.wrap(withSingleTenant(h.otlpReceive)))
+}
+
+// addUsageHandler adds the handler for the ingestion usage of a tenant. The usage of all tenants is exported by the
+// metrics of the gateway only, as the API is served to tenants.
+func (h *Handler) addUsageHandler() {
+	h.router.Path(apiTenantPrefix + epUsage).Methods(http.MethodGet).HandlerFunc(h.wrap(h.usage))
 }
 
+// addGlobalProxyHandler adds the routes without a tenant. The write routes serve clients that set the tenant header
+// themselves, e.g. the agents within the cluster, and their requests are enforced, limited and accounted for the tenant
+// of the header. The routes are not authenticated, so they must be served to trusted clients only.
 func (h *Handler) addGlobalProxyHandler() {
-	if h.remoteWriteProxy != nil {
-		h.router.Path(apiGlobalPrefix + epReceive).HandlerFunc(h.remoteWrite)
-		h.router.Path(apiGlobalPrefix + epOTLP).HandlerFunc(h.remoteWriteProxy.ServeHTTP)
-	}
-	if h.queryProxy != nil {
-		h.router.PathPrefix(apiGlobalPrefix).HandlerFunc(h.queryProxy.ServeHTTP)
+	h.router.Path(apiGlobalPrefix + epReceive).HandlerFunc(h.withHeaderTenant(h.remoteWrite))
+	h.router.Path(apiGlobalPrefix + epOTLP).HandlerFunc(h.withHeaderTenant(h.otlpReceive))
+	h.router.PathPrefix(apiGlobalPrefix).HandlerFunc(h.proxyTo(func(up *upstreams) *httputil.ReverseProxy { return up.queryProxy }))
+}
+
+// proxyTo proxies requests to the currently configured downstream returned by target, and responds with 404 if there is none.
+func (h *Handler) proxyTo(target func(*upstreams) *httputil.ReverseProxy) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		proxy := target(h.upstreams.Load())
+		if proxy == nil {
+			http.NotFound(w, req)
+			return
+		}
+		proxy.ServeHTTP(w, req)
 	}
 }
 
@@ -146,7 +269,7 @@ func (h *Handler) addQueryUIHandler() {
 	ui.NewQueryUI(h.logger, nil, epQueryUI, "", "", "", "", false).Register(r, ins)
 
 	// matching /-/ui/* routes
-	h.router.PathPrefix(epQueryUI).HandlerFunc(h.queryUIHander(epQueryUI, h.queryProxy.ServeHTTP, r.ServeHTTP))
+	h.router.PathPrefix(epQueryUI).HandlerFunc(h.queryUIHander(epQueryUI, h.proxyTo(func(up *upstreams) *httputil.ReverseProxy { return up.queryProxy }), r.ServeHTTP))
 }
 
 func (h *Handler) queryUIHander(prefix string, queryHanler, uiHandler http.HandlerFunc) http.HandlerFunc {
@@ -191,20 +314,29 @@ func (h *Handler) SetAdmissionControlHandler(c AdmissionControlConfig) error {
 	return nil
 }
 
+// SetLimits replaces the tenant limits enforced by the gateway.
+func (h *Handler) SetLimits(c LimitsConfig) error {
+	level.Info(h.logger).Log("msg", "updating tenant limits", "tenants", len(c.Tenants))
+	h.limits.Store(&c)
+	return nil
+}
+
 func (h *Handler) Router() *mux.Router {
 	return h.router
 }
 
 func (h *Handler) wrap(f http.HandlerFunc) http.HandlerFunc {
-	if h.options.CertAuthenticator != nil {
-		f = withAuthorization(f, h.options.CertAuthenticator)
+	f = withAdmittedTenant(f, h.options.EnabledTenantsAdmission)
+	if h.options.Authenticator != nil {
+		f = withAuthorization(f, h.options.Authenticator)
 	}
 
-	return withRequestInfo(withTenantsAdmission(f, h.tenantsAdmissionMap, h.options.EnabledTenantsAdmission))
+	return h.withAudit(withRequestInfo(withTenantsAdmission(f, h.tenantsAdmissionMap, h.options.EnabledTenantsAdmission)))
 }
 
 func (h *Handler) query(w http.ResponseWriter, req *http.Request) {
-	if h.queryProxy == nil {
+	up := h.upstreams.Load()
+	if up.queryProxy == nil {
 		http.Error(w, "The query target is not configured for the server", http.StatusNotAcceptable)
 		return
 	}
@@ -214,11 +346,11 @@ func (h *Handler) query(w http.ResponseWriter, req *http.Request) {
 		postForm url.Values
 	)
 
+	if err := req.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	if req.Method == http.MethodPost {
-		if err := req.ParseForm(); err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
-		}
 		postForm = req.PostForm
 	}
 
@@ -226,43 +358,81 @@ func (h *Handler) query(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	// The queries are parsed once for the query cost check and the enforcement of the matchers.
+	queryExpr, err := parseQuery(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	postExpr, err := parseQuery(postForm)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// The query of the body takes precedence over the query of the URL, as in the form of the request.
+	expr := postExpr
+	if expr == nil {
+		expr = queryExpr
+	}
+
 	ctx := req.Context()
 	requestInfo, _ := requestInfoFrom(ctx)
 
+	limits := h.queryLimits(requestInfo.Tenants)
+	rangeQuery := strings.HasSuffix(req.URL.Path, epQueryRange)
+	if rangeQuery {
+		if err := checkQueryRangeLimits(limits, req.Form); err != nil {
+			writeAPIError(w, http.StatusUnprocessableEntity, errorBadData, err)
+			return
+		}
+	}
+	matchers, err := h.queryMatchers(requestInfo)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if err := h.checkQueryCost(limits, requestInfo.TenantId, expr, req.Form, rangeQuery, matchers); err != nil {
+		writeAPIError(w, http.StatusUnprocessableEntity, errorBadData, err)
+		return
+	}
+	release, ok := h.acquireQuerySlots(w, requestInfo.Tenants)
+	if !ok {
+		return
+	}
+	defer release()
+
+	capQueryTimeout(query, limits.QueryTimeout)
+	if postForm.Get(queryParam) != "" {
+		capQueryTimeout(postForm, limits.QueryTimeout)
+	}
+	req, cancel := withQueryTimeout(req, limits.QueryTimeout)
+	defer cancel()
+
 	// Set errorOnReplace to false to directly replace the existing tenant with the new TenantId without reporting an error.
-	enforcer := injectproxy.NewPromQLEnforcer(false, &labels.Matcher{
-		Type:  labels.MatchEqual,
-		Name:  h.options.TenantLabelName,
-		Value: requestInfo.TenantId,
-	})
+	enforcer := injectproxy.NewPromQLEnforcer(false, matchers...)
 
-	q, found, err := enforceQueryValues(enforcer, query)
+	q, _, err := enforceQueryValues(enforcer, query, queryExpr)
 	if err != nil {
 		if errors.Is(err, injectproxy.ErrIllegalLabelMatcher) {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 		} else {
 			switch err.(type) {
-			case queryParseError:
-				http.Error(w, err.Error(), http.StatusBadRequest)
 			case enforceLabelError:
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 			}
 		}
 		return
 	}
-	if found {
-		req.URL.RawQuery = q
-	}
+	// The query values are re-encoded even without a query, as the timeout may have been capped.
+	req.URL.RawQuery = q
 
 	if postForm != nil {
-		q, found, err := enforceQueryValues(enforcer, postForm)
+		q, found, err := enforceQueryValues(enforcer, postForm, postExpr)
 		if err != nil {
 			if errors.Is(err, injectproxy.ErrIllegalLabelMatcher) {
 				http.Error(w, err.Error(), http.StatusBadRequest)
 			} else {
 				switch err.(type) {
-				case queryParseError:
-					http.Error(w, err.Error(), http.StatusBadRequest)
 				case enforceLabelError:
 					http.Error(w, err.Error(), http.StatusInternalServerError)
 				}
@@ -276,12 +446,32 @@ func (h *Handler) query(w http.ResponseWriter, req *http.Request) {
 		}
 	}
 
-	h.queryProxy.ServeHTTP(w, req)
+	up.queryProxy.ServeHTTP(w, req)
+}
+
+// tenantMatcher returns the matcher that restricts queries to the given tenants.
+// A tenant set is matched by a regular expression that matches exactly its tenants.
+func (h *Handler) tenantMatcher(tenants []string) *labels.Matcher {
+	tenantLabelName := h.upstreams.Load().tenantLabelName
+	if len(tenants) == 1 {
+		return &labels.Matcher{
+			Type:  labels.MatchEqual,
+			Name:  tenantLabelName,
+			Value: tenants[0],
+		}
+	}
+
+	values := make([]string, 0, len(tenants))
+	for _, tenant := range tenants {
+		values = append(values, regexp.QuoteMeta(tenant))
+	}
+	return labels.MustNewMatcher(labels.MatchRegexp, tenantLabelName, strings.Join(values, "|"))
 }
 
 func (h *Handler) matcher(matchersParam string) http.HandlerFunc {
 	return func(w http.ResponseWriter, req *http.Request) {
-		if h.queryProxy == nil {
+		up := h.upstreams.Load()
+		if up.queryProxy == nil {
 			http.Error(w, "The query target is not configured for the server", http.StatusNotAcceptable)
 			return
 		}
@@ -289,14 +479,30 @@ func (h *Handler) matcher(matchersParam string) http.HandlerFunc {
 		ctx := req.Context()
 		requestInfo, _ := requestInfoFrom(ctx)
 
-		matcher := &labels.Matcher{
-			Type:  labels.MatchEqual,
-			Name:  h.options.TenantLabelName,
-			Value: requestInfo.TenantId,
+		limits := h.queryLimits(requestInfo.Tenants)
+		matchers, err := h.queryMatchers(requestInfo)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
 		}
+		release, ok := h.acquireQuerySlots(w, requestInfo.Tenants)
+		if !ok {
+			return
+		}
+		defer release()
+		req, cancel := withQueryTimeout(req, limits.QueryTimeout)
+		defer cancel()
+
 		q := req.URL.Query()
 
-		if err := injectMatcher(q, matcher, matchersParam); err != nil {
+		if !strings.HasSuffix(req.URL.Path, epRules) {
+			if err := enforceQueryLookback(limits, q, time.Now()); err != nil {
+				writeAPIError(w, http.StatusUnprocessableEntity, errorBadData, err)
+				return
+			}
+		}
+
+		if err := injectMatcher(q, matchersParam, matchers...); err != nil {
 			return
 		}
 		req.URL.RawQuery = q.Encode()
@@ -305,7 +511,7 @@ func (h *Handler) matcher(matchersParam string) http.HandlerFunc {
 				return
 			}
 			q = req.PostForm
-			if err := injectMatcher(q, matcher, matchersParam); err != nil {
+			if err := injectMatcher(q, matchersParam, matchers...); err != nil {
 				return
 			}
 			_ = req.Body.Close()
@@ -313,17 +519,46 @@ func (h *Handler) matcher(matchersParam string) http.HandlerFunc {
 			req.ContentLength = int64(len(q))
 		}
 
-		if (strings.HasSuffix(req.URL.Path, "/rules") || strings.HasSuffix(req.URL.Path, "/alerts")) &&
-			h.rulesQueryProxy != nil {
-			h.rulesQueryProxy.ServeHTTP(w, req)
+		if strings.HasSuffix(req.URL.Path, epRules) {
+			h.proxyRules(w, req, up, matchers)
 			return
 		}
-		h.queryProxy.ServeHTTP(w, req)
+		up.queryProxy.ServeHTTP(w, req)
 	}
 }
 
+// proxyRules proxies a request of the rules API, and filters the rules and alerts of the response by the matchers
+// enforced on the request.
+func (h *Handler) proxyRules(w http.ResponseWriter, req *http.Request, up *upstreams, matchers []*labels.Matcher) {
+	proxy := *up.queryProxy // 浅拷贝
+	if up.rulesQueryProxy != nil {
+		proxy = *up.rulesQueryProxy
+	}
+	// The response is rewritten, so it is requested without encoding.
+	req.Header.Del("Accept-Encoding")
+	proxy.ModifyResponse = func(resp *http.Response) error {
+		if resp.StatusCode/100 != 2 {
+			return nil
+		}
+		body, err := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		if body, err = filterRules(body, matchers); err != nil {
+			return err
+		}
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+		resp.ContentLength = int64(len(body))
+		resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+		return nil
+	}
+	proxy.ServeHTTP(w, req)
+}
+
 func (h *Handler) remoteWrite(w http.ResponseWriter, req *http.Request) {
-	if h.remoteWriteProxy == nil {
+	up := h.upstreams.Load()
+	if up.remoteWriteProxy == nil {
 		http.Error(w, "There is no remote write targets configured for the server", http.StatusNotAcceptable)
 		return
 	}
@@ -331,11 +566,9 @@ func (h *Handler) remoteWrite(w http.ResponseWriter, req *http.Request) {
 	requestInfo, found := requestInfoFrom(ctx)
 
 	if found && requestInfo.TenantId != "" {
-		req.Header.Set(h.options.TenantHeader, requestInfo.TenantId)
+		req.Header.Set(up.tenantHeader, requestInfo.TenantId)
 	}
 
-	// Forward the request to multiple targets in parallel.
-	// If either forwarding fails, the errors are responded. This may result in repeated sending same data to one target.
 	body, err := io.ReadAll(req.Body)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -343,39 +576,194 @@ func (h *Handler) remoteWrite(w http.ResponseWriter, req *http.Request) {
 	}
 	defer req.Body.Close()
 
-	proxy := *h.remoteWriteProxy // 浅拷贝
+	protoMsg, err := parseRemoteWriteProtoMsg(req.Header.Get("Content-Type"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		return
+	}
+	h.remoteWriteRequestsCounter.WithLabelValues(req.Header.Get(up.tenantHeader), protoMsg.Version()).Inc()
+
+	// Requests are handled as 1.0 requests internally. The original body of 2.0 requests is kept
+	// to be forwarded as is to the targets that support them.
+	var (
+		wreq   *prompb.WriteRequest
+		v2Body []byte
+	)
+	if protoMsg == RemoteWriteProtoMsgV2 {
+		v2req, err := decodeWriteV2Request(body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if wreq, err = writeV2ToV1(v2req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		v2Body = body
+		if body, err = encodeWriteRequest(wreq); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	h.forwardWrite(w, req, up, &writeRequest{
+		wreq:     wreq,
+		body:     body,
+		v2Body:   v2Body,
+		protoMsg: protoMsg,
+		protocol: protocolRemoteWrite,
+	})
+}
+
+// writeRequest is a remote write request on its way to the downstreams.
+type writeRequest struct {
+	// wreq is the decoded request, which is decoded from body when needed if nil.
+	wreq *prompb.WriteRequest
+	// body is the request encoded as prometheus.WriteRequest.
+	body []byte
+	// v2Body is the original body of requests received as io.prometheus.write.v2.Request.
+	v2Body []byte
+	// protoMsg is the message the request was received as.
+	protoMsg RemoteWriteProtoMsg
+	// protocol is the protocol the request was received by, for the usage accounting.
+	protocol string
+}
+
+// forwardWrite enforces the tenant label, the elected replica of HA pairs, the validation of the series, the drop rules,
+// the series per metric and the ingestion limits of the tenant on the write request, and forwards it to the remote
+// write downstream. Once the downstream accepted it, the request is forwarded to the external remote write targets and
+// accounted to the usage of the tenant.
+func (h *Handler) forwardWrite(w http.ResponseWriter, req *http.Request, up *upstreams, r *writeRequest) {
+	var err error
+	// commitSeries records the series of the request as active for the limit of series per metric once it is written.
+	commitSeries := func() {}
+	requestInfo, found := requestInfoFrom(req.Context())
+	if found && requestInfo.TenantId != "" {
+		if r.wreq == nil {
+			if r.wreq, err = decodeWriteRequest(r.body); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		samples := countSamples(r.wreq)
+
+		changed, err := enforceTenantLabel(r.wreq, up.tenantLabelName, requestInfo.TenantId, up.tenantLabelEnforcement)
+		if err != nil {
+			h.rejectedSamplesCounter.WithLabelValues(requestInfo.TenantId, reasonTenantLabelMismatch).Add(float64(samples))
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if h.options.HATracker != nil {
+			accepted, deduped, err := h.options.HATracker.dedupe(req.Context(), requestInfo.TenantId, r.wreq, samples)
+			if err != nil {
+				level.Error(h.logger).Log("msg", "failed to check the elected replica", "tenant", requestInfo.TenantId, "err", err)
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if !accepted {
+				// Like Cortex, the samples of replicas that are not elected are acknowledged, so that they are not retried.
+				http.Error(w, "samples of a replica that is not elected are dropped", http.StatusAccepted)
+				return
+			}
+			changed = changed || deduped
+		}
+		limits := h.limits.Load().ForTenant(requestInfo.TenantId)
+		if limits.validatesSeries() {
+			discarded, dropped, err := validateWriteRequest(r.wreq, limits, time.Now())
+			if err != nil {
+				level.Debug(h.logger).Log("msg", "remote write request rejected", "tenant", requestInfo.TenantId, "err", err)
+				h.rejectedSamplesCounter.WithLabelValues(requestInfo.TenantId, err.reason).Add(float64(samples))
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			for reason, n := range discarded {
+				h.rejectedSamplesCounter.WithLabelValues(requestInfo.TenantId, reason).Add(float64(n))
+				samples -= n
+			}
+			changed = changed || dropped
+		}
+		if len(limits.DropRules) > 0 {
+			dropped := applyDropRules(r.wreq, limits.DropRules)
+			for rule, n := range dropped {
+				h.droppedSamplesCounter.WithLabelValues(requestInfo.TenantId, rule).Add(float64(n))
+				samples -= n
+			}
+			changed = changed || len(dropped) > 0
+		}
+		series, n, commit := h.seriesLimiter.limit(requestInfo.TenantId, r.wreq, limits.MaxSeriesPerMetric)
+		commitSeries = commit
+		if series > 0 {
+			level.Debug(h.logger).Log("msg", "new series over the limit of series per metric dropped", "tenant", requestInfo.TenantId, "series", series)
+			h.rejectedSamplesCounter.WithLabelValues(requestInfo.TenantId, reasonMaxSeriesPerMetric).Add(float64(n))
+			samples -= n
+			changed = true
+		}
+		if changed {
+			if r.body, err = encodeWriteRequest(r.wreq); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			// The original 2.0 body carries the overwritten labels, so the changed request is forwarded as 1.0 only.
+			r.v2Body = nil
+		}
+
+		if err := h.checkIngestionLimits(requestInfo.TenantId, limits, len(r.body), len(r.wreq.Timeseries), samples); err != nil {
+			level.Debug(h.logger).Log("msg", "remote write request rejected", "tenant", requestInfo.TenantId, "err", err)
+			h.rejectedSamplesCounter.WithLabelValues(requestInfo.TenantId, err.reason).Add(float64(samples))
+			writeRateLimitError(w, err)
+			return
+		}
+		h.acceptedSamplesCounter.WithLabelValues(requestInfo.TenantId).Add(float64(samples))
+	}
+
+	outBody, outProtoMsg := r.body, RemoteWriteProtoMsgV1
+	if r.v2Body != nil && up.remoteWriteProtoMsg == RemoteWriteProtoMsgV2 {
+		outBody, outProtoMsg = r.v2Body, RemoteWriteProtoMsgV2
+	} else if r.protoMsg == RemoteWriteProtoMsgV2 {
+		// The downstream does not answer with the written counts of 2.0 requests, as it receives a 1.0 request.
+		samples, histograms, exemplars := countWritten(r.wreq)
+		w.Header().Set(samplesWrittenHeader, strconv.Itoa(samples))
+		w.Header().Set(histogramsWrittenHeader, strconv.Itoa(histograms))
+		w.Header().Set(exemplarsWrittenHeader, strconv.Itoa(exemplars))
+	}
+
+	proxy := *up.remoteWriteProxy // 浅拷贝
 	originalDirector := proxy.Director
 	proxy.Director = func(req *http.Request) {
 		originalDirector(req)
-		req.Body = io.NopCloser(bytes.NewReader(body))
+		req.Body = io.NopCloser(bytes.NewReader(outBody))
+		req.ContentLength = int64(len(outBody))
+		req.Header.Del("Content-Encoding")
+		req.Header.Set("Content-Type", outProtoMsg.ContentType())
+		req.Header.Set(remoteWriteVersionHeader, outProtoMsg.Version())
 	}
-	proxy.ServeHTTP(w, req)
+	sw := &statusResponseWriter{ResponseWriter: w, code: http.StatusOK}
+	proxy.ServeHTTP(sw, req)
 
-	var wg sync.WaitGroup
-	var tenantHeader = make(http.Header)
-	if tenantId := req.Header.Get(h.options.TenantHeader); tenantId != "" {
-		tenantHeader.Set(h.options.TenantHeader, tenantId)
+	// The request is acknowledged once the primary target accepted it, and forwarded to the external targets asynchronously.
+	if sw.code/100 != 2 {
+		return
+	}
+	commitSeries()
+	tenantId := req.Header.Get(up.tenantHeader)
+	for _, q := range up.externalRWQueues {
+		q.Enqueue(tenantId, r.body, r.v2Body)
 	}
 
-	for _, writeClient := range h.externalRWClients {
-		wg.Add(1)
-		ep := writeClient.Endpoint()
-
-		go func(writeClient *remoteWriteClient) {
-			defer wg.Done()
-			result := writeClient.Send(ctx, body, tenantHeader)
-			if result.err != nil {
-				level.Error(h.logger).Log("msg", "failed to forward request", "endpoint", ep, "err", result.err)
+	if tenantId != "" {
+		if r.wreq == nil {
+			if r.wreq, err = decodeWriteRequest(r.body); err != nil {
+				level.Warn(h.logger).Log("msg", "failed to decode remote write request for usage accounting", "tenant", tenantId, "err", err)
+				return
 			}
-			h.remoteWriteRequestsCounter.WithLabelValues(ep, strconv.Itoa(int(result.code))).Inc()
-		}(writeClient)
+		}
+		h.usageTracker.record(tenantId, r.protocol, remoteWriteUsage(r.wreq))
 	}
-	wg.Wait()
-
 }
 
 func (h *Handler) otlpReceive(w http.ResponseWriter, req *http.Request) {
-	if h.remoteWriteProxy == nil {
+	up := h.upstreams.Load()
+	if up.remoteWriteProxy == nil {
 		http.Error(w, "There is no remote write targets configured for the server", http.StatusNotAcceptable)
 		return
 	}
@@ -384,10 +772,73 @@ func (h *Handler) otlpReceive(w http.ResponseWriter, req *http.Request) {
 	requestInfo, found := requestInfoFrom(ctx)
 
 	if found && requestInfo.TenantId != "" {
-		req.Header.Set(h.options.TenantHeader, requestInfo.TenantId)
+		req.Header.Set(up.tenantHeader, requestInfo.TenantId)
+	}
+
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer req.Body.Close()
+
+	decodeReq := req.Clone(ctx)
+	decodeReq.Body = io.NopCloser(bytes.NewReader(body))
+	ereq, err := remote.DecodeOTLPWriteRequest(decodeReq)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
-	h.remoteWriteProxy.ServeHTTP(w, req)
+	// Translated requests take the way of remote write requests, including the tenant label enforcement.
+	if up.otlp.translate() {
+		wreq, err := translateOTLP(ctx, h.logger, ereq, up.otlp)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if body, err = encodeWriteRequest(wreq); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		req.URL.Path, req.URL.RawPath = apiGlobalPrefix+epReceive, ""
+		h.forwardWrite(w, req, up, &writeRequest{
+			wreq:     wreq,
+			body:     body,
+			protoMsg: RemoteWriteProtoMsgV1,
+			protocol: protocolOTLP,
+		})
+		return
+	}
+
+	if found && requestInfo.TenantId != "" {
+		changed, err := enforceOTLPTenantAttribute(ereq, up.tenantLabelName, requestInfo.TenantId, up.tenantLabelEnforcement)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if changed {
+			if body, err = encodeOTLPRequest(ereq, req.Header.Get("Content-Type")); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			req.Header.Del("Content-Encoding")
+		}
+	}
+
+	proxy := *up.remoteWriteProxy
+	originalDirector := proxy.Director
+	proxy.Director = func(req *http.Request) {
+		originalDirector(req)
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		req.ContentLength = int64(len(body))
+	}
+	sw := &statusResponseWriter{ResponseWriter: w, code: http.StatusOK}
+	proxy.ServeHTTP(sw, req)
+
+	if sw.code/100 == 2 && found && requestInfo.TenantId != "" {
+		h.usageTracker.record(requestInfo.TenantId, protocolOTLP, otlpUsage(ereq))
+	}
 }
 
 func NewSingleHostReverseProxy(target *url.URL, transport http.RoundTripper) *httputil.ReverseProxy {
@@ -441,3 +892,30 @@ func difference(a, b []string) []string {
 
 	return set
 }
+
+// statusResponseWriter records the status code and the size of the response.
+type statusResponseWriter struct {
+	http.ResponseWriter
+	code  int
+	bytes int
+}
+
+func (w *statusResponseWriter) WriteHeader(code int) {
+	w.code = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusResponseWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += n
+	return n, err
+}
+
+// Flush implements http.Flusher for handlers that stream their response.
+func (w *statusResponseWriter) Flush() {
+	_ = http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *statusResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -1,9 +1,22 @@
 package monitoringgateway
 
 import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"testing"
+	"time"
 
+	"github.com/golang/snappy"
 	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/prompb"
+	writev2 "github.com/prometheus/prometheus/prompb/io/prometheus/write/v2"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/pmetric/pmetricotlp"
 )
 
 func TestDifference(t *testing.T) {
@@ -17,3 +30,233 @@ func TestDifference(t *testing.T) {
 		t.Fatal(diff)
 	}
 }
+
+func TestTenantSetQuery(t *testing.T) {
+	var gotQuery string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		gotQuery = req.URL.Query().Get("query")
+	}))
+	defer upstream.Close()
+
+	upstreamURL, _ := url.Parse(upstream.URL)
+	h := NewHandler(nil, prometheus.NewRegistry(), &Options{
+		TenantLabelName:         "tenant_id",
+		QueryProxy:              NewSingleHostReverseProxy(upstreamURL, http.DefaultTransport),
+		EnabledTenantsAdmission: true,
+	})
+	if err := h.SetAdmissionControlHandler(AdmissionControlConfig{Tenants: []string{"a", "b.c"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	h.Router().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/a|b.c/api/v1/query?query=up", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d: %s", rec.Code, rec.Body.String())
+	}
+	if want := `up{tenant_id=~"a|b\\.c"}`; gotQuery != want {
+		t.Fatalf("expected query %s, got %s", want, gotQuery)
+	}
+
+	rec = httptest.NewRecorder()
+	h.Router().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/a|d/api/v1/query?query=up", nil))
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("expected status %d, got %d", http.StatusForbidden, rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	h.Router().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/a|b.c/api/v1/receive", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected status %d, got %d", http.StatusBadRequest, rec.Code)
+	}
+
+	for _, target := range []string{"/a|/api/v1/receive", "/a||b.c/api/v1/query?query=up", "/a|a/api/v1/receive", "/|/api/v1/query?query=up"} {
+		rec = httptest.NewRecorder()
+		h.Router().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, target, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("expected status %d for %s, got %d", http.StatusBadRequest, target, rec.Code)
+		}
+	}
+}
+
+func TestRemoteWriteV2(t *testing.T) {
+	var (
+		gotContentType string
+		gotRequest     *prompb.WriteRequest
+	)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		gotContentType = req.Header.Get("Content-Type")
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		if gotRequest, err = decodeWriteRequest(body); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer upstream.Close()
+	upstreamURL, _ := url.Parse(upstream.URL)
+
+	h := NewHandler(nil, prometheus.NewRegistry(), &Options{
+		TenantHeader:     "WHIZARD-TENANT",
+		TenantLabelName:  "tenant_id",
+		RemoteWriteProxy: NewSingleHostReverseProxy(upstreamURL, http.DefaultTransport),
+	})
+
+	st := writev2.NewSymbolTable()
+	v2req := &writev2.Request{Timeseries: []writev2.TimeSeries{{
+		LabelsRefs: st.SymbolizeLabels(labels.FromStrings("__name__", "up", "job", "a"), nil),
+		Samples:    []writev2.Sample{{Value: 1, Timestamp: 10}},
+		Metadata: writev2.Metadata{
+			Type:    writev2.Metadata_METRIC_TYPE_GAUGE,
+			HelpRef: st.Symbolize("Whether the target is up."),
+		},
+	}}}
+	v2req.Symbols = st.Symbols()
+	data, err := v2req.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/t1/api/v1/receive", bytes.NewReader(snappy.Encode(nil, data)))
+	req.Header.Set("Content-Type", RemoteWriteProtoMsgV2.ContentType())
+	rec := httptest.NewRecorder()
+	h.Router().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d: %s", rec.Code, rec.Body.String())
+	}
+	if got := rec.Header().Get(samplesWrittenHeader); got != "1" {
+		t.Fatalf("expected 1 sample written, got %q", got)
+	}
+	if gotContentType != RemoteWriteProtoMsgV1.ContentType() {
+		t.Fatalf("expected the request to be translated, got content type %q", gotContentType)
+	}
+	want := &prompb.WriteRequest{
+		Timeseries: []prompb.TimeSeries{{
+			Labels:  []prompb.Label{{Name: "__name__", Value: "up"}, {Name: "job", Value: "a"}},
+			Samples: []prompb.Sample{{Value: 1, Timestamp: 10}},
+		}},
+		Metadata: []prompb.MetricMetadata{{
+			Type:             prompb.MetricMetadata_GAUGE,
+			MetricFamilyName: "up",
+			Help:             "Whether the target is up.",
+		}},
+	}
+	if diff := cmp.Diff(want, gotRequest); diff != "" {
+		t.Fatal(diff)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/t1/api/v1/receive", bytes.NewReader(nil))
+	req.Header.Set("Content-Type", "application/json")
+	rec = httptest.NewRecorder()
+	h.Router().ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnsupportedMediaType {
+		t.Fatalf("expected status 415, got %d", rec.Code)
+	}
+}
+
+func TestGlobalRemoteWrite(t *testing.T) {
+	var gotTenant string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		gotTenant = req.Header.Get("WHIZARD-TENANT")
+	}))
+	defer upstream.Close()
+	upstreamURL, _ := url.Parse(upstream.URL)
+
+	h := NewHandler(nil, prometheus.NewRegistry(), &Options{
+		TenantHeader:           "WHIZARD-TENANT",
+		TenantLabelName:        "tenant_id",
+		TenantLabelEnforcement: TenantLabelEnforcementReject,
+		RemoteWriteProxy:       NewSingleHostReverseProxy(upstreamURL, http.DefaultTransport),
+	})
+
+	write := func(tenant, labelValue string) *httptest.ResponseRecorder {
+		data, err := (&prompb.WriteRequest{Timeseries: []prompb.TimeSeries{{
+			Labels:  []prompb.Label{{Name: "__name__", Value: "up"}, {Name: "tenant_id", Value: labelValue}},
+			Samples: []prompb.Sample{{Value: 1, Timestamp: 10}},
+		}}}).Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/receive", bytes.NewReader(snappy.Encode(nil, data)))
+		req.Header.Set("Content-Type", RemoteWriteProtoMsgV1.ContentType())
+		req.Header.Set("WHIZARD-TENANT", tenant)
+		rec := httptest.NewRecorder()
+		h.Router().ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := write("a", "b"); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected the series of another tenant to be rejected, got status %d", rec.Code)
+	}
+	if rec := write("a|b", "a"); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected a tenant set to be rejected, got status %d", rec.Code)
+	}
+	if rec := write("a", "a"); rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d: %s", rec.Code, rec.Body.String())
+	}
+	if gotTenant != "a" {
+		t.Fatalf("expected tenant a, got %q", gotTenant)
+	}
+}
+
+func TestOTLPTranslation(t *testing.T) {
+	var (
+		gotPath    string
+		gotRequest *prompb.WriteRequest
+	)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		gotPath = req.URL.Path
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		if gotRequest, err = decodeWriteRequest(body); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer upstream.Close()
+	upstreamURL, _ := url.Parse(upstream.URL)
+
+	disabled := false
+	h := NewHandler(nil, prometheus.NewRegistry(), &Options{
+		TenantHeader:           "WHIZARD-TENANT",
+		TenantLabelName:        "tenant_id",
+		TenantLabelEnforcement: TenantLabelEnforcementOverwrite,
+		RemoteWriteProxy:       NewSingleHostReverseProxy(upstreamURL, http.DefaultTransport),
+		OTLP:                   OTLPConfig{EnableTargetInfo: &disabled, PromoteResourceAttributes: []string{"service.name"}},
+	})
+
+	ereq := pmetricotlp.NewExportRequest()
+	rm := ereq.Metrics().ResourceMetrics().AppendEmpty()
+	rm.Resource().Attributes().PutStr("service.name", "api")
+	m := rm.ScopeMetrics().AppendEmpty().Metrics().AppendEmpty()
+	m.SetName("up")
+	dp := m.SetEmptyGauge().DataPoints().AppendEmpty()
+	dp.Attributes().PutStr("tenant_id", "t2")
+	dp.SetTimestamp(pcommon.Timestamp(10 * time.Millisecond))
+	dp.SetDoubleValue(1)
+	data, err := ereq.MarshalProto()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/t1/api/v1/otlp", bytes.NewReader(data))
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	rec := httptest.NewRecorder()
+	h.Router().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d: %s", rec.Code, rec.Body.String())
+	}
+	if gotPath != "/api/v1/receive" {
+		t.Fatalf("expected the request to be sent to the remote write endpoint, got %s", gotPath)
+	}
+	want := []prompb.TimeSeries{{
+		Labels:  []prompb.Label{{Name: "__name__", Value: "up"}, {Name: "job", Value: "api"}, {Name: "service_name", Value: "api"}, {Name: "tenant_id", Value: "t1"}},
+		Samples: []prompb.Sample{{Value: 1, Timestamp: 10}},
+	}}
+	if diff := cmp.Diff(want, gotRequest.Timeseries); diff != "" {
+		t.Fatal(diff)
+	}
+}
@@ -17,17 +17,24 @@ const (
 	targetMatchersParam = "match_target[]"
 )
 
-func enforceQueryValues(e *injectproxy.PromQLEnforcer, v url.Values) (values string, hasQuery bool, err error) {
-	// If no values were given or no query is present,
-	// e.g. because the query came in the POST body
-	// but the URL query string was passed, then finish early.
+// parseQuery parses the query of the given values. It returns nil if no query is present.
+func parseQuery(v url.Values) (parser.Expr, error) {
 	if v.Get(queryParam) == "" {
-		return v.Encode(), false, nil
+		return nil, nil
 	}
 	expr, err := parser.ParseExpr(v.Get(queryParam))
 	if err != nil {
-		queryParseError := newQueryParseError(err)
-		return "", true, queryParseError
+		return nil, newQueryParseError(err)
+	}
+	return expr, nil
+}
+
+func enforceQueryValues(e *injectproxy.PromQLEnforcer, v url.Values, expr parser.Expr) (values string, hasQuery bool, err error) {
+	// If no values were given or no query is present,
+	// e.g. because the query came in the POST body
+	// but the URL query string was passed, then finish early.
+	if expr == nil {
+		return v.Encode(), false, nil
 	}
 
 	if err := e.EnforceNode(expr); err != nil {
@@ -67,10 +74,10 @@ func newEnforceLabelError(err error) enforceLabelError {
 	return enforceLabelError{msg: fmt.Sprintf("error enforcing label %q", err.Error())}
 }
 
-func injectMatcher(q url.Values, matcher *labels.Matcher, matchersParam string) error {
+func injectMatcher(q url.Values, matchersParam string, enforced ...*labels.Matcher) error {
 	matchers := q[matchersParam]
 	if len(matchers) == 0 {
-		q.Set(matchersParam, matchersToString(matcher))
+		q.Set(matchersParam, matchersToString(enforced...))
 	} else {
 		// Inject label to existing matchers.
 		for i, m := range matchers {
@@ -78,7 +85,7 @@ func injectMatcher(q url.Values, matcher *labels.Matcher, matchersParam string)
 			if err != nil {
 				return err
 			}
-			matchers[i] = matchersToString(append(ms, matcher)...)
+			matchers[i] = matchersToString(append(ms, enforced...)...)
 		}
 		q[matchersParam] = matchers
 	}
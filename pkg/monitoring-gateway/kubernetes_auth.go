@@ -0,0 +1,191 @@
+package monitoringgateway
+
+import (
+	"context"
+	"crypto/sha256"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// KubernetesAuthConfig configures the authentication of bearer tokens by the TokenReview API, and the authorization of
+// the access to tenants by the SubjectAccessReview API.
+type KubernetesAuthConfig struct {
+	// Audiences are the audiences the tokens must be issued for. If empty, the audience of the API server is expected.
+	Audiences []string
+	// ResourceGroup and Resource name the virtual resource whose objects are the tenants,
+	// e.g. tenants.monitoring.whizard.io/<tenant>.
+	ResourceGroup string
+	Resource      string
+	// CacheTTL is how long the results of the reviews are cached.
+	CacheTTL time.Duration
+}
+
+// KubernetesAuthenticator authenticates bearer tokens, e.g. of ServiceAccounts, by the TokenReview API.
+// The access of the caller to a tenant is authorized by a SubjectAccessReview of the verb get for reads, and create for
+// writes, on the tenant as object of the virtual resource, so that it can be granted by RBAC.
+type KubernetesAuthenticator struct {
+	logger log.Logger
+	config KubernetesAuthConfig
+	client kubernetes.Interface
+
+	tokens    *ttlCache[[sha256.Size]byte, *authenticationv1.UserInfo]
+	decisions *ttlCache[string, bool]
+}
+
+// NewKubernetesAuthenticator creates a new KubernetesAuthenticator.
+func NewKubernetesAuthenticator(logger log.Logger, client kubernetes.Interface, config KubernetesAuthConfig) (*KubernetesAuthenticator, error) {
+	if logger == nil {
+		logger = log.NewNopLogger()
+	}
+	if config.Resource == "" {
+		return nil, errors.New("the resource of the tenants must be configured")
+	}
+
+	return &KubernetesAuthenticator{
+		logger:    logger,
+		config:    config,
+		client:    client,
+		tokens:    newTTLCache[[sha256.Size]byte, *authenticationv1.UserInfo](config.CacheTTL),
+		decisions: newTTLCache[string, bool](config.CacheTTL),
+	}, nil
+}
+
+func (a *KubernetesAuthenticator) AuthenticateRequest(req *http.Request) (*Identity, bool) {
+	token, ok := bearerToken(req)
+	if !ok {
+		return nil, false
+	}
+
+	key := sha256.Sum256([]byte(token))
+	user, ok := a.tokens.get(key)
+	if !ok {
+		var err error
+		if user, err = a.reviewToken(req.Context(), token); err != nil {
+			level.Warn(a.logger).Log("msg", "failed to review bearer token", "err", err)
+			return nil, false
+		}
+		a.tokens.set(key, user)
+	}
+	if user == nil {
+		return nil, false
+	}
+
+	ctx := req.Context()
+	return &Identity{
+		Name:   user.Username,
+		Groups: user.Groups,
+		authorize: func(tenant, verb string) bool {
+			return a.authorize(ctx, user, tenant, verb)
+		},
+	}, true
+}
+
+// reviewToken returns the user of the token, or nil if the token is not authenticated.
+func (a *KubernetesAuthenticator) reviewToken(ctx context.Context, token string) (*authenticationv1.UserInfo, error) {
+	review, err := a.client.AuthenticationV1().TokenReviews().Create(ctx, &authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{Token: token, Audiences: a.config.Audiences},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return nil, errors.Wrap(err, "creating token review")
+	}
+	if !review.Status.Authenticated {
+		level.Debug(a.logger).Log("msg", "bearer token is not authenticated", "err", review.Status.Error)
+		return nil, nil
+	}
+	return &review.Status.User, nil
+}
+
+func (a *KubernetesAuthenticator) authorize(ctx context.Context, user *authenticationv1.UserInfo, tenant, verb string) bool {
+	key := strings.Join([]string{user.UID, user.Username, strings.Join(user.Groups, ","), tenant, verb}, "/")
+	if allowed, ok := a.decisions.get(key); ok {
+		return allowed
+	}
+
+	extra := make(map[string]authorizationv1.ExtraValue, len(user.Extra))
+	for k, v := range user.Extra {
+		extra[k] = authorizationv1.ExtraValue(v)
+	}
+	review, err := a.client.AuthorizationV1().SubjectAccessReviews().Create(ctx, &authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			User:   user.Username,
+			UID:    user.UID,
+			Groups: user.Groups,
+			Extra:  extra,
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Group:    a.config.ResourceGroup,
+				Resource: a.config.Resource,
+				Name:     tenant,
+				Verb:     verb,
+			},
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		// Failed reviews are not cached, so that they are retried by the next request.
+		level.Warn(a.logger).Log("msg", "failed to review access to tenant", "user", user.Username, "tenant", tenant, "verb", verb, "err", err)
+		return false
+	}
+
+	a.decisions.set(key, review.Status.Allowed)
+	return review.Status.Allowed
+}
+
+// ttlCache caches values for a fixed time.
+type ttlCache[K comparable, V any] struct {
+	ttl time.Duration
+
+	mtx     sync.Mutex
+	entries map[K]ttlCacheEntry[V]
+	// nextPurge is when the expired entries are removed next.
+	nextPurge time.Time
+}
+
+type ttlCacheEntry[V any] struct {
+	value   V
+	expires time.Time
+}
+
+func newTTLCache[K comparable, V any](ttl time.Duration) *ttlCache[K, V] {
+	return &ttlCache[K, V]{ttl: ttl, entries: make(map[K]ttlCacheEntry[V])}
+}
+
+func (c *ttlCache[K, V]) get(key K) (V, bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok || time.Now().After(e.expires) {
+		var zero V
+		return zero, false
+	}
+	return e.value, true
+}
+
+func (c *ttlCache[K, V]) set(key K, value V) {
+	if c.ttl <= 0 {
+		return
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	now := time.Now()
+	if now.After(c.nextPurge) {
+		for k, e := range c.entries {
+			if now.After(e.expires) {
+				delete(c.entries, k)
+			}
+		}
+		c.nextPurge = now.Add(c.ttl)
+	}
+	c.entries[key] = ttlCacheEntry[V]{value: value, expires: now.Add(c.ttl)}
+}
@@ -0,0 +1,83 @@
+package monitoringgateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestKubernetesAuthenticator(t *testing.T) {
+	var reviews int
+	client := fake.NewClientset()
+	client.PrependReactor("create", "tokenreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authenticationv1.TokenReview)
+		if review.Spec.Token == "sa-token" {
+			review.Status = authenticationv1.TokenReviewStatus{
+				Authenticated: true,
+				User:          authenticationv1.UserInfo{Username: "system:serviceaccount:team-a:agent"},
+			}
+		}
+		return true, review, nil
+	})
+	client.PrependReactor("create", "subjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		reviews++
+		review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SubjectAccessReview)
+		attrs := review.Spec.ResourceAttributes
+		// The agent may read and write tenant a, and only read tenant b.
+		review.Status.Allowed = attrs.Group == "monitoring.whizard.io" && attrs.Resource == "tenants" &&
+			(attrs.Name == "a" || attrs.Name == "b" && attrs.Verb == "get")
+		return true, review, nil
+	})
+
+	a, err := NewKubernetesAuthenticator(nil, client, KubernetesAuthConfig{
+		ResourceGroup: "monitoring.whizard.io",
+		Resource:      "tenants",
+		CacheTTL:      time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewHandler(nil, prometheus.NewRegistry(), &Options{TenantLabelName: "tenant_id", Authenticator: a})
+
+	serve := func(token, method, target string) int {
+		req := httptest.NewRequest(method, target, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		h.Router().ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// Authorized requests reach the handlers, which reject them as no downstream is configured.
+	for _, tc := range []struct {
+		token, method, target string
+		code                  int
+	}{
+		{"sa-token", http.MethodGet, "/a/api/v1/query?query=up", http.StatusNotAcceptable},
+		{"sa-token", http.MethodPost, "/a/api/v1/receive", http.StatusNotAcceptable},
+		{"sa-token", http.MethodGet, "/b/api/v1/query?query=up", http.StatusNotAcceptable},
+		{"sa-token", http.MethodPost, "/b/api/v1/receive", http.StatusForbidden},
+		{"sa-token", http.MethodGet, "/c/api/v1/query?query=up", http.StatusForbidden},
+		{"other-token", http.MethodGet, "/a/api/v1/query?query=up", http.StatusUnauthorized},
+	} {
+		if code := serve(tc.token, tc.method, tc.target); code != tc.code {
+			t.Fatalf("expected status %d for %s %s, got %d", tc.code, tc.method, tc.target, code)
+		}
+	}
+
+	// The decisions are cached.
+	n := reviews
+	if code := serve("sa-token", http.MethodGet, "/a/api/v1/query?query=up"); code != http.StatusNotAcceptable {
+		t.Fatalf("unexpected status %d", code)
+	}
+	if reviews != n {
+		t.Fatalf("expected cached decision, got %d more reviews", reviews-n)
+	}
+}
@@ -0,0 +1,182 @@
+package monitoringgateway
+
+import (
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"gopkg.in/yaml.v2"
+)
+
+// TenantLimits are the limits enforced by the gateway for a single tenant.
+// A zero value means the corresponding limit is disabled.
+type TenantLimits struct {
+	// IngestionRate is the number of samples (including histograms) per second a tenant may remote write.
+	IngestionRate float64 `yaml:"ingestion_rate,omitempty"`
+	// IngestionBurstSize is the number of samples a tenant may send in a single burst. Defaults to the ingestion rate.
+	IngestionBurstSize int `yaml:"ingestion_burst_size,omitempty"`
+	// IngestionBytesRate is the number of request bytes per second a tenant may remote write.
+	IngestionBytesRate float64 `yaml:"ingestion_bytes_rate,omitempty"`
+	// IngestionBytesBurstSize is the number of request bytes a tenant may send in a single burst. Defaults to the ingestion bytes rate.
+	IngestionBytesBurstSize int `yaml:"ingestion_bytes_burst_size,omitempty"`
+	// MaxSeriesPerRequest is the maximum number of series in a single remote write request.
+	MaxSeriesPerRequest int `yaml:"max_series_per_request,omitempty"`
+
+	// MaxLabelNamesPerSeries is the maximum number of labels of a written series.
+	MaxLabelNamesPerSeries int `yaml:"max_label_names_per_series,omitempty"`
+	// MaxLabelNameLength is the maximum length of the label names of a written series.
+	MaxLabelNameLength int `yaml:"max_label_name_length,omitempty"`
+	// MaxLabelValueLength is the maximum length of the label values of a written series.
+	MaxLabelValueLength int `yaml:"max_label_value_length,omitempty"`
+	// RejectOldSamplesMaxAge is how far in the past the samples of a written series may be.
+	RejectOldSamplesMaxAge model.Duration `yaml:"reject_old_samples_max_age,omitempty"`
+	// CreationGracePeriod is how far in the future the samples of a written series may be.
+	CreationGracePeriod model.Duration `yaml:"creation_grace_period,omitempty"`
+	// ValidateSeriesFormat validates the metric and label names of written series, and rejects duplicate label names.
+	ValidateSeriesFormat bool `yaml:"validate_series_format,omitempty"`
+	// DropInvalidSeries drops the invalid series and samples of a remote write request instead of rejecting the request.
+	DropInvalidSeries bool `yaml:"drop_invalid_series,omitempty"`
+	// DropRules drop the series they match before the series are forwarded.
+	DropRules []DropRule `yaml:"drop_rules,omitempty"`
+	// MaxSeriesPerMetric is the maximum number of active series of a metric, new series of a metric at the limit are dropped.
+	MaxSeriesPerMetric int `yaml:"max_series_per_metric,omitempty"`
+
+	// MaxConcurrentQueries is the maximum number of queries a tenant may run at the same time.
+	MaxConcurrentQueries int `yaml:"max_concurrent_queries,omitempty"`
+	// MaxQueryRange is the maximum time range of a range query.
+	MaxQueryRange model.Duration `yaml:"max_query_range,omitempty"`
+	// MinQueryStep is the minimum step of a range query, that is the maximum resolution a tenant may query.
+	MinQueryStep model.Duration `yaml:"min_query_step,omitempty"`
+	// MaxQueryLookback is how far back in time series, labels and label values may be queried.
+	MaxQueryLookback model.Duration `yaml:"max_query_lookback,omitempty"`
+	// QueryTimeout overrides the query timeout of the query target for the tenant.
+	QueryTimeout model.Duration `yaml:"query_timeout,omitempty"`
+	// MaxQueryCost is the budget of the estimated cost of a query, see estimateQueryCost.
+	MaxQueryCost float64 `yaml:"max_query_cost,omitempty"`
+	// QueryCostDryRun only logs and counts the queries over the cost budget instead of rejecting them.
+	QueryCostDryRun bool `yaml:"query_cost_dry_run,omitempty"`
+}
+
+// LimitsConfig holds the default limits and the per-tenant overrides.
+// Overrides of a tenant are applied on top of the defaults.
+type LimitsConfig struct {
+	Defaults TenantLimits            `yaml:"defaults,omitempty"`
+	Tenants  map[string]TenantLimits `yaml:"tenants,omitempty"`
+}
+
+// ForTenant returns the limits of the given tenant.
+func (c *LimitsConfig) ForTenant(tenant string) TenantLimits {
+	if c == nil {
+		return TenantLimits{}
+	}
+	if l, ok := c.Tenants[tenant]; ok {
+		return l
+	}
+	return c.Defaults
+}
+
+// ParseLimitsConfig parses the raw limits configuration content and returns a LimitsConfig.
+func ParseLimitsConfig(content []byte) (LimitsConfig, error) {
+	var raw struct {
+		Defaults TenantLimits             `yaml:"defaults,omitempty"`
+		Tenants  map[string]yaml.MapSlice `yaml:"tenants,omitempty"`
+	}
+	if err := yaml.UnmarshalStrict(content, &raw); err != nil {
+		return LimitsConfig{}, errors.Wrap(err, "parsing limits config YAML")
+	}
+
+	config := LimitsConfig{
+		Defaults: raw.Defaults,
+		Tenants:  make(map[string]TenantLimits, len(raw.Tenants)),
+	}
+	for tenant, overrides := range raw.Tenants {
+		// Re-encode the overrides so that they can be unmarshalled on top of the defaults.
+		b, err := yaml.Marshal(overrides)
+		if err != nil {
+			return LimitsConfig{}, errors.Wrapf(err, "parsing limits of tenant %s", tenant)
+		}
+		limits := raw.Defaults
+		if err := yaml.UnmarshalStrict(b, &limits); err != nil {
+			return LimitsConfig{}, errors.Wrapf(err, "parsing limits of tenant %s", tenant)
+		}
+		config.Tenants[tenant] = limits
+	}
+	return config, nil
+}
+
+// NewLimitsConfigWatcher creates a new ConfigWatcher for the tenant limits configuration.
+func NewLimitsConfigWatcher(logger log.Logger, reg prometheus.Registerer, path string, interval model.Duration) (*ConfigWatcher[LimitsConfig], error) {
+	return newConfigWatcher(logger, reg, "whizard_tenant_limits_config", path, interval, ParseLimitsConfig)
+}
+
+// limitsCollector exports the configured default and per-tenant limits as metrics.
+type limitsCollector struct {
+	limits *atomic.Pointer[LimitsConfig]
+
+	defaultsDesc  *prometheus.Desc
+	overridesDesc *prometheus.Desc
+}
+
+func newLimitsCollector(limits *atomic.Pointer[LimitsConfig]) *limitsCollector {
+	return &limitsCollector{
+		limits: limits,
+		defaultsDesc: prometheus.NewDesc(
+			"whizard_gateway_limits_defaults",
+			"Default limits enforced by the gateway, labeled by limit. Durations are in seconds.",
+			[]string{"limit"}, nil,
+		),
+		overridesDesc: prometheus.NewDesc(
+			"whizard_gateway_limits_overrides",
+			"Per-tenant limits enforced by the gateway, labeled by limit and tenant. Durations are in seconds.",
+			[]string{"limit", "tenant"}, nil,
+		),
+	}
+}
+
+func (c *limitsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.defaultsDesc
+	ch <- c.overridesDesc
+}
+
+func (c *limitsCollector) Collect(ch chan<- prometheus.Metric) {
+	config := c.limits.Load()
+	if config == nil {
+		return
+	}
+
+	for name, value := range limitValues(config.Defaults) {
+		ch <- prometheus.MustNewConstMetric(c.defaultsDesc, prometheus.GaugeValue, value, name)
+	}
+	for tenant, limits := range config.Tenants {
+		for name, value := range limitValues(limits) {
+			ch <- prometheus.MustNewConstMetric(c.overridesDesc, prometheus.GaugeValue, value, name, tenant)
+		}
+	}
+}
+
+var durationType = reflect.TypeOf(model.Duration(0))
+
+// limitValues returns the numeric limits keyed by their YAML names.
+func limitValues(l TenantLimits) map[string]float64 {
+	values := make(map[string]float64)
+
+	v := reflect.ValueOf(l)
+	for i := 0; i < v.NumField(); i++ {
+		name, _, _ := strings.Cut(v.Type().Field(i).Tag.Get("yaml"), ",")
+		f := v.Field(i)
+		switch {
+		case f.Type() == durationType:
+			values[name] = time.Duration(f.Int()).Seconds()
+		case f.CanInt():
+			values[name] = float64(f.Int())
+		case f.CanFloat():
+			values[name] = f.Float()
+		}
+	}
+	return values
+}
@@ -0,0 +1,83 @@
+package monitoringgateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseLimitsConfig(t *testing.T) {
+	content := `
+defaults:
+  ingestion_rate: 1000
+  max_series_per_request: 100
+tenants:
+  a:
+    ingestion_rate: 10
+  b: {}
+`
+	c, err := ParseLimitsConfig([]byte(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(TenantLimits{IngestionRate: 10, MaxSeriesPerRequest: 100}, c.ForTenant("a")); diff != "" {
+		t.Fatal(diff)
+	}
+	if diff := cmp.Diff(TenantLimits{IngestionRate: 1000, MaxSeriesPerRequest: 100}, c.ForTenant("b")); diff != "" {
+		t.Fatal(diff)
+	}
+	if diff := cmp.Diff(c.Defaults, c.ForTenant("c")); diff != "" {
+		t.Fatal(diff)
+	}
+
+	if _, err := ParseLimitsConfig([]byte("tenants: {a: {unknown: 1}}")); err == nil {
+		t.Fatal("expected error for unknown field")
+	}
+}
+
+func TestCheckIngestionLimits(t *testing.T) {
+	h := &Handler{
+		ingestionRateLimiter:  newTenantRateLimiter(),
+		ingestionBytesLimiter: newTenantRateLimiter(),
+	}
+	limits := TenantLimits{IngestionRate: 10, IngestionBurstSize: 20, MaxSeriesPerRequest: 5}
+
+	if err := h.checkIngestionLimits("a", limits, 100, 5, 20); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err := h.checkIngestionLimits("a", limits, 100, 5, 10)
+	if err == nil || err.reason != reasonRateLimited {
+		t.Fatalf("expected rate limited error, got %v", err)
+	}
+	if err.retryAfter <= 0 || err.retryAfter > 2*time.Second {
+		t.Fatalf("unexpected retry after %v", err.retryAfter)
+	}
+	rec := httptest.NewRecorder()
+	writeRateLimitError(rec, err)
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("expected status %d with Retry-After, got %d", http.StatusTooManyRequests, rec.Code)
+	}
+	// Other tenants have their own bucket.
+	if err := h.checkIngestionLimits("b", limits, 100, 5, 20); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Requests that can never fit in the limits are not to be retried.
+	for _, err := range []*rateLimitError{
+		h.checkIngestionLimits("b", limits, 100, 6, 1),
+		h.checkIngestionLimits("c", limits, 100, 5, 21),
+	} {
+		if err == nil || !err.permanent {
+			t.Fatalf("expected permanent error, got %v", err)
+		}
+		rec := httptest.NewRecorder()
+		writeRateLimitError(rec, err)
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("expected status %d, got %d", http.StatusBadRequest, rec.Code)
+		}
+	}
+}
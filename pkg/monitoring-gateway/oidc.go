@@ -0,0 +1,303 @@
+package monitoringgateway
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/pkg/errors"
+)
+
+// minJWKSRefreshInterval limits how often the JWKS is loaded again when a token is signed by an unknown key.
+const minJWKSRefreshInterval = time.Minute
+
+// OIDCConfig configures the authentication of bearer tokens issued by an OIDC provider.
+type OIDCConfig struct {
+	// IssuerURL is the expected issuer of the tokens.
+	IssuerURL string
+	// Audience is the expected audience of the tokens, e.g. the client ID of the gateway at the issuer.
+	Audience string
+	// SkipAudienceCheck disables the verification of the audience, so that tokens issued for any client are accepted.
+	SkipAudienceCheck bool
+	// JWKSFile is the path of a local JSON Web Key Set used to verify the tokens.
+	JWKSFile string
+	// JWKSURL is the URL of the JSON Web Key Set of the issuer, used if JWKSFile is not set.
+	JWKSURL string
+	// TenantClaim is the claim that holds the tenant, or a list of tenants, the caller may access.
+	TenantClaim string
+	// UsernameClaim is the claim that identifies the caller.
+	UsernameClaim string
+}
+
+// OIDCAuthenticator authenticates bearer tokens against the JSON Web Key Set of an OIDC issuer,
+// and maps a claim of the token to the tenants the caller may access.
+type OIDCAuthenticator struct {
+	logger log.Logger
+	config OIDCConfig
+	client *http.Client
+	parser *jwt.Parser
+
+	mtx       sync.RWMutex
+	keys      map[string]crypto.PublicKey
+	lastFetch time.Time
+}
+
+// NewOIDCAuthenticator creates a new OIDCAuthenticator and loads the JSON Web Key Set.
+func NewOIDCAuthenticator(logger log.Logger, config OIDCConfig) (*OIDCAuthenticator, error) {
+	if logger == nil {
+		logger = log.NewNopLogger()
+	}
+	if config.JWKSFile == "" && config.JWKSURL == "" {
+		return nil, errors.New("either a JWKS file or a JWKS URL must be configured")
+	}
+	if config.IssuerURL == "" {
+		return nil, errors.New("the issuer URL must be configured")
+	}
+	if config.Audience == "" && !config.SkipAudienceCheck {
+		return nil, errors.New("the audience must be configured, unless the audience check is skipped")
+	}
+	if config.TenantClaim == "" {
+		return nil, errors.New("the tenant claim must be configured")
+	}
+	if config.UsernameClaim == "" {
+		config.UsernameClaim = "sub"
+	}
+
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuer(config.IssuerURL),
+	}
+	if !config.SkipAudienceCheck {
+		opts = append(opts, jwt.WithAudience(config.Audience))
+	}
+
+	a := &OIDCAuthenticator{
+		logger: logger,
+		config: config,
+		client: &http.Client{Timeout: 30 * time.Second},
+		parser: jwt.NewParser(opts...),
+	}
+	if err := a.refreshKeys(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *OIDCAuthenticator) AuthenticateRequest(req *http.Request) (*Identity, bool) {
+	raw, ok := bearerToken(req)
+	if !ok {
+		return nil, false
+	}
+
+	claims := jwt.MapClaims{}
+	if _, err := a.parser.ParseWithClaims(raw, claims, a.keyFunc); err != nil {
+		level.Debug(a.logger).Log("msg", "failed to verify bearer token", "err", err)
+		return nil, false
+	}
+
+	name, _ := claims[a.config.UsernameClaim].(string)
+	return &Identity{Name: name, Tenants: claimStrings(claims[a.config.TenantClaim])}, true
+}
+
+// keyFunc returns the key the token was signed with, refreshing the key set if the key is unknown.
+func (a *OIDCAuthenticator) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	if key, ok := a.lookupKey(kid); ok {
+		return key, nil
+	}
+
+	a.mtx.RLock()
+	// The keys are loaded again from the file or the URL, so that keys rotated by the issuer are picked up.
+	refresh := time.Since(a.lastFetch) > minJWKSRefreshInterval
+	a.mtx.RUnlock()
+	if refresh {
+		if err := a.refreshKeys(); err != nil {
+			level.Warn(a.logger).Log("msg", "failed to refresh JWKS", "err", err)
+		}
+		if key, ok := a.lookupKey(kid); ok {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+func (a *OIDCAuthenticator) lookupKey(kid string) (crypto.PublicKey, bool) {
+	a.mtx.RLock()
+	defer a.mtx.RUnlock()
+
+	if kid == "" && len(a.keys) == 1 {
+		for _, key := range a.keys {
+			return key, true
+		}
+	}
+	key, ok := a.keys[kid]
+	return key, ok
+}
+
+// refreshKeys loads the JSON Web Key Set from the configured file or URL.
+func (a *OIDCAuthenticator) refreshKeys() error {
+	var (
+		content []byte
+		err     error
+	)
+	if a.config.JWKSFile != "" {
+		content, err = readFile(a.logger, a.config.JWKSFile)
+	} else {
+		content, err = a.fetchJWKS()
+	}
+	if err != nil {
+		return errors.Wrap(err, "loading JWKS")
+	}
+
+	keys, err := parseJWKS(content)
+	if err != nil {
+		return err
+	}
+
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	a.keys = keys
+	a.lastFetch = time.Now()
+	return nil
+}
+
+func (a *OIDCAuthenticator) fetchJWKS() ([]byte, error) {
+	resp, err := a.client.Get(a.config.JWKSURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("server returned HTTP status %s", resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// jsonWebKey is a public key in the JSON Web Key format (RFC 7517).
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// parseJWKS parses the signing keys of a JSON Web Key Set, keyed by their key ID.
+func parseJWKS(content []byte) (map[string]crypto.PublicKey, error) {
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(content, &jwks); err != nil {
+		return nil, errors.Wrap(err, "parsing JWKS")
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(jwks.Keys))
+	for _, k := range jwks.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			return nil, errors.Wrapf(err, "parsing JWK %q", k.Kid)
+		}
+		if key != nil {
+			keys[k.Kid] = key
+		}
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("JWKS contains no signing keys")
+	}
+	return keys, nil
+}
+
+// publicKey returns the RSA or EC public key, or nil for unsupported key types.
+func (k *jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, nil
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// bearerToken returns the bearer token of the Authorization header.
+func bearerToken(req *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(req.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return "", false
+	}
+	return strings.TrimSpace(token), true
+}
+
+// claimStrings returns the value of a claim that is either a string or a list of strings.
+func claimStrings(v interface{}) []string {
+	switch v := v.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		s := make([]string, 0, len(v))
+		for _, e := range v {
+			if e, ok := e.(string); ok {
+				s = append(s, e)
+			}
+		}
+		return s
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package monitoringgateway
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestOIDCAuthenticator(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwksFile := filepath.Join(t.TempDir(), "jwks.json")
+	writeJWKS := func(kid string, key *rsa.PrivateKey) {
+		jwks, _ := json.Marshal(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": kid,
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+		if err := os.WriteFile(jwksFile, jwks, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeJWKS("k1", key)
+
+	config := OIDCConfig{
+		IssuerURL:   "https://issuer.example.com",
+		JWKSFile:    jwksFile,
+		TenantClaim: "tenants",
+	}
+	// The issuer and the audience are verified unless the audience check is skipped explicitly.
+	for _, c := range []OIDCConfig{{JWKSFile: jwksFile, TenantClaim: "tenants", Audience: "gateway"}, config} {
+		if _, err := NewOIDCAuthenticator(nil, c); err == nil {
+			t.Fatalf("expected error for config %+v", c)
+		}
+	}
+	config.Audience = "gateway"
+	a, err := NewOIDCAuthenticator(nil, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sign := func(claims jwt.MapClaims) string {
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = "k1"
+		s, err := token.SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	exp := time.Now().Add(time.Hour).Unix()
+
+	req := httptest.NewRequest("GET", "/t1/api/v1/query", nil)
+	req.Header.Set("Authorization", "Bearer "+sign(jwt.MapClaims{
+		"iss": "https://issuer.example.com", "aud": "gateway", "sub": "grafana", "exp": exp, "tenants": []string{"t1", "t2"},
+	}))
+	identity, ok := a.AuthenticateRequest(req)
+	if !ok {
+		t.Fatal("expected token to be authenticated")
+	}
+	if diff := cmp.Diff(&Identity{Name: "grafana", Tenants: []string{"t1", "t2"}}, identity, cmp.AllowUnexported(Identity{})); diff != "" {
+		t.Fatal(diff)
+	}
+
+	for _, claims := range []jwt.MapClaims{
+		{"iss": "https://other.example.com", "aud": "gateway", "exp": exp, "tenants": "t1"},
+		{"iss": "https://issuer.example.com", "aud": "other", "exp": exp, "tenants": "t1"},
+		{"iss": "https://issuer.example.com", "exp": exp, "tenants": "t1"},
+		{"iss": "https://issuer.example.com", "aud": "gateway", "exp": time.Now().Add(-time.Hour).Unix(), "tenants": "t1"},
+		{"iss": "https://issuer.example.com", "aud": "gateway", "tenants": "t1"},
+	} {
+		req.Header.Set("Authorization", "Bearer "+sign(claims))
+		if _, ok := a.AuthenticateRequest(req); ok {
+			t.Fatalf("expected token with claims %v to be rejected", claims)
+		}
+	}
+
+	req.Header.Del("Authorization")
+	if _, ok := a.AuthenticateRequest(req); ok {
+		t.Fatal("expected request without token to be rejected")
+	}
+
+	// Keys rotated in the file are loaded once a token is signed by an unknown key.
+	rotated, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeJWKS("k2", rotated)
+	a.lastFetch = time.Now().Add(-2 * minJWKSRefreshInterval)
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss": "https://issuer.example.com", "aud": "gateway", "exp": exp, "tenants": "t1",
+	})
+	token.Header["kid"] = "k2"
+	signed, err := token.SignedString(rotated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+signed)
+	if _, ok := a.AuthenticateRequest(req); !ok {
+		t.Fatal("expected token signed by the rotated key to be authenticated")
+	}
+}
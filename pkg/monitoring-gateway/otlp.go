@@ -0,0 +1,51 @@
+package monitoringgateway
+
+import (
+	"context"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/prompb"
+	otlptranslator "github.com/prometheus/prometheus/storage/remote/otlptranslator/prometheusremotewrite"
+	"go.opentelemetry.io/collector/pdata/pmetric/pmetricotlp"
+)
+
+// OTLPConfig configures how OTLP requests are handled.
+type OTLPConfig struct {
+	// Translate enables the translation of OTLP requests into remote write requests in the gateway, so that they are
+	// handled like remote write requests, subject to the limits of the tenant and forwarded to the external remote
+	// write targets as well. Otherwise they are proxied to the remote write downstream as they are, with the tenant
+	// attributes enforced only. Defaults to true.
+	Translate *bool `yaml:"translate,omitempty"`
+	// PromoteResourceAttributes are the resource attributes added as labels to the translated series.
+	PromoteResourceAttributes []string `yaml:"promote_resource_attributes,omitempty"`
+	// EnableTargetInfo enables the translation of the resource attributes into the target_info metric, defaults to true.
+	EnableTargetInfo *bool `yaml:"enable_target_info,omitempty"`
+}
+
+// translate reports whether OTLP requests are translated in the gateway.
+func (c OTLPConfig) translate() bool {
+	return c.Translate == nil || *c.Translate
+}
+
+// translateOTLP translates an OTLP metrics export request into a remote write request the way Thanos Receive does.
+func translateOTLP(ctx context.Context, logger log.Logger, req pmetricotlp.ExportRequest, c OTLPConfig) (*prompb.WriteRequest, error) {
+	converter := otlptranslator.NewPrometheusConverter()
+	annots, err := converter.FromMetrics(ctx, req.Metrics(), otlptranslator.Settings{
+		AddMetricSuffixes:         true,
+		DisableTargetInfo:         c.EnableTargetInfo != nil && !*c.EnableTargetInfo,
+		PromoteResourceAttributes: c.PromoteResourceAttributes,
+	})
+	if ws, _ := annots.AsStrings("", 0, 0); len(ws) > 0 {
+		level.Warn(logger).Log("msg", "warnings translating OTLP metrics to Prometheus write request", "warnings", ws)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "translating OTLP metrics to Prometheus write request")
+	}
+
+	return &prompb.WriteRequest{
+		Timeseries: converter.TimeSeries(),
+		Metadata:   converter.Metadata(),
+	}, nil
+}
@@ -0,0 +1,123 @@
+package monitoringgateway
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"strings"
+
+	"go.opentelemetry.io/collector/pdata/pmetric/pmetricotlp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// otlpGRPCServer serves the OTLP/gRPC metrics service. The requests are passed to the OTLP/HTTP endpoint of the tenant,
+// so that they take the same way, including the authentication, admission, limits and usage accounting.
+type otlpGRPCServer struct {
+	pmetricotlp.UnimplementedGRPCServer
+
+	handler *Handler
+}
+
+// RegisterOTLPGRPCServer registers the OTLP/gRPC metrics service on the gRPC server.
+// The tenant of a request is taken from the metadata named like the tenant header, or else from the common name
+// of the client certificate.
+func (h *Handler) RegisterOTLPGRPCServer(s *grpc.Server) {
+	pmetricotlp.RegisterGRPCServer(s, &otlpGRPCServer{handler: h})
+}
+
+func (s *otlpGRPCServer) Export(ctx context.Context, ereq pmetricotlp.ExportRequest) (pmetricotlp.ExportResponse, error) {
+	var (
+		up     = s.handler.upstreams.Load()
+		md, _  = metadata.FromIncomingContext(ctx)
+		req    = grpcHTTPRequest(ctx)
+		tenant string
+	)
+	if vs := md.Get(up.tenantHeader); len(vs) > 0 {
+		tenant = vs[0]
+	} else if req.TLS != nil && len(req.TLS.PeerCertificates) > 0 {
+		tenant = req.TLS.PeerCertificates[0].Subject.CommonName
+	}
+	if tenant == "" || strings.Contains(tenant, "/") {
+		return pmetricotlp.NewExportResponse(), status.Errorf(codes.InvalidArgument, "the tenant must be given by the %s metadata or the client certificate", strings.ToLower(up.tenantHeader))
+	}
+
+	body, err := ereq.MarshalProto()
+	if err != nil {
+		return pmetricotlp.NewExportResponse(), status.Error(codes.Internal, err.Error())
+	}
+	req.URL.Path = "/" + tenant + apiGlobalPrefix + epOTLP
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.ContentLength = int64(len(body))
+	req.Header.Set("Content-Type", "application/x-protobuf")
+
+	w := &bufferedResponseWriter{header: make(http.Header), code: http.StatusOK}
+	s.handler.Router().ServeHTTP(w, req)
+	if w.code/100 == 2 {
+		return pmetricotlp.NewExportResponse(), nil
+	}
+	return pmetricotlp.NewExportResponse(), status.Error(grpcCode(w.code), strings.TrimSpace(w.body.String()))
+}
+
+// grpcHTTPRequest returns an HTTP request carrying the client certificate, address and authorization of a gRPC call,
+// so that the call can be authenticated like HTTP requests.
+func grpcHTTPRequest(ctx context.Context) *http.Request {
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, "/", http.NoBody)
+	if p, ok := peer.FromContext(ctx); ok {
+		req.RemoteAddr = p.Addr.String()
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			req.TLS = &info.State
+		}
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	if vs := md.Get("authorization"); len(vs) > 0 {
+		req.Header.Set("Authorization", vs[0])
+	}
+	return req
+}
+
+// grpcCode maps the HTTP status code of a failed request to the gRPC status code, following the OTLP specification
+// on which failures are retryable.
+func grpcCode(code int) codes.Code {
+	switch code {
+	case http.StatusBadRequest, http.StatusUnsupportedMediaType:
+		return codes.InvalidArgument
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusNotAcceptable:
+		return codes.FailedPrecondition
+	case http.StatusTooManyRequests:
+		return codes.ResourceExhausted
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return codes.Unavailable
+	}
+	return codes.Internal
+}
+
+// bufferedResponseWriter keeps the response of a request passed from another protocol.
+type bufferedResponseWriter struct {
+	header http.Header
+	code   int
+	body   bytes.Buffer
+}
+
+func (w *bufferedResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *bufferedResponseWriter) WriteHeader(code int) {
+	w.code = code
+}
+
+func (w *bufferedResponseWriter) Write(b []byte) (int, error) {
+	return w.body.Write(b)
+}
@@ -0,0 +1,68 @@
+package monitoringgateway
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/collector/pdata/pmetric/pmetricotlp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestOTLPGRPCServer(t *testing.T) {
+	var gotPath, gotTenant string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		gotPath, gotTenant = req.URL.Path, req.Header.Get("WHIZARD-TENANT")
+	}))
+	defer upstream.Close()
+	upstreamURL, _ := url.Parse(upstream.URL)
+
+	h := NewHandler(nil, prometheus.NewRegistry(), &Options{
+		TenantHeader:     "WHIZARD-TENANT",
+		TenantLabelName:  "tenant_id",
+		RemoteWriteProxy: NewSingleHostReverseProxy(upstreamURL, http.DefaultTransport),
+	})
+
+	l := bufconn.Listen(1 << 20)
+	s := grpc.NewServer()
+	h.RegisterOTLPGRPCServer(s)
+	go func() { _ = s.Serve(l) }()
+	defer s.Stop()
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return l.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := pmetricotlp.NewGRPCClient(conn)
+
+	ereq := pmetricotlp.NewExportRequest()
+	m := ereq.Metrics().ResourceMetrics().AppendEmpty().ScopeMetrics().AppendEmpty().Metrics().AppendEmpty()
+	m.SetName("up")
+	m.SetEmptyGauge().DataPoints().AppendEmpty().SetDoubleValue(1)
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "whizard-tenant", "t1")
+	if _, err := client.Export(ctx, ereq); err != nil {
+		t.Fatal(err)
+	}
+	// OTLP requests are translated into remote write requests by default.
+	if gotPath != "/api/v1/receive" || gotTenant != "t1" {
+		t.Fatalf("unexpected request to %s of tenant %q", gotPath, gotTenant)
+	}
+
+	_, err = client.Export(context.Background(), ereq)
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected invalid argument without tenant, got %v", err)
+	}
+}
@@ -0,0 +1,155 @@
+package monitoringgateway
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql/parser"
+)
+
+const (
+	// costSampleInterval is the interval of the samples of a series assumed by the cost estimation.
+	costSampleInterval = 15 * time.Second
+	// defaultSubqueryStep is the step of subqueries without step, the default evaluation interval of Prometheus.
+	defaultSubqueryStep = time.Minute
+
+	// The weights of selectors by how many series they may select.
+	metricNameSelectorWeight = 1
+	labelSelectorWeight      = 10
+	regexOnlySelectorWeight  = 100
+)
+
+// selectorCost is the estimated cost of a selector of a query.
+type selectorCost struct {
+	selector string
+	cost     float64
+	// reason explains the weight of the selector.
+	reason string
+}
+
+// estimateQueryCost estimates the cost of a query evaluated at the given number of steps. The cost of a selector is the
+// number of samples it reads per series, weighted by how many series it may select:
+//   - selectors of a metric name weigh 1, or the number of names of a regular expression like a|b,
+//   - selectors of other labels weigh 10,
+//   - selectors of only regular expressions or negative matchers, e.g. {__name__=~".+"}, weigh 100.
+//
+// The samples per series are the steps, multiplied by the range of range selectors and by the steps of subqueries.
+// The cost of the query is the sum of the costs of its selectors, the most expensive selector is returned as well.
+// Matchers of the enforced labels, e.g. the tenant label, do not narrow the selection, so they are not weighted.
+func estimateQueryCost(expr parser.Expr, steps float64, enforcedLabels map[string]struct{}) (float64, *selectorCost) {
+	var (
+		total float64
+		top   *selectorCost
+	)
+	parser.Inspect(expr, func(node parser.Node, path []parser.Node) error {
+		vs, ok := node.(*parser.VectorSelector)
+		if !ok {
+			return nil
+		}
+
+		samples := steps
+		for i, n := range path {
+			switch n := n.(type) {
+			case *parser.MatrixSelector:
+				// The matrix selector is the parent of the vector selector.
+				if i == len(path)-1 {
+					samples *= max(1, float64(n.Range)/float64(costSampleInterval))
+				}
+			case *parser.SubqueryExpr:
+				step := n.Step
+				if step <= 0 {
+					step = defaultSubqueryStep
+				}
+				samples *= max(1, float64(n.Range)/float64(step))
+			}
+		}
+
+		weight, reason := selectorWeight(vs, enforcedLabels)
+		c := &selectorCost{selector: vs.String(), cost: weight * samples, reason: reason}
+		total += c.cost
+		if top == nil || c.cost > top.cost {
+			top = c
+		}
+		return nil
+	})
+	return total, top
+}
+
+// selectorWeight returns the weight of a selector by how many series it may select, and the reason of the weight.
+func selectorWeight(vs *parser.VectorSelector, enforcedLabels map[string]struct{}) (float64, string) {
+	hasLabelMatcher := false
+	for _, m := range vs.LabelMatchers {
+		if _, ok := enforcedLabels[m.Name]; ok {
+			continue
+		}
+		switch {
+		case m.Name == labels.MetricName && m.Type == labels.MatchEqual:
+			return metricNameSelectorWeight, "selects a metric name"
+		case m.Name == labels.MetricName && m.Type == labels.MatchRegexp && len(m.SetMatches()) > 0:
+			return float64(len(m.SetMatches())), fmt.Sprintf("selects %d metric names", len(m.SetMatches()))
+		case m.Type == labels.MatchEqual && m.Value != "":
+			hasLabelMatcher = true
+		}
+	}
+	if hasLabelMatcher {
+		return labelSelectorWeight, "selects series by labels without a metric name"
+	}
+	return regexOnlySelectorWeight, "selects series by regular expressions or negative matchers only"
+}
+
+// queryCostSteps returns the number of steps a query is evaluated at, 1 for instant queries.
+func queryCostSteps(params url.Values, rangeQuery bool) (float64, error) {
+	if !rangeQuery {
+		return 1, nil
+	}
+	start, err := parseTime(params.Get(startParam))
+	if err != nil {
+		return 0, err
+	}
+	end, err := parseTime(params.Get(endParam))
+	if err != nil {
+		return 0, err
+	}
+	step, err := parseDuration(params.Get(stepParam))
+	if err != nil {
+		return 0, err
+	}
+	if step <= 0 || end.Before(start) {
+		return 1, nil
+	}
+	return float64(end.Sub(start)/step) + 1, nil
+}
+
+// checkQueryCost rejects queries whose estimated cost exceeds the query cost budget of the tenant. The labels of the
+// matchers enforced on the query, i.e. the tenant label and the labels of access policies, are not weighted.
+// In dry run mode, queries over the budget are only logged and counted.
+func (h *Handler) checkQueryCost(limits TenantLimits, tenant string, expr parser.Expr, params url.Values, rangeQuery bool, enforced []*labels.Matcher) error {
+	if limits.MaxQueryCost <= 0 || expr == nil {
+		return nil
+	}
+	steps, err := queryCostSteps(params, rangeQuery)
+	if err != nil {
+		return err
+	}
+
+	enforcedLabels := make(map[string]struct{}, len(enforced))
+	for _, m := range enforced {
+		enforcedLabels[m.Name] = struct{}{}
+	}
+	cost, top := estimateQueryCost(expr, steps, enforcedLabels)
+	if cost <= limits.MaxQueryCost {
+		return nil
+	}
+	err = fmt.Errorf("the estimated query cost exceeds the budget (cost: %.0f, budget: %.0f), the most expensive selector %s %s and costs %.0f; select metric names, narrow the time range or increase the step",
+		cost, limits.MaxQueryCost, top.selector, top.reason, top.cost)
+
+	h.expensiveQueriesCounter.WithLabelValues(tenant, fmt.Sprint(limits.QueryCostDryRun)).Inc()
+	if limits.QueryCostDryRun {
+		level.Warn(h.logger).Log("msg", "query would be rejected by its cost", "tenant", tenant, "query", expr.String(), "err", err)
+		return nil
+	}
+	return err
+}
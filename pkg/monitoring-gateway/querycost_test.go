@@ -0,0 +1,75 @@
+package monitoringgateway
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql/parser"
+)
+
+func TestEstimateQueryCost(t *testing.T) {
+	for _, tc := range []struct {
+		query string
+		steps float64
+		cost  float64
+	}{
+		{query: `up`, steps: 1, cost: 1},
+		{query: `rate(up[5m])`, steps: 1, cost: 20},
+		{query: `{job="a"}`, steps: 1, cost: 10},
+		{query: `{__name__=~"a|b"}`, steps: 1, cost: 2},
+		{query: `{__name__=~".+"}`, steps: 721, cost: 72100},
+		{query: `max_over_time(rate(up[5m])[1h:])`, steps: 1, cost: 1200},
+		{query: `max_over_time(rate(up[5m])[1h:10m]) / up`, steps: 2, cost: 242},
+	} {
+		expr, err := parser.ParseExpr(tc.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cost, _ := estimateQueryCost(expr, tc.steps, nil); cost != tc.cost {
+			t.Fatalf("expected cost %v of %s, got %v", tc.cost, tc.query, cost)
+		}
+	}
+}
+
+func mustParseQuery(t *testing.T, params url.Values) parser.Expr {
+	t.Helper()
+	expr, err := parseQuery(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return expr
+}
+
+func TestCheckQueryCost(t *testing.T) {
+	h := NewHandler(nil, prometheus.NewRegistry(), &Options{})
+	params := url.Values{"query": {`{__name__=~".+"}`}, "start": {"0"}, "end": {"2592000"}, "step": {"3600"}}
+
+	if err := h.checkQueryCost(TenantLimits{MaxQueryCost: 100000}, "a", mustParseQuery(t, params), params, true, nil); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := h.checkQueryCost(TenantLimits{MaxQueryCost: 50000}, "a", mustParseQuery(t, params), params, true, nil); err == nil {
+		t.Fatal("expected error for query over the budget")
+	}
+	if err := h.checkQueryCost(TenantLimits{MaxQueryCost: 50000, QueryCostDryRun: true}, "a", mustParseQuery(t, params), params, true, nil); err != nil {
+		t.Fatalf("unexpected error in dry run mode %v", err)
+	}
+	if v := testutil.ToFloat64(h.expensiveQueriesCounter.WithLabelValues("a", "true")); v != 1 {
+		t.Fatalf("expected 1 query counted in dry run mode, got %v", v)
+	}
+
+	// Matchers of the enforced labels do not lower the cost.
+	enforced := []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "tenant_id", "a"), labels.MustNewMatcher(labels.MatchEqual, "namespace", "a")}
+	for _, query := range []string{`{__name__=~".+", tenant_id="a"}`, `{__name__=~".+", namespace="a"}`} {
+		params.Set("query", query)
+		if err := h.checkQueryCost(TenantLimits{MaxQueryCost: 50000}, "a", mustParseQuery(t, params), params, true, enforced); err == nil {
+			t.Fatalf("expected error for %s over the budget", query)
+		}
+	}
+	params.Set("query", `{__name__=~".+", job="a"}`)
+	if err := h.checkQueryCost(TenantLimits{MaxQueryCost: 50000}, "a", mustParseQuery(t, params), params, true, enforced); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
@@ -0,0 +1,231 @@
+package monitoringgateway
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+const (
+	startParam   = "start"
+	endParam     = "end"
+	stepParam    = "step"
+	timeoutParam = "timeout"
+
+	errorBadData     = "bad_data"
+	errorUnavailable = "unavailable"
+)
+
+// tenantConcurrency tracks the number of in-flight queries per tenant.
+type tenantConcurrency struct {
+	mtx      sync.Mutex
+	inflight map[string]int
+}
+
+func newTenantConcurrency() *tenantConcurrency {
+	return &tenantConcurrency{inflight: make(map[string]int)}
+}
+
+// acquire takes a query slot for the tenant if less than max queries are in flight.
+// The returned function must be called to release the slot.
+func (c *tenantConcurrency) acquire(tenant string, max int) (release func(), ok bool) {
+	if max <= 0 {
+		return func() {}, true
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if c.inflight[tenant] >= max {
+		return nil, false
+	}
+	c.inflight[tenant]++
+
+	return func() {
+		c.mtx.Lock()
+		defer c.mtx.Unlock()
+
+		c.inflight[tenant]--
+		if c.inflight[tenant] <= 0 {
+			delete(c.inflight, tenant)
+		}
+	}, true
+}
+
+// queryLimits returns the query limits of the given tenants. For a tenant set, the strictest limits of its tenants apply.
+func (h *Handler) queryLimits(tenants []string) TenantLimits {
+	config := h.limits.Load()
+
+	var limits TenantLimits
+	for i, tenant := range tenants {
+		l := config.ForTenant(tenant)
+		if i == 0 {
+			limits = l
+			continue
+		}
+		limits.MaxQueryRange = minLimit(limits.MaxQueryRange, l.MaxQueryRange)
+		limits.MaxQueryLookback = minLimit(limits.MaxQueryLookback, l.MaxQueryLookback)
+		limits.QueryTimeout = minLimit(limits.QueryTimeout, l.QueryTimeout)
+		limits.MinQueryStep = max(limits.MinQueryStep, l.MinQueryStep)
+		if limits.MaxQueryCost <= 0 || (l.MaxQueryCost > 0 && l.MaxQueryCost < limits.MaxQueryCost) {
+			limits.MaxQueryCost, limits.QueryCostDryRun = l.MaxQueryCost, l.QueryCostDryRun
+		}
+	}
+	return limits
+}
+
+// minLimit returns the smaller of two limits, where zero means no limit.
+func minLimit(a, b model.Duration) model.Duration {
+	if a <= 0 || (b > 0 && b < a) {
+		return b
+	}
+	return a
+}
+
+// acquireQuerySlots takes a query slot for each of the tenants, and responds with an error if a tenant runs too many queries.
+func (h *Handler) acquireQuerySlots(w http.ResponseWriter, tenants []string) (release func(), ok bool) {
+	config := h.limits.Load()
+
+	var releases []func()
+	release = func() {
+		for _, r := range releases {
+			r()
+		}
+	}
+	for _, tenant := range tenants {
+		max := config.ForTenant(tenant).MaxConcurrentQueries
+		r, ok := h.queryConcurrency.acquire(tenant, max)
+		if !ok {
+			release()
+			err := fmt.Errorf("too many concurrent queries for tenant %s (limit: %d)", tenant, max)
+			writeAPIError(w, http.StatusTooManyRequests, errorUnavailable, err)
+			return nil, false
+		}
+		releases = append(releases, r)
+	}
+	return release, true
+}
+
+// checkQueryRangeLimits validates the range and the step of a range query against the tenant limits.
+func checkQueryRangeLimits(limits TenantLimits, params url.Values) error {
+	if limits.MaxQueryRange <= 0 && limits.MinQueryStep <= 0 {
+		return nil
+	}
+
+	start, err := parseTime(params.Get(startParam))
+	if err != nil {
+		return err
+	}
+	end, err := parseTime(params.Get(endParam))
+	if err != nil {
+		return err
+	}
+	if r := end.Sub(start); limits.MaxQueryRange > 0 && r > time.Duration(limits.MaxQueryRange) {
+		return fmt.Errorf("the query time range exceeds the limit (query length: %s, limit: %s)", model.Duration(r), limits.MaxQueryRange)
+	}
+
+	step, err := parseDuration(params.Get(stepParam))
+	if err != nil {
+		return err
+	}
+	if limits.MinQueryStep > 0 && step < time.Duration(limits.MinQueryStep) {
+		return fmt.Errorf("the query resolution step is below the limit (step: %s, limit: %s)", model.Duration(step), limits.MinQueryStep)
+	}
+	return nil
+}
+
+// enforceQueryLookback restricts the start of a metadata query to the tenant's maximum lookback.
+// A missing start is set to the earliest allowed time, an earlier start is rejected.
+func enforceQueryLookback(limits TenantLimits, q url.Values, now time.Time) error {
+	if limits.MaxQueryLookback <= 0 {
+		return nil
+	}
+
+	minStart := now.Add(-time.Duration(limits.MaxQueryLookback))
+	if q.Get(startParam) == "" {
+		q.Set(startParam, formatTime(minStart))
+		return nil
+	}
+
+	start, err := parseTime(q.Get(startParam))
+	if err != nil {
+		return err
+	}
+	if start.Before(minStart) {
+		return fmt.Errorf("the query start exceeds the lookback limit (start: %s, limit: %s)", start.Format(time.RFC3339), limits.MaxQueryLookback)
+	}
+	return nil
+}
+
+// capQueryTimeout sets the timeout parameter to the given timeout if it is missing or larger.
+func capQueryTimeout(q url.Values, timeout model.Duration) {
+	if timeout <= 0 {
+		return
+	}
+	if t := q.Get(timeoutParam); t != "" {
+		if d, err := parseDuration(t); err == nil && d <= time.Duration(timeout) {
+			return
+		}
+	}
+	q.Set(timeoutParam, timeout.String())
+}
+
+// withQueryTimeout bounds the request context by the given timeout.
+func withQueryTimeout(req *http.Request, timeout model.Duration) (*http.Request, context.CancelFunc) {
+	if timeout <= 0 {
+		return req, func() {}
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), time.Duration(timeout))
+	return req.WithContext(ctx), cancel
+}
+
+// writeAPIError responds with an error in the format of the Prometheus HTTP API.
+func writeAPIError(w http.ResponseWriter, code int, errorType string, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(map[string]string{
+		"status":    "error",
+		"errorType": errorType,
+		"error":     err.Error(),
+	})
+}
+
+// parseTime parses a timestamp the way the Prometheus HTTP API does.
+func parseTime(s string) (time.Time, error) {
+	if t, err := strconv.ParseFloat(s, 64); err == nil {
+		s, ns := math.Modf(t)
+		ns = math.Round(ns*1000) / 1000
+		return time.Unix(int64(s), int64(ns*float64(time.Second))).UTC(), nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("cannot parse %q to a valid timestamp", s)
+}
+
+// parseDuration parses a duration the way the Prometheus HTTP API does.
+func parseDuration(s string) (time.Duration, error) {
+	if d, err := strconv.ParseFloat(s, 64); err == nil {
+		ts := d * float64(time.Second)
+		if ts > float64(math.MaxInt64) || ts < float64(math.MinInt64) {
+			return 0, fmt.Errorf("cannot parse %q to a valid duration. It overflows int64", s)
+		}
+		return time.Duration(ts), nil
+	}
+	if d, err := model.ParseDuration(s); err == nil {
+		return time.Duration(d), nil
+	}
+	return 0, fmt.Errorf("cannot parse %q to a valid duration", s)
+}
+
+func formatTime(t time.Time) string {
+	return strconv.FormatFloat(float64(t.Unix())+float64(t.Nanosecond())/1e9, 'f', -1, 64)
+}
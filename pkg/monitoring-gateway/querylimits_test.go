@@ -0,0 +1,54 @@
+package monitoringgateway
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+func TestCheckQueryRangeLimits(t *testing.T) {
+	limits := TenantLimits{
+		MaxQueryRange: model.Duration(24 * time.Hour),
+		MinQueryStep:  model.Duration(15 * time.Second),
+	}
+
+	for _, tc := range []struct {
+		params url.Values
+		err    bool
+	}{
+		{params: url.Values{"start": {"0"}, "end": {"3600"}, "step": {"15s"}}},
+		{params: url.Values{"start": {"0"}, "end": {"172800"}, "step": {"60"}}, err: true},
+		{params: url.Values{"start": {"0"}, "end": {"3600"}, "step": {"1"}}, err: true},
+		{params: url.Values{"end": {"3600"}, "step": {"15"}}, err: true},
+	} {
+		if err := checkQueryRangeLimits(limits, tc.params); (err != nil) != tc.err {
+			t.Fatalf("params %v: unexpected error %v", tc.params, err)
+		}
+	}
+
+	if err := checkQueryRangeLimits(TenantLimits{}, url.Values{}); err != nil {
+		t.Fatalf("unexpected error without limits: %v", err)
+	}
+}
+
+func TestEnforceQueryLookback(t *testing.T) {
+	now := time.Unix(100000, 0)
+	limits := TenantLimits{MaxQueryLookback: model.Duration(time.Hour)}
+
+	q := url.Values{}
+	if err := enforceQueryLookback(limits, q, now); err != nil {
+		t.Fatal(err)
+	}
+	if q.Get("start") != "96400" {
+		t.Fatalf("expected start to be set to 96400, got %q", q.Get("start"))
+	}
+
+	if err := enforceQueryLookback(limits, url.Values{"start": {"99000"}}, now); err != nil {
+		t.Fatal(err)
+	}
+	if err := enforceQueryLookback(limits, url.Values{"start": {"1000"}}, now); err == nil {
+		t.Fatal("expected lookback error")
+	}
+}
@@ -0,0 +1,669 @@
+package monitoringgateway
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash/fnv"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/common/model"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	reasonQueueFull        = "queue_full"
+	reasonRetriesExhausted = "retries_exhausted"
+	reasonNonRecoverable   = "non_recoverable"
+	reasonWALFailure       = "wal_failure"
+	reasonInvalidRequest   = "invalid_request"
+)
+
+// QueueConfig configures the queue that forwards remote write requests to an external target.
+type QueueConfig struct {
+	// Capacity is the number of requests buffered per shard before new requests are dropped.
+	Capacity int `yaml:"capacity,omitempty"`
+	// Shards is the number of concurrent senders. Requests of a tenant are always sent by the same shard.
+	Shards int `yaml:"shards,omitempty"`
+	// MaxRetries is the number of times a request failing with a recoverable error is retried.
+	MaxRetries int `yaml:"max_retries,omitempty"`
+	// MinBackoff is the initial retry delay, which is doubled for every retry.
+	MinBackoff model.Duration `yaml:"min_backoff,omitempty"`
+	// MaxBackoff is the maximum retry delay.
+	MaxBackoff model.Duration `yaml:"max_backoff,omitempty"`
+}
+
+// DefaultQueueConfig is the default queue configuration of external remote write targets.
+var DefaultQueueConfig = QueueConfig{
+	Capacity:   500,
+	Shards:     4,
+	MaxRetries: 10,
+	MinBackoff: model.Duration(30 * time.Millisecond),
+	MaxBackoff: model.Duration(5 * time.Second),
+}
+
+type queueMetrics struct {
+	requests *prometheus.CounterVec
+	length   *prometheus.GaugeVec
+	retries  *prometheus.CounterVec
+	dropped  *prometheus.CounterVec
+}
+
+func newQueueMetrics(reg prometheus.Registerer) *queueMetrics {
+	return &queueMetrics{
+		requests: promauto.With(reg).NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "whizard_external_remote_write_requests_total",
+				Help: "Total number of remote write results, labeled by endpoint and code.",
+			},
+			[]string{"endpoint", "code"},
+		),
+		length: promauto.With(reg).NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "whizard_external_remote_write_queue_length",
+				Help: "Number of remote write requests waiting in the queue, labeled by endpoint.",
+			},
+			[]string{"endpoint"},
+		),
+		retries: promauto.With(reg).NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "whizard_external_remote_write_retries_total",
+				Help: "Total number of retried remote write requests, labeled by endpoint.",
+			},
+			[]string{"endpoint"},
+		),
+		dropped: promauto.With(reg).NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "whizard_external_remote_write_dropped_total",
+				Help: "Total number of dropped remote write requests, labeled by endpoint and reason.",
+			},
+			[]string{"endpoint", "reason"},
+		),
+	}
+}
+
+// queuedRequest is a remote write request waiting to be sent.
+type queuedRequest struct {
+	tenant   string
+	protoMsg RemoteWriteProtoMsg
+	body     []byte
+	// walPath is the path of the request in the write-ahead log, if any.
+	walPath string
+}
+
+// incomingRequest is a remote write request given to a queue, which is yet to be prepared and persisted.
+type incomingRequest struct {
+	tenant string
+	body   []byte
+	v2Body []byte
+}
+
+// remoteWriteQueue forwards remote write requests to an external target asynchronously.
+type remoteWriteQueue struct {
+	logger       log.Logger
+	client       *remoteWriteClient
+	config       QueueConfig
+	tenantHeader string
+	wal          *requestWAL
+	metrics      *queueMetrics
+
+	incoming    chan *incomingRequest
+	shards      []chan *queuedRequest
+	quit        chan struct{}
+	stopOnce    sync.Once
+	releaseOnce sync.Once
+	wg          sync.WaitGroup
+	// closing is closed once the queue is closed, persisted once the incoming requests are persisted and queued, and
+	// replayed once the requests of the write-ahead log are queued. drained is closed once all of them are, and the
+	// shards stop when they are empty.
+	closing   chan struct{}
+	persisted chan struct{}
+	replayed  chan struct{}
+	drained   chan struct{}
+
+	// mtx guards closed and successor. A closed queue takes no new requests, the requests in flight are given to its
+	// successor, the queue that replaced it for the same target, if any.
+	mtx       sync.RWMutex
+	closed    bool
+	successor *remoteWriteQueue
+}
+
+// QueueManager runs the queues of the external remote write targets, and replaces them when the targets change.
+type QueueManager struct {
+	logger  log.Logger
+	metrics *queueMetrics
+	walDir  string
+
+	mtx sync.Mutex
+	// queues are the running queues, keyed by the configuration they were created from.
+	queues map[string]*remoteWriteQueue
+	// draining are the replaced queues that still send their queued requests.
+	draining map[*remoteWriteQueue]struct{}
+	// wals are the write-ahead logs by directory, which a queue replacing another one of the same target takes over.
+	wals map[string]*requestWAL
+}
+
+// NewQueueManager creates a QueueManager. If walDir is not empty, queued requests are persisted there and replayed on start.
+func NewQueueManager(logger log.Logger, reg prometheus.Registerer, walDir string) *QueueManager {
+	if logger == nil {
+		logger = log.NewNopLogger()
+	}
+	return &QueueManager{
+		logger:   logger,
+		metrics:  newQueueMetrics(reg),
+		walDir:   walDir,
+		queues:   make(map[string]*remoteWriteQueue),
+		draining: make(map[*remoteWriteQueue]struct{}),
+		wals:     make(map[string]*requestWAL),
+	}
+}
+
+// Update returns the queues of the given targets. The queues of unchanged targets keep running, so that no queued
+// request is lost, and the queues of new or changed targets are created while the running ones still take requests.
+// The returned function drains the queues of removed or changed targets, and is to be called once the returned
+// queues are in use. If an error is returned, the running queues are left untouched.
+func (m *QueueManager) Update(configs []ExternalRemoteWriteConfig, tenantHeader string) ([]*remoteWriteQueue, func(), error) {
+	if err := validateTargetNames(configs); err != nil {
+		return nil, nil, err
+	}
+	// Create the clients first, so that an invalid configuration leaves the running queues untouched.
+	keys := make([]string, 0, len(configs))
+	clients := make(map[string]*remoteWriteClient, len(configs))
+	for _, cfg := range configs {
+		out, err := yaml.Marshal(cfg)
+		if err != nil {
+			return nil, nil, err
+		}
+		key := tenantHeader + "\n" + string(out)
+		if _, ok := clients[key]; ok {
+			continue
+		}
+		client, err := newExternalRemoteWriteClient(&cfg)
+		if err != nil {
+			return nil, nil, err
+		}
+		keys = append(keys, key)
+		clients[key] = client
+	}
+
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	wals := maps.Clone(m.wals)
+	created := make(map[string]*remoteWriteQueue)
+	queues := make([]*remoteWriteQueue, 0, len(keys))
+	// successors are the queues by the write-ahead log key of their target.
+	successors := make(map[string]*remoteWriteQueue, len(keys))
+	for _, key := range keys {
+		q, ok := m.queues[key]
+		if !ok {
+			var err error
+			if q, err = m.newQueue(clients[key], tenantHeader); err != nil {
+				for _, q := range created {
+					q.Stop()
+				}
+				m.wals = wals
+				return nil, nil, err
+			}
+			created[key] = q
+		}
+		queues = append(queues, q)
+		successors[walKey(q.client.name, q.Endpoint())] = q
+	}
+
+	obsolete := make(map[*remoteWriteQueue]*remoteWriteQueue)
+	for key, q := range m.queues {
+		if _, ok := clients[key]; !ok {
+			obsolete[q] = successors[walKey(q.client.name, q.Endpoint())]
+			delete(m.queues, key)
+			m.draining[q] = struct{}{}
+		}
+	}
+	maps.Copy(m.queues, created)
+	m.removeStaleWALs()
+
+	return queues, func() {
+		for q, successor := range obsolete {
+			go m.drain(q, successor)
+		}
+	}, nil
+}
+
+// newQueue creates the queue of the target. A queue replacing another one of the same target takes over its
+// write-ahead log, whose requests are sent by the replaced queue.
+func (m *QueueManager) newQueue(client *remoteWriteClient, tenantHeader string) (*remoteWriteQueue, error) {
+	var (
+		wal    *requestWAL
+		replay []*queuedRequest
+	)
+	if m.walDir != "" {
+		dir := filepath.Join(m.walDir, walKey(client.name, client.Endpoint()))
+		if wal = m.wals[dir]; wal == nil {
+			var err error
+			if wal, replay, err = openRequestWAL(dir); err != nil {
+				return nil, err
+			}
+			m.wals[dir] = wal
+		}
+	}
+	return newRemoteWriteQueue(log.With(m.logger, "endpoint", client.Endpoint()), client, tenantHeader, wal, replay, m.metrics), nil
+}
+
+// drain drains the replaced queue, giving the requests in flight to its successor.
+func (m *QueueManager) drain(q, successor *remoteWriteQueue) {
+	drained := q.Drain(successor)
+
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	delete(m.draining, q)
+	m.release(q, drained)
+}
+
+// release removes the queue length of the endpoint of the stopped queue and, if the queue was drained, its
+// write-ahead log, unless another queue uses them. It must be called with the mutex held.
+func (m *QueueManager) release(q *remoteWriteQueue, drained bool) {
+	var endpointUsed, walUsed bool
+	use := func(other *remoteWriteQueue) {
+		endpointUsed = endpointUsed || other.Endpoint() == q.Endpoint()
+		walUsed = walUsed || (q.wal != nil && other.wal == q.wal)
+	}
+	for _, other := range m.queues {
+		use(other)
+	}
+	for other := range m.draining {
+		use(other)
+	}
+
+	if !endpointUsed {
+		m.metrics.length.DeleteLabelValues(q.Endpoint())
+	}
+	if q.wal == nil || walUsed || !drained {
+		return
+	}
+	delete(m.wals, q.wal.dir)
+	if err := os.RemoveAll(q.wal.dir); err != nil {
+		level.Warn(m.logger).Log("msg", "failed to remove the write-ahead log of a removed target", "dir", q.wal.dir, "err", err)
+	}
+}
+
+// removeStaleWALs removes the write-ahead logs of the targets that were removed while the gateway was not running.
+// It must be called with the mutex held.
+func (m *QueueManager) removeStaleWALs() {
+	if m.walDir == "" {
+		return
+	}
+	entries, err := os.ReadDir(m.walDir)
+	if err != nil {
+		level.Warn(m.logger).Log("msg", "failed to read the write-ahead log directory", "dir", m.walDir, "err", err)
+		return
+	}
+	for _, e := range entries {
+		dir := filepath.Join(m.walDir, e.Name())
+		if _, ok := m.wals[dir]; ok || !e.IsDir() || !isWALKey(e.Name()) {
+			continue
+		}
+		level.Info(m.logger).Log("msg", "removing the write-ahead log of a removed target", "dir", dir)
+		if err := os.RemoveAll(dir); err != nil {
+			level.Warn(m.logger).Log("msg", "failed to remove the write-ahead log of a removed target", "dir", dir, "err", err)
+		}
+	}
+}
+
+// Stop stops all queues, including the queues being drained.
+func (m *QueueManager) Stop() {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	for key, q := range m.queues {
+		q.Stop()
+		delete(m.queues, key)
+	}
+	for q := range m.draining {
+		q.Stop()
+	}
+	m.metrics.length.Reset()
+}
+
+// validateTargetNames checks that targets with the same URL have distinct names, as the write-ahead log of a target
+// is identified by its name and URL.
+func validateTargetNames(configs []ExternalRemoteWriteConfig) error {
+	seen := make(map[string]struct{}, len(configs))
+	for _, cfg := range configs {
+		if cfg.URL == nil {
+			continue
+		}
+		key := walKey(cfg.Name, cfg.URL.String())
+		if _, ok := seen[key]; ok {
+			return errors.Errorf("external remote write targets with the same url %s must have distinct names", cfg.URL)
+		}
+		seen[key] = struct{}{}
+	}
+	return nil
+}
+
+// walKey returns the name of the write-ahead log directory of a target.
+func walKey(name, endpoint string) string {
+	sum := sha256.Sum256([]byte(name + "\n" + endpoint))
+	return hex.EncodeToString(sum[:8])
+}
+
+// isWALKey reports whether the name is the name of the write-ahead log directory of a target.
+func isWALKey(name string) bool {
+	b, err := hex.DecodeString(name)
+	return err == nil && len(b) == 8
+}
+
+func newRemoteWriteQueue(logger log.Logger, client *remoteWriteClient, tenantHeader string, wal *requestWAL, replay []*queuedRequest, metrics *queueMetrics) *remoteWriteQueue {
+	q := &remoteWriteQueue{
+		logger:       logger,
+		client:       client,
+		config:       client.queueConfig,
+		tenantHeader: tenantHeader,
+		wal:          wal,
+		metrics:      metrics,
+		incoming:     make(chan *incomingRequest, client.queueConfig.Capacity),
+		quit:         make(chan struct{}),
+		closing:      make(chan struct{}),
+		persisted:    make(chan struct{}),
+		replayed:     make(chan struct{}),
+		drained:      make(chan struct{}),
+	}
+
+	q.wg.Add(1)
+	go q.runWriter()
+	q.shards = make([]chan *queuedRequest, q.config.Shards)
+	for i := range q.shards {
+		q.shards[i] = make(chan *queuedRequest, q.config.Capacity)
+	}
+	for _, ch := range q.shards {
+		q.wg.Add(1)
+		go q.runShard(ch)
+	}
+	if len(replay) > 0 {
+		q.wg.Add(1)
+		go q.replay(replay)
+	} else {
+		close(q.replayed)
+	}
+	return q
+}
+
+// replay queues the requests of the write-ahead log, waiting for room in the shards rather than dropping requests
+// beyond their capacity. Requests left when the queue is stopped stay in the write-ahead log.
+func (q *remoteWriteQueue) replay(requests []*queuedRequest) {
+	defer q.wg.Done()
+	defer close(q.replayed)
+
+	for i, r := range requests {
+		select {
+		case q.shard(r) <- r:
+			q.metrics.length.WithLabelValues(q.Endpoint()).Inc()
+		case <-q.quit:
+			level.Info(q.logger).Log("msg", "stopped replaying queued remote write requests", "replayed", i, "left", len(requests)-i)
+			return
+		}
+	}
+	level.Info(q.logger).Log("msg", "replayed queued remote write requests", "count", len(requests))
+}
+
+// Endpoint returns the endpoint of the external target.
+func (q *remoteWriteQueue) Endpoint() string {
+	return q.client.Endpoint()
+}
+
+// Enqueue adds a remote write request of the tenant to the queue without blocking. The request is given as a 1.0 body
+// and, if it was received as 2.0, the original 2.0 body. The series are selected and relabeled for the target, and the
+// request is persisted, by the queue, so that the request goroutine is not held up by them. It reports false if the
+// request was dropped. The requests given to a closed queue are given to its successor, and dropped if its target was
+// removed.
+func (q *remoteWriteQueue) Enqueue(tenant string, body, v2Body []byte) bool {
+	q.mtx.RLock()
+	if q.closed {
+		successor := q.successor
+		q.mtx.RUnlock()
+		if successor == nil {
+			return false
+		}
+		return successor.Enqueue(tenant, body, v2Body)
+	}
+	defer q.mtx.RUnlock()
+
+	if !q.client.tenants.Selects(tenant) {
+		return true
+	}
+	select {
+	case q.incoming <- &incomingRequest{tenant: tenant, body: body, v2Body: v2Body}:
+		return true
+	default:
+		q.metrics.dropped.WithLabelValues(q.Endpoint(), reasonQueueFull).Inc()
+		return false
+	}
+}
+
+// runWriter prepares the incoming requests and persists them in the write-ahead log before queueing them. The requests
+// given while a batch is persisted make the next batch, which is persisted with one sync of the directory.
+func (q *remoteWriteQueue) runWriter() {
+	defer q.wg.Done()
+	defer close(q.persisted)
+
+	for {
+		select {
+		case <-q.quit:
+			// The requests given before the queue was stopped are persisted, to be sent after a restart.
+			q.persist(q.pending(nil), false)
+			return
+		case r := <-q.incoming:
+			q.persist(q.pending([]*incomingRequest{r}), true)
+		case <-q.closing:
+			q.persist(q.pending(nil), true)
+			return
+		}
+	}
+}
+
+// pending appends the incoming requests waiting to be persisted.
+func (q *remoteWriteQueue) pending(incoming []*incomingRequest) []*incomingRequest {
+	for {
+		select {
+		case r := <-q.incoming:
+			incoming = append(incoming, r)
+		default:
+			return incoming
+		}
+	}
+}
+
+// persist prepares the incoming requests for the target and persists them in the write-ahead log, and queues them if
+// push is true.
+func (q *remoteWriteQueue) persist(incoming []*incomingRequest, push bool) {
+	if len(incoming) == 0 || (!push && q.wal == nil) {
+		return
+	}
+
+	requests := make([]*queuedRequest, 0, len(incoming))
+	for _, in := range incoming {
+		body, protoMsg, ok, err := q.client.prepare(in.tenant, in.body, in.v2Body)
+		if err != nil {
+			level.Error(q.logger).Log("msg", "failed to prepare remote write request", "tenant", in.tenant, "err", err)
+			q.metrics.dropped.WithLabelValues(q.Endpoint(), reasonInvalidRequest).Inc()
+			continue
+		}
+		if ok {
+			requests = append(requests, &queuedRequest{tenant: in.tenant, protoMsg: protoMsg, body: body})
+		}
+	}
+	if q.wal != nil && len(requests) > 0 {
+		if err := q.wal.writeBatch(requests); err != nil {
+			level.Error(q.logger).Log("msg", "failed to persist remote write requests", "count", len(requests), "err", err)
+			q.metrics.dropped.WithLabelValues(q.Endpoint(), reasonWALFailure).Add(float64(len(requests)))
+			return
+		}
+	}
+	if push {
+		for _, r := range requests {
+			q.push(r)
+		}
+	}
+}
+
+// shard returns the shard of the request, by its tenant.
+func (q *remoteWriteQueue) shard(r *queuedRequest) chan *queuedRequest {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(r.tenant))
+	return q.shards[h.Sum32()%uint32(len(q.shards))]
+}
+
+func (q *remoteWriteQueue) push(r *queuedRequest) bool {
+	select {
+	case q.shard(r) <- r:
+		q.metrics.length.WithLabelValues(q.Endpoint()).Inc()
+		return true
+	default:
+		q.drop(r, reasonQueueFull)
+		return false
+	}
+}
+
+// Stop stops sending. Requests left in the queue are lost, unless they are persisted in the write-ahead log.
+func (q *remoteWriteQueue) Stop() {
+	q.stopOnce.Do(func() { close(q.quit) })
+	q.wg.Wait()
+	q.release()
+}
+
+// release removes the requests left in the shards of the stopped queue from the queue length.
+func (q *remoteWriteQueue) release() {
+	q.releaseOnce.Do(func() {
+		var n int
+		for _, ch := range q.shards {
+			n += len(ch)
+		}
+		q.metrics.length.WithLabelValues(q.Endpoint()).Sub(float64(n))
+	})
+}
+
+// Drain closes the queue, giving new requests to its successor, and stops it once the requests given before,
+// including those of the write-ahead log, are sent. It reports whether all requests were sent or dropped, and false if
+// the queue was stopped first. It is called once per queue.
+func (q *remoteWriteQueue) Drain(successor *remoteWriteQueue) bool {
+	// The requests being given are given before the queue is closed.
+	q.mtx.Lock()
+	q.closed = true
+	q.successor = successor
+	q.mtx.Unlock()
+
+	close(q.closing)
+	for _, done := range []chan struct{}{q.persisted, q.replayed} {
+		select {
+		case <-done:
+		case <-q.quit:
+		}
+	}
+	close(q.drained)
+	q.wg.Wait()
+	q.release()
+
+	select {
+	case <-q.quit:
+		return false
+	default:
+		return true
+	}
+}
+
+func (q *remoteWriteQueue) runShard(ch chan *queuedRequest) {
+	defer q.wg.Done()
+
+	for {
+		select {
+		case <-q.quit:
+			return
+		case r := <-ch:
+			q.metrics.length.WithLabelValues(q.Endpoint()).Dec()
+			q.send(r)
+		case <-q.drained:
+			// No request is queued anymore, send the queued ones and stop.
+			for {
+				select {
+				case <-q.quit:
+					return
+				case r := <-ch:
+					q.metrics.length.WithLabelValues(q.Endpoint()).Dec()
+					q.send(r)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// send sends the request, retrying recoverable errors with exponential backoff.
+func (q *remoteWriteQueue) send(r *queuedRequest) {
+	header := make(http.Header)
+	if r.tenant != "" {
+		header.Set(q.tenantHeader, r.tenant)
+	}
+
+	backoff := time.Duration(q.config.MinBackoff)
+	for attempt := 0; ; attempt++ {
+		result := q.client.Send(context.Background(), r.body, r.protoMsg, header)
+		q.metrics.requests.WithLabelValues(q.Endpoint(), strconv.Itoa(result.code)).Inc()
+		if result.err == nil {
+			q.done(r)
+			return
+		}
+
+		if !recoverable(result.code) {
+			level.Error(q.logger).Log("msg", "failed to forward request, dropping it", "tenant", r.tenant, "err", result.err)
+			q.drop(r, reasonNonRecoverable)
+			return
+		}
+		if attempt >= q.config.MaxRetries {
+			level.Error(q.logger).Log("msg", "failed to forward request, retries exhausted", "tenant", r.tenant, "err", result.err)
+			q.drop(r, reasonRetriesExhausted)
+			return
+		}
+
+		level.Debug(q.logger).Log("msg", "failed to forward request, retrying", "tenant", r.tenant, "backoff", backoff, "err", result.err)
+		q.metrics.retries.WithLabelValues(q.Endpoint()).Inc()
+		select {
+		case <-q.quit:
+			// Keep the request in the write-ahead log to be replayed on the next start.
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, time.Duration(q.config.MaxBackoff))
+	}
+}
+
+func (q *remoteWriteQueue) drop(r *queuedRequest, reason string) {
+	q.metrics.dropped.WithLabelValues(q.Endpoint(), reason).Inc()
+	q.done(r)
+}
+
+// done removes a request that is sent or dropped from the write-ahead log.
+func (q *remoteWriteQueue) done(r *queuedRequest) {
+	if q.wal == nil || r.walPath == "" {
+		return
+	}
+	if err := q.wal.remove(r); err != nil {
+		level.Warn(q.logger).Log("msg", "failed to remove remote write request from the write-ahead log", "err", err)
+	}
+}
+
+// recoverable reports whether a request that failed with the given status code may succeed when retried.
+func recoverable(code int) bool {
+	return code/100 == 5 || code == http.StatusTooManyRequests
+}
@@ -0,0 +1,288 @@
+package monitoringgateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	config_util "github.com/prometheus/common/config"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+func newTestQueueConfig(t *testing.T, rawURL string) ExternalRemoteWriteConfig {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ExternalRemoteWriteConfig{
+		URL: &config_util.URL{URL: u},
+		QueueConfig: &QueueConfig{
+			Shards:     1,
+			MinBackoff: model.Duration(time.Millisecond),
+			MaxBackoff: model.Duration(time.Millisecond),
+		},
+	}
+}
+
+func TestRemoteWriteQueueRetries(t *testing.T) {
+	var calls, delivered atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if req.Header.Get("WHIZARD-TENANT") != "t1" {
+			t.Errorf("unexpected tenant header %q", req.Header.Get("WHIZARD-TENANT"))
+		}
+		delivered.Add(1)
+	}))
+	defer upstream.Close()
+
+	m := NewQueueManager(nil, prometheus.NewRegistry(), "")
+	defer m.Stop()
+	queues, _, err := m.Update([]ExternalRemoteWriteConfig{newTestQueueConfig(t, upstream.URL)}, "WHIZARD-TENANT")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !queues[0].Enqueue("t1", []byte("body"), nil) {
+		t.Fatal("expected request to be queued")
+	}
+	waitFor(t, func() bool { return delivered.Load() == 1 })
+	if calls.Load() != 2 {
+		t.Fatalf("expected 2 calls, got %d", calls.Load())
+	}
+}
+
+func TestRemoteWriteQueueWALReplay(t *testing.T) {
+	dir := t.TempDir()
+
+	var available atomic.Bool
+	var delivered atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !available.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		delivered.Add(1)
+	}))
+	defer upstream.Close()
+
+	cfg := newTestQueueConfig(t, upstream.URL)
+	cfg.QueueConfig.MaxRetries = 1000
+	cfg.QueueConfig.Capacity = 1
+
+	m := NewQueueManager(nil, prometheus.NewRegistry(), dir)
+	queues, _, err := m.Update([]ExternalRemoteWriteConfig{cfg}, "WHIZARD-TENANT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	queues[0].Enqueue("t1", []byte("body"), nil)
+	m.Stop()
+	// More requests than the queue holds are left in the write-ahead log.
+	for i := 0; i < 3; i++ {
+		if err := queues[0].wal.write(&queuedRequest{tenant: "t1", protoMsg: RemoteWriteProtoMsgV1, body: []byte("body")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The requests are replayed after a restart, including those beyond the capacity of the queue.
+	available.Store(true)
+	m = NewQueueManager(nil, prometheus.NewRegistry(), dir)
+	defer m.Stop()
+	if _, _, err := m.Update([]ExternalRemoteWriteConfig{cfg}, "WHIZARD-TENANT"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return delivered.Load() == 4 })
+	waitFor(t, func() bool {
+		entries, err := os.ReadDir(queues[0].wal.dir)
+		return err == nil && len(entries) == 0
+	})
+}
+
+func TestQueueManagerReplacedQueue(t *testing.T) {
+	var available atomic.Bool
+	var delivered atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !available.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		delivered.Add(1)
+	}))
+	defer upstream.Close()
+
+	m := NewQueueManager(nil, prometheus.NewRegistry(), t.TempDir())
+	defer m.Stop()
+	cfg := newTestQueueConfig(t, upstream.URL)
+	cfg.QueueConfig.MaxRetries = 1000
+	queues, _, err := m.Update([]ExternalRemoteWriteConfig{cfg}, "WHIZARD-TENANT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	replaced := queues[0]
+	if !replaced.Enqueue("t1", []byte("body"), nil) {
+		t.Fatal("expected request to be queued")
+	}
+
+	// The queue of the changed target takes over the write-ahead log of the replaced queue, which keeps sending its
+	// queued request once it is drained, and gives the requests in flight to the new queue.
+	cfg.QueueConfig.Capacity = 10
+	queues, drainObsolete, err := m.Update([]ExternalRemoteWriteConfig{cfg}, "WHIZARD-TENANT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if queues[0] == replaced || queues[0].wal != replaced.wal {
+		t.Fatal("expected a new queue sharing the write-ahead log of the replaced queue")
+	}
+	drainObsolete()
+	waitFor(t, func() bool {
+		replaced.mtx.RLock()
+		defer replaced.mtx.RUnlock()
+		return replaced.closed
+	})
+	if !replaced.Enqueue("t1", []byte("body"), nil) {
+		t.Fatal("expected request in flight to be queued by the new queue")
+	}
+
+	available.Store(true)
+	waitFor(t, func() bool { return delivered.Load() == 2 })
+	waitFor(t, func() bool {
+		m.mtx.Lock()
+		defer m.mtx.Unlock()
+		return len(m.draining) == 0
+	})
+	entries, err := os.ReadDir(replaced.wal.dir)
+	if err != nil || len(entries) != 0 {
+		t.Fatalf("expected the write-ahead log emptied, got %d entries and err %v", len(entries), err)
+	}
+}
+
+func TestQueueManagerRemovedTarget(t *testing.T) {
+	var delivered atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		delivered.Add(1)
+	}))
+	defer upstream.Close()
+
+	// The write-ahead log of a target removed while the gateway was not running is removed.
+	dir := t.TempDir()
+	stale := filepath.Join(dir, walKey("removed", "http://partner.example.com/api/v1/write"))
+	if err := os.MkdirAll(stale, 0o750); err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewQueueManager(nil, prometheus.NewRegistry(), dir)
+	defer m.Stop()
+	queues, _, err := m.Update([]ExternalRemoteWriteConfig{newTestQueueConfig(t, upstream.URL)}, "WHIZARD-TENANT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Fatalf("expected the stale write-ahead log removed, got %v", err)
+	}
+	queues[0].Enqueue("t1", []byte("body"), nil)
+	waitFor(t, func() bool { return delivered.Load() == 1 })
+
+	// The write-ahead log and the queue length of a removed target are removed once its queue is drained.
+	_, drainObsolete, err := m.Update(nil, "WHIZARD-TENANT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	drainObsolete()
+	waitFor(t, func() bool {
+		_, err := os.Stat(queues[0].wal.dir)
+		return os.IsNotExist(err) && testutil.CollectAndCount(m.metrics.length) == 0
+	})
+}
+
+func TestQueueManagerTargetNames(t *testing.T) {
+	m := NewQueueManager(nil, prometheus.NewRegistry(), t.TempDir())
+	defer m.Stop()
+
+	a, b := newTestQueueConfig(t, "http://partner.example.com/api/v1/write"), newTestQueueConfig(t, "http://partner.example.com/api/v1/write")
+	b.Tenants = &TenantSelector{Include: []string{"t1"}}
+	if _, _, err := m.Update([]ExternalRemoteWriteConfig{a, b}, "WHIZARD-TENANT"); err == nil {
+		t.Fatal("expected error for targets with the same url and name")
+	}
+
+	b.Name = "t1"
+	queues, _, err := m.Update([]ExternalRemoteWriteConfig{a, b}, "WHIZARD-TENANT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if queues[0].wal.dir == queues[1].wal.dir {
+		t.Fatalf("expected distinct write-ahead logs, got %s", queues[0].wal.dir)
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	for i := 0; i < 500; i++ {
+		if cond() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("condition not met in time")
+}
+
+func TestRemoteWriteClientPrepare(t *testing.T) {
+	rwsCfg, err := LoadExternalRemoteWriteConfig("", `
+- url: http://partner.example.com/api/v1/write
+  tenants:
+    include: [t1, t2]
+    exclude: [t2]
+  write_relabel_configs:
+  - source_labels: [__name__]
+    regex: up
+    action: keep
+  - regex: pod
+    action: labeldrop
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := newExternalRemoteWriteClient(&rwsCfg[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, err := encodeWriteRequest(&prompb.WriteRequest{Timeseries: []prompb.TimeSeries{
+		{Labels: []prompb.Label{{Name: "__name__", Value: "up"}, {Name: "pod", Value: "a"}}, Samples: []prompb.Sample{{Value: 1}}},
+		{Labels: []prompb.Label{{Name: "__name__", Value: "down"}}, Samples: []prompb.Sample{{Value: 1}}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tenant := range []string{"t2", "t3", ""} {
+		if _, _, ok, err := client.prepare(tenant, body, nil); err != nil || ok {
+			t.Fatalf("expected tenant %q not to be forwarded, got ok=%v err=%v", tenant, ok, err)
+		}
+	}
+
+	prepared, _, ok, err := client.prepare("t1", body, nil)
+	if err != nil || !ok {
+		t.Fatalf("expected tenant t1 to be forwarded, got ok=%v err=%v", ok, err)
+	}
+	wreq, err := decodeWriteRequest(prepared)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []prompb.TimeSeries{
+		{Labels: []prompb.Label{{Name: "__name__", Value: "up"}}, Samples: []prompb.Sample{{Value: 1}}},
+	}
+	if diff := cmp.Diff(want, wreq.Timeseries); diff != "" {
+		t.Fatal(diff)
+	}
+}
@@ -0,0 +1,189 @@
+package monitoringgateway
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+)
+
+const walFileSuffix = ".req"
+
+// requestWAL persists queued remote write requests, one file per request, so that they survive restarts.
+type requestWAL struct {
+	dir string
+	seq atomic.Uint64
+}
+
+// openRequestWAL opens the write-ahead log in dir and returns the requests it holds, oldest first.
+func openRequestWAL(dir string) (*requestWAL, []*queuedRequest, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, nil, errors.Wrap(err, "creating write-ahead log directory")
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "reading write-ahead log directory")
+	}
+
+	type walFile struct {
+		seq  uint64
+		path string
+	}
+	var files []walFile
+	for _, e := range entries {
+		path := filepath.Join(dir, e.Name())
+		name, ok := strings.CutSuffix(e.Name(), walFileSuffix)
+		if !ok {
+			// Remove leftovers of interrupted writes.
+			_ = os.Remove(path)
+			continue
+		}
+		seq, err := strconv.ParseUint(name, 10, 64)
+		if err != nil {
+			continue
+		}
+		files = append(files, walFile{seq: seq, path: path})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].seq < files[j].seq })
+
+	w := &requestWAL{dir: dir}
+	var requests []*queuedRequest
+	for _, f := range files {
+		w.seq.Store(f.seq + 1)
+
+		r, err := readWALFile(f.path)
+		if err != nil {
+			// A corrupted request cannot be sent anyway.
+			_ = os.Remove(f.path)
+			continue
+		}
+		requests = append(requests, r)
+	}
+	return w, requests, nil
+}
+
+// write persists the request and records its path in the request.
+func (w *requestWAL) write(r *queuedRequest) error {
+	return w.writeBatch([]*queuedRequest{r})
+}
+
+// writeBatch persists the requests and records their paths in the requests. The directory is synced once for all of
+// them. If an error is returned, none of the requests is persisted.
+func (w *requestWAL) writeBatch(requests []*queuedRequest) error {
+	for i, r := range requests {
+		if err := w.writeFile(r); err != nil {
+			w.removeAll(requests[:i])
+			return err
+		}
+	}
+	// Sync the directory, so that the requests are persisted once they are queued.
+	if err := syncDir(w.dir); err != nil {
+		w.removeAll(requests)
+		return err
+	}
+	return nil
+}
+
+// writeFile writes the request to its file, which is persisted once the directory is synced.
+func (w *requestWAL) writeFile(r *queuedRequest) error {
+	seq := w.seq.Add(1) - 1
+	path := filepath.Join(w.dir, fmt.Sprintf("%020d%s", seq, walFileSuffix))
+
+	buf := binary.AppendUvarint(nil, uint64(len(r.tenant)))
+	buf = append(buf, r.tenant...)
+	buf = binary.AppendUvarint(buf, uint64(len(r.protoMsg)))
+	buf = append(buf, r.protoMsg...)
+	buf = append(buf, r.body...)
+
+	// Write to a temporary file first, so that a crash never leaves a partial request behind.
+	tmp := path + ".tmp"
+	if err := writeFileSync(tmp, buf); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	r.walPath = path
+	return nil
+}
+
+// removeAll removes the written requests, ignoring errors.
+func (w *requestWAL) removeAll(requests []*queuedRequest) {
+	for _, r := range requests {
+		if r.walPath != "" {
+			_ = w.remove(r)
+			r.walPath = ""
+		}
+	}
+}
+
+// writeFileSync writes the file and syncs it to disk.
+func writeFileSync(path string, buf []byte) error {
+	f, err := os.OpenFile(filepath.Clean(path), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o640)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(buf); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// syncDir syncs the directory, so that the files renamed into it are persisted.
+func syncDir(dir string) error {
+	d, err := os.Open(filepath.Clean(dir))
+	if err != nil {
+		return err
+	}
+	if err := d.Sync(); err != nil {
+		_ = d.Close()
+		return err
+	}
+	return d.Close()
+}
+
+func (w *requestWAL) remove(r *queuedRequest) error {
+	return os.Remove(r.walPath)
+}
+
+func readWALFile(path string) (*queuedRequest, error) {
+	buf, err := os.ReadFile(filepath.Clean(path))
+	if err != nil {
+		return nil, err
+	}
+	tenant, buf, err := readWALString(buf)
+	if err != nil {
+		return nil, err
+	}
+	protoMsg, buf, err := readWALString(buf)
+	if err != nil {
+		return nil, err
+	}
+	return &queuedRequest{
+		tenant:   tenant,
+		protoMsg: RemoteWriteProtoMsg(protoMsg),
+		body:     buf,
+		walPath:  path,
+	}, nil
+}
+
+// readWALString reads a length-prefixed string and returns the remaining buffer.
+func readWALString(buf []byte) (string, []byte, error) {
+	n, k := binary.Uvarint(buf)
+	if k <= 0 || uint64(len(buf)-k) < n {
+		return "", nil, errors.New("corrupted write-ahead log file")
+	}
+	return string(buf[k : k+int(n)]), buf[k+int(n):], nil
+}
@@ -0,0 +1,147 @@
+package monitoringgateway
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	reasonRateLimited         = "rate_limited"
+	reasonBytesRateLimited    = "bytes_rate_limited"
+	reasonTooManySeriesPerReq = "too_many_series_per_request"
+)
+
+// tenantRateLimiter keeps a token bucket per tenant.
+type tenantRateLimiter struct {
+	mtx      sync.Mutex
+	limiters map[string]*rate.Limiter
+}
+
+func newTenantRateLimiter() *tenantRateLimiter {
+	return &tenantRateLimiter{limiters: make(map[string]*rate.Limiter)}
+}
+
+// reserveN reserves n tokens from the bucket of the tenant, which is created or updated with the given limit and burst.
+// A nil reservation is returned if the limit is disabled.
+func (l *tenantRateLimiter) reserveN(tenant string, limit float64, burst int, now time.Time, n int) *rate.Reservation {
+	if limit <= 0 {
+		l.mtx.Lock()
+		delete(l.limiters, tenant)
+		l.mtx.Unlock()
+		return nil
+	}
+	if burst <= 0 {
+		burst = int(math.Ceil(limit))
+	}
+
+	l.mtx.Lock()
+	lim, ok := l.limiters[tenant]
+	if !ok {
+		lim = rate.NewLimiter(rate.Limit(limit), burst)
+		l.limiters[tenant] = lim
+	}
+	l.mtx.Unlock()
+
+	if lim.Limit() != rate.Limit(limit) {
+		lim.SetLimitAt(now, rate.Limit(limit))
+	}
+	if lim.Burst() != burst {
+		lim.SetBurstAt(now, burst)
+	}
+	return lim.ReserveN(now, n)
+}
+
+// rateLimitError is returned when a request exceeds one of the tenant's ingestion limits.
+type rateLimitError struct {
+	reason     string
+	msg        string
+	retryAfter time.Duration
+	// permanent is set if the request exceeds a limit it can never fit in, so that retrying it cannot succeed.
+	permanent bool
+}
+
+func (e *rateLimitError) Error() string {
+	return e.msg
+}
+
+// checkIngestionLimits enforces the ingestion limits of the tenant against a request
+// with the given number of bytes, series and samples.
+func (h *Handler) checkIngestionLimits(tenant string, limits TenantLimits, bytes, series, samples int) *rateLimitError {
+	if limits.MaxSeriesPerRequest > 0 && series > limits.MaxSeriesPerRequest {
+		return &rateLimitError{
+			reason:    reasonTooManySeriesPerReq,
+			msg:       fmt.Sprintf("request contains %d series, exceeding the limit of %d series per request for tenant %s", series, limits.MaxSeriesPerRequest, tenant),
+			permanent: true,
+		}
+	}
+
+	now := time.Now()
+	bytesRes := h.ingestionBytesLimiter.reserveN(tenant, limits.IngestionBytesRate, limits.IngestionBytesBurstSize, now, bytes)
+	samplesRes := h.ingestionRateLimiter.reserveN(tenant, limits.IngestionRate, limits.IngestionBurstSize, now, samples)
+
+	var err *rateLimitError
+	if delay, ok := reservationDelay(bytesRes, now); !ok {
+		err = &rateLimitError{
+			reason:     reasonBytesRateLimited,
+			msg:        fmt.Sprintf("ingestion bytes rate limit (%g bytes/s) exceeded for tenant %s while adding %d bytes", limits.IngestionBytesRate, tenant, bytes),
+			retryAfter: delay,
+			permanent:  !bytesRes.OK(),
+		}
+	}
+	// A request that can never fit is reported rather than one that has to wait.
+	if delay, ok := reservationDelay(samplesRes, now); !ok && (err == nil || (!err.permanent && (!samplesRes.OK() || delay > err.retryAfter))) {
+		err = &rateLimitError{
+			reason:     reasonRateLimited,
+			msg:        fmt.Sprintf("ingestion rate limit (%g samples/s) exceeded for tenant %s while adding %d samples", limits.IngestionRate, tenant, samples),
+			retryAfter: delay,
+			permanent:  !samplesRes.OK(),
+		}
+	}
+
+	if err != nil {
+		// Return the tokens so that a rejected request does not count against the tenant.
+		if bytesRes != nil {
+			bytesRes.CancelAt(now)
+		}
+		if samplesRes != nil {
+			samplesRes.CancelAt(now)
+		}
+	}
+	return err
+}
+
+// reservationDelay reports whether the reservation may be acted on immediately, and if not, how long to wait.
+// A reservation exceeding the burst size can never be acted on.
+func reservationDelay(r *rate.Reservation, now time.Time) (time.Duration, bool) {
+	if r == nil {
+		return 0, true
+	}
+	if !r.OK() {
+		return 0, false
+	}
+	if delay := r.DelayFrom(now); delay > 0 {
+		return delay, false
+	}
+	return 0, true
+}
+
+// writeRateLimitError responds with 429 Too Many Requests and a Retry-After header, or with 400 Bad Request if the
+// request can never fit in the limits, so that clients do not retry it.
+func writeRateLimitError(w http.ResponseWriter, err *rateLimitError) {
+	if err.permanent {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	retryAfter := int(math.Ceil(err.retryAfter.Seconds()))
+	if retryAfter < 1 {
+		retryAfter = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	http.Error(w, err.Error(), http.StatusTooManyRequests)
+}
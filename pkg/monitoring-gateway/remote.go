@@ -12,14 +12,21 @@ import (
 
 	"github.com/pkg/errors"
 	config_util "github.com/prometheus/common/config"
+	"github.com/prometheus/prometheus/model/relabel"
 	"gopkg.in/yaml.v2"
 )
 
 type remoteWriteClient struct {
 	Client *http.Client
+	name   string
 	url    *config_util.URL
 
-	timeout time.Duration
+	timeout     time.Duration
+	queueConfig QueueConfig
+	protoMsg    RemoteWriteProtoMsg
+
+	tenants             *TenantSelector
+	writeRelabelConfigs []*relabel.Config
 }
 
 // LoadExternalRemoteWriteConfig loads remotewrites config, and prefers file to content
@@ -44,21 +51,6 @@ func LoadExternalRemoteWriteConfig(file, content string) ([]ExternalRemoteWriteC
 	return rws, nil
 }
 
-func NewExternalRemoteWriteClients(rwsCfg []ExternalRemoteWriteConfig) ([]*remoteWriteClient, error) {
-
-	var clients []*remoteWriteClient
-	for _, rwCfg := range rwsCfg {
-		writeClient, err := newExternalRemoteWriteClient(&rwCfg)
-		if err != nil {
-			return nil, err
-		}
-		if writeClient != nil {
-			clients = append(clients, writeClient)
-		}
-	}
-	return clients, nil
-}
-
 func newExternalRemoteWriteClient(conf *ExternalRemoteWriteConfig) (*remoteWriteClient, error) {
 	cfg := config_util.HTTPClientConfig{
 		TLSConfig:   conf.TLSConfig,
@@ -85,14 +77,45 @@ func newExternalRemoteWriteClient(conf *ExternalRemoteWriteConfig) (*remoteWrite
 	if conf.RemoteTimeout > 0 {
 		timeout = time.Duration(conf.RemoteTimeout)
 	}
+	queueConfig := DefaultQueueConfig
+	if qc := conf.QueueConfig; qc != nil {
+		if qc.Capacity > 0 {
+			queueConfig.Capacity = qc.Capacity
+		}
+		if qc.Shards > 0 {
+			queueConfig.Shards = qc.Shards
+		}
+		if qc.MaxRetries > 0 {
+			queueConfig.MaxRetries = qc.MaxRetries
+		}
+		if qc.MinBackoff > 0 {
+			queueConfig.MinBackoff = qc.MinBackoff
+		}
+		if qc.MaxBackoff > 0 {
+			queueConfig.MaxBackoff = qc.MaxBackoff
+		}
+	}
+	protoMsg := RemoteWriteProtoMsgV1
+	if conf.ProtobufMessage != "" {
+		protoMsg = conf.ProtobufMessage
+		if err := protoMsg.Validate(); err != nil {
+			return nil, err
+		}
+	}
 	return &remoteWriteClient{
-		Client:  httpClient,
-		url:     conf.URL,
-		timeout: timeout,
+		Client:      httpClient,
+		name:        conf.Name,
+		url:         conf.URL,
+		timeout:     timeout,
+		queueConfig: queueConfig,
+		protoMsg:    protoMsg,
+
+		tenants:             conf.Tenants,
+		writeRelabelConfigs: conf.WriteRelabelConfigs,
 	}, nil
 }
 
-func (c *remoteWriteClient) Send(ctx context.Context, body []byte, header http.Header) result {
+func (c *remoteWriteClient) Send(ctx context.Context, body []byte, protoMsg RemoteWriteProtoMsg, header http.Header) result {
 	httpReq, err := http.NewRequest("POST", c.url.String(), bytes.NewReader(body))
 	if err != nil {
 		return result{code: http.StatusBadRequest, err: err}
@@ -104,8 +127,8 @@ func (c *remoteWriteClient) Send(ctx context.Context, body []byte, header http.H
 	}
 
 	httpReq.Header.Add("Content-Encoding", "snappy")
-	httpReq.Header.Set("Content-Type", "application/x-protobuf")
-	httpReq.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	httpReq.Header.Set("Content-Type", protoMsg.ContentType())
+	httpReq.Header.Set(remoteWriteVersionHeader, protoMsg.Version())
 	ctx, cancel := context.WithTimeout(ctx, c.timeout)
 	defer cancel()
 
@@ -129,6 +152,36 @@ func (c *remoteWriteClient) Send(ctx context.Context, body []byte, header http.H
 	return result{code: httpResp.StatusCode, err: err}
 }
 
+// prepare returns the body and its message to send for a remote write request of the tenant, and false if nothing
+// of the request is to be sent to the target. The request is given as a 1.0 body and, if it was received as 2.0,
+// the original 2.0 body, which is sent as is to targets supporting 2.0 unless the series are relabeled.
+func (c *remoteWriteClient) prepare(tenant string, body, v2Body []byte) ([]byte, RemoteWriteProtoMsg, bool, error) {
+	if !c.tenants.Selects(tenant) {
+		return nil, "", false, nil
+	}
+	if len(c.writeRelabelConfigs) == 0 {
+		if c.protoMsg == RemoteWriteProtoMsgV2 && v2Body != nil {
+			return v2Body, RemoteWriteProtoMsgV2, true, nil
+		}
+		return body, RemoteWriteProtoMsgV1, true, nil
+	}
+
+	wreq, err := decodeWriteRequest(body)
+	if err != nil {
+		return nil, "", false, err
+	}
+	relabelWriteRequest(wreq, c.writeRelabelConfigs)
+	if len(wreq.Timeseries) == 0 {
+		return nil, "", false, nil
+	}
+	body, err = encodeWriteRequest(wreq)
+	if err != nil {
+		return nil, "", false, err
+	}
+	// Receivers of 2.0 requests accept 1.0 requests as well.
+	return body, RemoteWriteProtoMsgV1, true, nil
+}
+
 func (c remoteWriteClient) Endpoint() string {
 	return c.url.String()
 }
@@ -0,0 +1,285 @@
+package monitoringgateway
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/storage/remote"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/prometheus/prometheus/tsdb/chunks"
+	"github.com/thanos-io/thanos/pkg/store/labelpb"
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+)
+
+const streamedChunksContentType = "application/x-streamed-protobuf; proto=prometheus.ChunkedReadResponse"
+
+// remoteRead serves the Prometheus remote read API for the tenants from the series of the StoreAPI.
+// The tenant matcher and the access policy matchers are added to the matchers of every query, so that only series of the
+// tenants the caller may access are read.
+func (h *Handler) remoteRead(w http.ResponseWriter, req *http.Request) {
+	if h.storeClient == nil {
+		http.Error(w, "The store target is not configured for the server", http.StatusNotAcceptable)
+		return
+	}
+
+	rreq, err := remote.DecodeReadRequest(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	respType, err := remote.NegotiateResponseType(rreq.AcceptedResponseTypes)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	requestInfo, _ := requestInfoFrom(req.Context())
+	limits := h.queryLimits(requestInfo.Tenants)
+	matchers, err := h.queryMatchers(requestInfo)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	release, ok := h.acquireQuerySlots(w, requestInfo.Tenants)
+	if !ok {
+		return
+	}
+	defer release()
+	req, cancel := withQueryTimeout(req, limits.QueryTimeout)
+	defer cancel()
+
+	seriesReqs := make([]*storepb.SeriesRequest, 0, len(rreq.Queries))
+	for _, q := range rreq.Queries {
+		sreq, err := seriesRequest(q, matchers...)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if limits.MaxQueryLookback > 0 {
+			sreq.MinTime = max(sreq.MinTime, time.Now().Add(-time.Duration(limits.MaxQueryLookback)).UnixMilli())
+		}
+		seriesReqs = append(seriesReqs, sreq)
+	}
+
+	switch respType {
+	case prompb.ReadRequest_STREAMED_XOR_CHUNKS:
+		h.remoteReadStreamedChunks(req.Context(), w, requestInfo.TenantId, seriesReqs)
+	default:
+		h.remoteReadSamples(req.Context(), w, requestInfo.TenantId, seriesReqs)
+	}
+}
+
+// remoteReadSamples responds with the samples of the queries.
+func (h *Handler) remoteReadSamples(ctx context.Context, w http.ResponseWriter, tenant string, seriesReqs []*storepb.SeriesRequest) {
+	resp := &prompb.ReadResponse{Results: make([]*prompb.QueryResult, len(seriesReqs))}
+	for i, sreq := range seriesReqs {
+		result := &prompb.QueryResult{}
+		err := h.readSeries(ctx, sreq, func(s *storepb.Series) error {
+			ts, err := seriesToTimeSeries(s, sreq.MinTime, sreq.MaxTime)
+			if err != nil {
+				return err
+			}
+			if len(ts.Samples) > 0 || len(ts.Histograms) > 0 {
+				result.Timeseries = append(result.Timeseries, ts)
+			}
+			return nil
+		})
+		if err != nil {
+			level.Error(h.logger).Log("msg", "failed to read series", "tenant", tenant, "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		resp.Results[i] = result
+	}
+
+	if err := remote.EncodeReadResponse(resp, w); err != nil {
+		level.Error(h.logger).Log("msg", "failed to write remote read response", "tenant", tenant, "err", err)
+	}
+}
+
+// remoteReadStreamedChunks responds with the chunks of the queries, one frame per series.
+func (h *Handler) remoteReadStreamedChunks(ctx context.Context, w http.ResponseWriter, tenant string, seriesReqs []*storepb.SeriesRequest) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "internal http.ResponseWriter does not implement http.Flusher interface", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", streamedChunksContentType)
+
+	var (
+		stream      = remote.NewChunkedWriter(w, flusher)
+		marshalPool = &sync.Pool{}
+	)
+	for i, sreq := range seriesReqs {
+		err := h.readSeries(ctx, sreq, func(s *storepb.Series) error {
+			cs, err := seriesToChunkedSeries(s)
+			if err != nil {
+				return err
+			}
+			if len(cs.Chunks) == 0 {
+				return nil
+			}
+			resp := &prompb.ChunkedReadResponse{ChunkedSeries: []*prompb.ChunkedSeries{cs}, QueryIndex: int64(i)}
+			b, err := resp.PooledMarshal(marshalPool)
+			if err != nil {
+				return errors.Wrap(err, "marshalling chunked read response")
+			}
+			if _, err := stream.Write(b); err != nil {
+				return errors.Wrap(err, "writing to stream")
+			}
+			marshalPool.Put(&b)
+			return nil
+		})
+		if err != nil {
+			// The response may be partially written already, so the error is only logged and the stream is cut.
+			level.Error(h.logger).Log("msg", "failed to stream series", "tenant", tenant, "err", err)
+			return
+		}
+	}
+}
+
+// readSeries calls f for every series the StoreAPI returns for the request.
+func (h *Handler) readSeries(ctx context.Context, sreq *storepb.SeriesRequest, f func(*storepb.Series) error) error {
+	client, err := h.storeClient.Series(ctx, sreq)
+	if err != nil {
+		return errors.Wrap(err, "requesting series")
+	}
+	for {
+		resp, err := client.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrap(err, "receiving series")
+		}
+		if w := resp.GetWarning(); w != "" {
+			level.Debug(h.logger).Log("msg", "warning from store", "warning", w)
+			continue
+		}
+		if s := resp.GetSeries(); s != nil {
+			if err := f(s); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// seriesRequest returns the StoreAPI request for a remote read query, restricted by the enforced matchers.
+func seriesRequest(q *prompb.Query, enforced ...*labels.Matcher) (*storepb.SeriesRequest, error) {
+	matchers, err := remote.FromLabelMatchers(q.Matchers)
+	if err != nil {
+		return nil, err
+	}
+	sms, err := storepb.PromMatchersToMatchers(append(matchers, enforced...)...)
+	if err != nil {
+		return nil, err
+	}
+	return &storepb.SeriesRequest{
+		MinTime:                 q.StartTimestampMs,
+		MaxTime:                 q.EndTimestampMs,
+		Matchers:                sms,
+		Aggregates:              []storepb.Aggr{storepb.Aggr_RAW},
+		PartialResponseStrategy: storepb.PartialResponseStrategy_ABORT,
+	}, nil
+}
+
+// mergedChunks returns the chunks of a StoreAPI series with the overlapping chunks merged, as the stores may return
+// overlapping chunks of a series, e.g. of the replicas of an ingester.
+func mergedChunks(s *storepb.Series) (chunks.Iterator, error) {
+	series := make([]storage.ChunkSeries, 0, len(s.Chunks))
+	for _, c := range s.Chunks {
+		if c.Raw == nil {
+			continue
+		}
+		chk, err := chunkenc.FromData(chunkEncoding(c.Raw.Type), c.Raw.Data)
+		if err != nil {
+			return nil, errors.Wrap(err, "decoding chunk")
+		}
+		meta := chunks.Meta{MinTime: c.MinTime, MaxTime: c.MaxTime, Chunk: chk}
+		series = append(series, &storage.ChunkSeriesEntry{ChunkIteratorFn: func(chunks.Iterator) chunks.Iterator {
+			return storage.NewListChunkSeriesIterator(meta)
+		}})
+	}
+	if len(series) == 0 {
+		return storage.NewListChunkSeriesIterator(), nil
+	}
+	return storage.NewCompactingChunkSeriesMerger(storage.ChainedSeriesMerge)(series...).Iterator(nil), nil
+}
+
+// seriesToChunkedSeries converts the raw chunks of a StoreAPI series into a remote read series.
+func seriesToChunkedSeries(s *storepb.Series) (*prompb.ChunkedSeries, error) {
+	cs := &prompb.ChunkedSeries{Labels: prompb.FromLabels(labelpb.ZLabelsToPromLabels(s.Labels), nil)}
+	it, err := mergedChunks(s)
+	if err != nil {
+		return nil, err
+	}
+	for it.Next() {
+		meta := it.At()
+		cs.Chunks = append(cs.Chunks, prompb.Chunk{
+			MinTimeMs: meta.MinTime,
+			MaxTimeMs: meta.MaxTime,
+			Type:      prompb.Chunk_Encoding(meta.Chunk.Encoding()),
+			Data:      meta.Chunk.Bytes(),
+		})
+	}
+	if err := it.Err(); err != nil {
+		return nil, errors.Wrap(err, "merging chunks")
+	}
+	return cs, nil
+}
+
+// seriesToTimeSeries decodes the raw chunks of a StoreAPI series into the samples between mint and maxt.
+func seriesToTimeSeries(s *storepb.Series, mint, maxt int64) (*prompb.TimeSeries, error) {
+	ts := &prompb.TimeSeries{Labels: prompb.FromLabels(labelpb.ZLabelsToPromLabels(s.Labels), nil)}
+	chks, err := mergedChunks(s)
+	if err != nil {
+		return nil, err
+	}
+	var it chunkenc.Iterator
+	for chks.Next() {
+		it = chks.At().Chunk.Iterator(it)
+		for vt := it.Next(); vt != chunkenc.ValNone; vt = it.Next() {
+			if t := it.AtT(); t < mint || t > maxt {
+				continue
+			}
+			switch vt {
+			case chunkenc.ValFloat:
+				t, v := it.At()
+				ts.Samples = append(ts.Samples, prompb.Sample{Timestamp: t, Value: v})
+			case chunkenc.ValHistogram:
+				t, h := it.AtHistogram(nil)
+				ts.Histograms = append(ts.Histograms, prompb.FromIntHistogram(t, h))
+			case chunkenc.ValFloatHistogram:
+				t, fh := it.AtFloatHistogram(nil)
+				ts.Histograms = append(ts.Histograms, prompb.FromFloatHistogram(t, fh))
+			}
+		}
+		if err := it.Err(); err != nil {
+			return nil, errors.Wrap(err, "iterating chunk")
+		}
+	}
+	if err := chks.Err(); err != nil {
+		return nil, errors.Wrap(err, "merging chunks")
+	}
+	return ts, nil
+}
+
+// chunkEncoding maps the encoding of a StoreAPI chunk to the TSDB chunk encoding, which remote read uses as well.
+func chunkEncoding(e storepb.Chunk_Encoding) chunkenc.Encoding {
+	switch e {
+	case storepb.Chunk_HISTOGRAM:
+		return chunkenc.EncHistogram
+	case storepb.Chunk_FLOAT_HISTOGRAM:
+		return chunkenc.EncFloatHistogram
+	default:
+		return chunkenc.EncXOR
+	}
+}
@@ -0,0 +1,98 @@
+package monitoringgateway
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/thanos-io/thanos/pkg/store/labelpb"
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+)
+
+func TestSeriesRequest(t *testing.T) {
+	q := &prompb.Query{
+		StartTimestampMs: 10,
+		EndTimestampMs:   20,
+		Matchers: []*prompb.LabelMatcher{
+			{Type: prompb.LabelMatcher_EQ, Name: "__name__", Value: "up"},
+			{Type: prompb.LabelMatcher_EQ, Name: "tenant_id", Value: "b"},
+		},
+	}
+	sreq, err := seriesRequest(q, labels.MustNewMatcher(labels.MatchEqual, "tenant_id", "a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []storepb.LabelMatcher{
+		{Type: storepb.LabelMatcher_EQ, Name: "__name__", Value: "up"},
+		{Type: storepb.LabelMatcher_EQ, Name: "tenant_id", Value: "b"},
+		{Type: storepb.LabelMatcher_EQ, Name: "tenant_id", Value: "a"},
+	}
+	if diff := cmp.Diff(want, sreq.Matchers); diff != "" {
+		t.Fatal(diff)
+	}
+	if sreq.MinTime != 10 || sreq.MaxTime != 20 {
+		t.Fatalf("unexpected time range [%d, %d]", sreq.MinTime, sreq.MaxTime)
+	}
+}
+
+func TestSeriesToTimeSeries(t *testing.T) {
+	chk := chunkenc.NewXORChunk()
+	app, err := chk.Appender()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ts := int64(0); ts < 5; ts++ {
+		app.Append(ts*10, float64(ts))
+	}
+
+	s := &storepb.Series{
+		Labels: labelpb.ZLabelsFromPromLabels(labels.FromStrings("__name__", "up", "tenant_id", "a")),
+		Chunks: []storepb.AggrChunk{{MinTime: 0, MaxTime: 40, Raw: &storepb.Chunk{Type: storepb.Chunk_XOR, Data: chk.Bytes()}}},
+	}
+
+	got, err := seriesToTimeSeries(s, 10, 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &prompb.TimeSeries{
+		Labels:  []prompb.Label{{Name: "__name__", Value: "up"}, {Name: "tenant_id", Value: "a"}},
+		Samples: []prompb.Sample{{Timestamp: 10, Value: 1}, {Timestamp: 20, Value: 2}, {Timestamp: 30, Value: 3}},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatal(diff)
+	}
+
+	cs, err := seriesToChunkedSeries(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cs.Chunks) != 1 || cs.Chunks[0].Type != prompb.Chunk_XOR {
+		t.Fatalf("unexpected chunks %v", cs.Chunks)
+	}
+
+	// Overlapping chunks, e.g. of the replicas of an ingester, are merged into one chunk without duplicate samples.
+	overlapping := chunkenc.NewXORChunk()
+	if app, err = overlapping.Appender(); err != nil {
+		t.Fatal(err)
+	}
+	for ts := int64(3); ts < 7; ts++ {
+		app.Append(ts*10, float64(ts))
+	}
+	s.Chunks = append(s.Chunks, storepb.AggrChunk{MinTime: 30, MaxTime: 60, Raw: &storepb.Chunk{Type: storepb.Chunk_XOR, Data: overlapping.Bytes()}})
+
+	if got, err = seriesToTimeSeries(s, 0, 60); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Samples) != 7 || got.Samples[6].Timestamp != 60 {
+		t.Fatalf("expected 7 merged samples, got %v", got.Samples)
+	}
+	if cs, err = seriesToChunkedSeries(s); err != nil {
+		t.Fatal(err)
+	}
+	if len(cs.Chunks) != 1 || cs.Chunks[0].MinTimeMs != 0 || cs.Chunks[0].MaxTimeMs != 60 {
+		t.Fatalf("expected 1 merged chunk, got %v", cs.Chunks)
+	}
+}
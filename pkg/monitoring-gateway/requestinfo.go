@@ -2,7 +2,10 @@ package monitoringgateway
 
 import (
 	"context"
+	"fmt"
 	"net/http"
+	"slices"
+	"strings"
 
 	"github.com/gorilla/mux"
 )
@@ -11,8 +14,26 @@ type requestInfoKeyType int
 
 const requestInfoKey requestInfoKeyType = iota
 
+// tenantSetSeparator separates the tenants of a tenant set, e.g. /tenant_a|tenant_b/api/v1/query.
+const tenantSetSeparator = "|"
+
 type RequestInfo struct {
 	TenantId string
+	// Tenants are the tenants addressed by the request. There is more than one tenant if the request addresses a tenant set.
+	Tenants []string
+	// Identity is the authenticated caller, if the gateway authenticates requests.
+	Identity *Identity
+}
+
+// parseTenants splits a tenant set into its distinct tenants.
+func parseTenants(tenantId string) []string {
+	var tenants []string
+	for _, tenant := range strings.Split(tenantId, tenantSetSeparator) {
+		if tenant != "" && !slices.Contains(tenants, tenant) {
+			tenants = append(tenants, tenant)
+		}
+	}
+	return tenants
 }
 
 func requestInfoFrom(ctx context.Context) (*RequestInfo, bool) {
@@ -29,10 +50,58 @@ func withRequestInfo(f http.HandlerFunc) http.HandlerFunc {
 		}
 		ctx := req.Context()
 
+		tenantId := mux.Vars(req)["tenant_id"]
+		tenants := parseTenants(tenantId)
+		// The tenant is the key of the tenant header, the tenant label and the limits of the tenant downstream, so it
+		// must be the tenants that are authorized and admitted, without empty or repeated tenants.
+		if tenantId != strings.Join(tenants, tenantSetSeparator) {
+			err := fmt.Errorf("invalid tenant %q, tenant sets must not have empty or repeated tenants", tenantId)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		req = req.WithContext(context.WithValue(ctx, requestInfoKey, &RequestInfo{
-			TenantId: mux.Vars(req)["tenant_id"],
+			TenantId: tenantId,
+			Tenants:  tenants,
 		}))
 
 		f.ServeHTTP(w, req)
 	})
 }
+
+// withHeaderTenant sets the request info of the requests of the global routes from the tenant header, and rejects
+// tenants that are not admitted. Requests without the tenant header are passed on as they are.
+func (h *Handler) withHeaderTenant(f http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		tenantId := req.Header.Get(h.upstreams.Load().tenantHeader)
+		if tenantId == "" {
+			f.ServeHTTP(w, req)
+			return
+		}
+		if tenants := parseTenants(tenantId); len(tenants) != 1 || tenants[0] != tenantId {
+			err := fmt.Errorf("invalid tenant %q, the request must address a single tenant", tenantId)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		req = req.WithContext(context.WithValue(req.Context(), requestInfoKey, &RequestInfo{
+			TenantId: tenantId,
+			Tenants:  []string{tenantId},
+		}))
+
+		withTenantsAdmission(f, h.tenantsAdmissionMap, h.options.EnabledTenantsAdmission).ServeHTTP(w, req)
+	})
+}
+
+// withSingleTenant rejects requests that address a tenant set.
+func withSingleTenant(f http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+
+		requestInfo, found := requestInfoFrom(req.Context())
+		if found && len(requestInfo.Tenants) > 1 {
+			err := fmt.Errorf("the request must address a single tenant, got %s", requestInfo.TenantId)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		f.ServeHTTP(w, req)
+	})
+}
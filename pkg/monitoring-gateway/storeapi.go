@@ -0,0 +1,181 @@
+package monitoringgateway
+
+import (
+	"context"
+	"io"
+	"slices"
+	"time"
+
+	"github.com/thanos-io/thanos/pkg/info/infopb"
+	"github.com/thanos-io/thanos/pkg/store/labelpb"
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// storeAPIServer serves the StoreAPI of the tenants of the caller from the StoreAPI of the gateway, e.g. of Thanos Query,
+// so that external queriers can federate the data of the tenants they are allowed to access.
+// The tenant matcher and the access policy matchers of the caller are added to the matchers of every request.
+type storeAPIServer struct {
+	storepb.UnimplementedStoreServer
+	infopb.UnimplementedInfoServer
+
+	handler *Handler
+}
+
+// RegisterStoreAPIServer registers the tenant-scoped StoreAPI and the Info API on the gRPC server.
+// Callers are authenticated by the authenticator of the gateway, i.e. by their client certificate or bearer token.
+// They may narrow the tenants by the metadata named like the tenant header, given as a tenant set, e.g. a|b.
+func (h *Handler) RegisterStoreAPIServer(s *grpc.Server) {
+	srv := &storeAPIServer{handler: h}
+	storepb.RegisterStoreServer(s, srv)
+	infopb.RegisterInfoServer(s, srv)
+}
+
+// requestInfo returns the tenants and the identity of the caller.
+func (s *storeAPIServer) requestInfo(ctx context.Context) (*RequestInfo, error) {
+	h := s.handler
+	if h.storeClient == nil || h.storeInfoClient == nil {
+		return nil, status.Error(codes.FailedPrecondition, "the store target is not configured for the server")
+	}
+	if h.options.Authenticator == nil {
+		return nil, status.Error(codes.Unauthenticated, "no authenticator is configured for the server")
+	}
+	identity, ok := h.options.Authenticator.AuthenticateRequest(grpcHTTPRequest(ctx))
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, errUnauthenticated.Error())
+	}
+
+	tenants := identity.Tenants
+	md, _ := metadata.FromIncomingContext(ctx)
+	if vs := md.Get(h.upstreams.Load().tenantHeader); len(vs) > 0 {
+		tenants = parseTenants(vs[0])
+		for _, tenant := range tenants {
+			if !identity.CanAccess(tenant, verbRead) {
+				return nil, status.Errorf(codes.PermissionDenied, "%s is not allowed to access tenant %s", identity.Name, tenant)
+			}
+		}
+	}
+	if h.options.EnabledTenantsAdmission {
+		tenants = slices.DeleteFunc(slices.Clone(tenants), func(tenant string) bool {
+			_, ok := h.tenantsAdmissionMap.Load(tenant)
+			return !ok
+		})
+	}
+	if len(tenants) == 0 {
+		return nil, status.Errorf(codes.PermissionDenied, "%s is not allowed to access any tenant", identity.Name)
+	}
+	return &RequestInfo{Tenants: tenants, Identity: identity}, nil
+}
+
+// enforceMatchers returns the matchers with the tenant matcher and the access policy matchers of the caller added.
+func (s *storeAPIServer) enforceMatchers(requestInfo *RequestInfo, matchers []storepb.LabelMatcher) ([]storepb.LabelMatcher, error) {
+	ms, err := s.handler.queryMatchers(requestInfo)
+	if err != nil {
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	}
+	tms, err := storepb.PromMatchersToMatchers(ms...)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return append(slices.Clone(matchers), tms...), nil
+}
+
+// Info advertises the external label sets of the tenants of the caller only.
+func (s *storeAPIServer) Info(ctx context.Context, req *infopb.InfoRequest) (*infopb.InfoResponse, error) {
+	requestInfo, err := s.requestInfo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	tenants := requestInfo.Tenants
+	resp, err := s.handler.storeInfoClient.Info(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	tenantLabelName := s.handler.upstreams.Load().tenantLabelName
+	labelSets := make([]labelpb.ZLabelSet, 0, len(tenants))
+	for _, ls := range resp.LabelSets {
+		if slices.Contains(tenants, ls.PromLabels().Get(tenantLabelName)) {
+			labelSets = append(labelSets, ls)
+		}
+	}
+	// The downstream may not announce tenants as external labels, but every series of the caller has the tenant label.
+	if len(labelSets) == 0 {
+		for _, tenant := range tenants {
+			labelSets = append(labelSets, labelpb.ZLabelSet{Labels: []labelpb.ZLabel{{Name: tenantLabelName, Value: tenant}}})
+		}
+	}
+
+	return &infopb.InfoResponse{
+		LabelSets:     labelSets,
+		ComponentType: resp.ComponentType,
+		Store:         resp.Store,
+	}, nil
+}
+
+func (s *storeAPIServer) Series(req *storepb.SeriesRequest, srv storepb.Store_SeriesServer) error {
+	ctx := srv.Context()
+	requestInfo, err := s.requestInfo(ctx)
+	if err != nil {
+		return err
+	}
+	r := *req
+	if r.Matchers, err = s.enforceMatchers(requestInfo, req.Matchers); err != nil {
+		return err
+	}
+	r.MinTime = s.clampMinTime(requestInfo, r.MinTime)
+
+	client, err := s.handler.storeClient.Series(ctx, &r)
+	if err != nil {
+		return err
+	}
+	for {
+		resp, err := client.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := srv.Send(resp); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *storeAPIServer) LabelNames(ctx context.Context, req *storepb.LabelNamesRequest) (*storepb.LabelNamesResponse, error) {
+	requestInfo, err := s.requestInfo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	r := *req
+	if r.Matchers, err = s.enforceMatchers(requestInfo, req.Matchers); err != nil {
+		return nil, err
+	}
+	r.Start = s.clampMinTime(requestInfo, r.Start)
+	return s.handler.storeClient.LabelNames(ctx, &r)
+}
+
+func (s *storeAPIServer) LabelValues(ctx context.Context, req *storepb.LabelValuesRequest) (*storepb.LabelValuesResponse, error) {
+	requestInfo, err := s.requestInfo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	r := *req
+	if r.Matchers, err = s.enforceMatchers(requestInfo, req.Matchers); err != nil {
+		return nil, err
+	}
+	r.Start = s.clampMinTime(requestInfo, r.Start)
+	return s.handler.storeClient.LabelValues(ctx, &r)
+}
+
+// clampMinTime returns the start of a request in milliseconds limited to the query lookback of the tenants.
+func (s *storeAPIServer) clampMinTime(requestInfo *RequestInfo, minTime int64) int64 {
+	if limits := s.handler.queryLimits(requestInfo.Tenants); limits.MaxQueryLookback > 0 {
+		return max(minTime, time.Now().Add(-time.Duration(limits.MaxQueryLookback)).UnixMilli())
+	}
+	return minTime
+}
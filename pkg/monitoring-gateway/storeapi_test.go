@@ -0,0 +1,129 @@
+package monitoringgateway
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"github.com/thanos-io/thanos/pkg/info/infopb"
+	"github.com/thanos-io/thanos/pkg/store/labelpb"
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+type fakeStore struct {
+	storepb.UnimplementedStoreServer
+	infopb.UnimplementedInfoServer
+
+	matchers []storepb.LabelMatcher
+	start    int64
+}
+
+func (s *fakeStore) LabelNames(_ context.Context, req *storepb.LabelNamesRequest) (*storepb.LabelNamesResponse, error) {
+	s.matchers = req.Matchers
+	s.start = req.Start
+	return &storepb.LabelNamesResponse{Names: []string{"__name__", "tenant_id"}}, nil
+}
+
+func (s *fakeStore) Info(context.Context, *infopb.InfoRequest) (*infopb.InfoResponse, error) {
+	return &infopb.InfoResponse{
+		ComponentType: "query",
+		LabelSets: []labelpb.ZLabelSet{
+			{Labels: []labelpb.ZLabel{{Name: "tenant_id", Value: "a"}}},
+			{Labels: []labelpb.ZLabel{{Name: "tenant_id", Value: "b"}}},
+		},
+	}, nil
+}
+
+type tokenAuthenticator map[string]*Identity
+
+func (a tokenAuthenticator) AuthenticateRequest(req *http.Request) (*Identity, bool) {
+	identity, ok := a[req.Header.Get("Authorization")]
+	return identity, ok
+}
+
+func serveBufconn(t *testing.T, register func(*grpc.Server)) *grpc.ClientConn {
+	l := bufconn.Listen(1 << 20)
+	s := grpc.NewServer()
+	register(s)
+	go func() { _ = s.Serve(l) }()
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return l.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestStoreAPIServer(t *testing.T) {
+	store := &fakeStore{}
+	storeConn := serveBufconn(t, func(s *grpc.Server) {
+		storepb.RegisterStoreServer(s, store)
+		infopb.RegisterInfoServer(s, store)
+	})
+
+	h := NewHandler(nil, prometheus.NewRegistry(), &Options{
+		TenantHeader:    "WHIZARD-TENANT",
+		TenantLabelName: "tenant_id",
+		StoreClient:     storepb.NewStoreClient(storeConn),
+		StoreInfoClient: infopb.NewInfoClient(storeConn),
+		Authenticator:   tokenAuthenticator{"Bearer a": {Name: "a", Tenants: []string{"a"}}},
+	})
+	conn := serveBufconn(t, h.RegisterStoreAPIServer)
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer a")
+	if _, err := storepb.NewStoreClient(conn).LabelNames(ctx, &storepb.LabelNamesRequest{
+		Matchers: []storepb.LabelMatcher{{Type: storepb.LabelMatcher_EQ, Name: "__name__", Value: "up"}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	want := []storepb.LabelMatcher{
+		{Type: storepb.LabelMatcher_EQ, Name: "__name__", Value: "up"},
+		{Type: storepb.LabelMatcher_EQ, Name: "tenant_id", Value: "a"},
+	}
+	if diff := cmp.Diff(want, store.matchers); diff != "" {
+		t.Fatal(diff)
+	}
+
+	// The time range of label requests is limited to the query lookback of the tenant.
+	if err := h.SetLimits(LimitsConfig{Defaults: TenantLimits{MaxQueryLookback: model.Duration(time.Hour)}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := storepb.NewStoreClient(conn).LabelNames(ctx, &storepb.LabelNamesRequest{End: time.Now().UnixMilli()}); err != nil {
+		t.Fatal(err)
+	}
+	if lookback := time.Now().Add(-time.Hour).UnixMilli(); store.start < lookback-time.Minute.Milliseconds() || store.start > lookback {
+		t.Fatalf("expected the start clamped to the query lookback, got %d", store.start)
+	}
+
+	info, err := infopb.NewInfoClient(conn).Info(ctx, &infopb.InfoRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]labelpb.ZLabelSet{{Labels: []labelpb.ZLabel{{Name: "tenant_id", Value: "a"}}}}, info.LabelSets); diff != "" {
+		t.Fatal(diff)
+	}
+
+	_, err = storepb.NewStoreClient(conn).LabelNames(metadata.AppendToOutgoingContext(ctx, "whizard-tenant", "b"), &storepb.LabelNamesRequest{})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("expected permission denied for another tenant, got %v", err)
+	}
+	_, err = storepb.NewStoreClient(conn).LabelNames(context.Background(), &storepb.LabelNamesRequest{})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected unauthenticated without token, got %v", err)
+	}
+}
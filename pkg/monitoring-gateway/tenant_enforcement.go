@@ -0,0 +1,175 @@
+package monitoringgateway
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/prompb"
+	prometheustranslator "github.com/prometheus/prometheus/storage/remote/otlptranslator/prometheus"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+	"go.opentelemetry.io/collector/pdata/pmetric/pmetricotlp"
+)
+
+// TenantLabelEnforcement is how the tenant label of the series written by a tenant is enforced.
+type TenantLabelEnforcement string
+
+const (
+	// TenantLabelEnforcementNone forwards the series as they are.
+	TenantLabelEnforcementNone TenantLabelEnforcement = "none"
+	// TenantLabelEnforcementReject rejects requests with series whose tenant label differs from the tenant of the request.
+	TenantLabelEnforcementReject TenantLabelEnforcement = "reject"
+	// TenantLabelEnforcementOverwrite overwrites the tenant label of series with the tenant of the request.
+	TenantLabelEnforcementOverwrite TenantLabelEnforcement = "overwrite"
+)
+
+const reasonTenantLabelMismatch = "tenant_label_mismatch"
+
+// Validate returns an error if the enforcement is unknown.
+func (e TenantLabelEnforcement) Validate() error {
+	switch e {
+	case TenantLabelEnforcementNone, TenantLabelEnforcementReject, TenantLabelEnforcementOverwrite:
+		return nil
+	}
+	return errors.Errorf("unknown tenant label enforcement %q", e)
+}
+
+// enforceTenantLabel enforces that the tenant label of the series, if set, equals the tenant.
+// It reports whether series were changed.
+func enforceTenantLabel(wreq *prompb.WriteRequest, labelName, tenant string, e TenantLabelEnforcement) (bool, error) {
+	if e == TenantLabelEnforcementNone || e == "" {
+		return false, nil
+	}
+
+	changed := false
+	for i := range wreq.Timeseries {
+		lbls := wreq.Timeseries[i].Labels
+		for j := range lbls {
+			if lbls[j].Name != labelName || lbls[j].Value == tenant {
+				continue
+			}
+			if e == TenantLabelEnforcementReject {
+				return false, fmt.Errorf("series %s has the tenant label %s=%q, which differs from the tenant %q", seriesName(lbls), labelName, lbls[j].Value, tenant)
+			}
+			lbls[j].Value = tenant
+			changed = true
+		}
+	}
+	return changed, nil
+}
+
+// seriesName returns the metric name of the series labels.
+func seriesName(lbls []prompb.Label) string {
+	for _, l := range lbls {
+		if l.Name == labels.MetricName {
+			return l.Value
+		}
+	}
+	return "{}"
+}
+
+// enforceOTLPTenantAttribute enforces that the tenant attribute of the resources and data points, if set, equals the tenant.
+// The resource attributes become labels of target_info and the data point attributes labels of the series.
+// It reports whether attributes were changed.
+func enforceOTLPTenantAttribute(req pmetricotlp.ExportRequest, labelName, tenant string, e TenantLabelEnforcement) (bool, error) {
+	if e == TenantLabelEnforcementNone || e == "" {
+		return false, nil
+	}
+
+	changed := false
+	enforce := func(attrs pcommon.Map) error {
+		// Attribute names are normalized into label names by the translation, e.g. tenant.id becomes tenant_id, so
+		// every attribute that becomes the tenant label is enforced.
+		differs := false
+		var err error
+		attrs.Range(func(k string, v pcommon.Value) bool {
+			if prometheustranslator.NormalizeLabel(k) != labelName || v.AsString() == tenant {
+				return true
+			}
+			if e == TenantLabelEnforcementReject {
+				err = fmt.Errorf("the tenant attribute %s=%q differs from the tenant %q", k, v.AsString(), tenant)
+				return false
+			}
+			differs = true
+			return true
+		})
+		if err != nil || !differs {
+			return err
+		}
+		attrs.RemoveIf(func(k string, _ pcommon.Value) bool {
+			return prometheustranslator.NormalizeLabel(k) == labelName
+		})
+		attrs.PutStr(labelName, tenant)
+		changed = true
+		return nil
+	}
+
+	rms := req.Metrics().ResourceMetrics()
+	for i := 0; i < rms.Len(); i++ {
+		rm := rms.At(i)
+		if err := enforce(rm.Resource().Attributes()); err != nil {
+			return false, err
+		}
+		sms := rm.ScopeMetrics()
+		for j := 0; j < sms.Len(); j++ {
+			ms := sms.At(j).Metrics()
+			for k := 0; k < ms.Len(); k++ {
+				if err := forEachDataPointAttributes(ms.At(k), enforce); err != nil {
+					return false, err
+				}
+			}
+		}
+	}
+	return changed, nil
+}
+
+// forEachDataPointAttributes calls f with the attributes of every data point of the metric.
+func forEachDataPointAttributes(m pmetric.Metric, f func(pcommon.Map) error) error {
+	switch m.Type() {
+	case pmetric.MetricTypeGauge:
+		dps := m.Gauge().DataPoints()
+		for i := 0; i < dps.Len(); i++ {
+			if err := f(dps.At(i).Attributes()); err != nil {
+				return err
+			}
+		}
+	case pmetric.MetricTypeSum:
+		dps := m.Sum().DataPoints()
+		for i := 0; i < dps.Len(); i++ {
+			if err := f(dps.At(i).Attributes()); err != nil {
+				return err
+			}
+		}
+	case pmetric.MetricTypeHistogram:
+		dps := m.Histogram().DataPoints()
+		for i := 0; i < dps.Len(); i++ {
+			if err := f(dps.At(i).Attributes()); err != nil {
+				return err
+			}
+		}
+	case pmetric.MetricTypeExponentialHistogram:
+		dps := m.ExponentialHistogram().DataPoints()
+		for i := 0; i < dps.Len(); i++ {
+			if err := f(dps.At(i).Attributes()); err != nil {
+				return err
+			}
+		}
+	case pmetric.MetricTypeSummary:
+		dps := m.Summary().DataPoints()
+		for i := 0; i < dps.Len(); i++ {
+			if err := f(dps.At(i).Attributes()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// encodeOTLPRequest encodes the OTLP request uncompressed in the format of the content type.
+func encodeOTLPRequest(req pmetricotlp.ExportRequest, contentType string) ([]byte, error) {
+	if contentType == "application/json" {
+		return req.MarshalJSON()
+	}
+	return req.MarshalProto()
+}
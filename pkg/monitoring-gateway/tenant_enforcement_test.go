@@ -0,0 +1,79 @@
+package monitoringgateway
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/prometheus/prompb"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/pmetric/pmetricotlp"
+)
+
+func TestEnforceTenantLabel(t *testing.T) {
+	newRequest := func() *prompb.WriteRequest {
+		return &prompb.WriteRequest{Timeseries: []prompb.TimeSeries{
+			{Labels: []prompb.Label{{Name: "__name__", Value: "up"}}},
+			{Labels: []prompb.Label{{Name: "__name__", Value: "up"}, {Name: "tenant_id", Value: "a"}}},
+			{Labels: []prompb.Label{{Name: "__name__", Value: "up"}, {Name: "tenant_id", Value: "b"}}},
+		}}
+	}
+
+	wreq := newRequest()
+	if changed, err := enforceTenantLabel(wreq, "tenant_id", "a", TenantLabelEnforcementNone); err != nil || changed {
+		t.Fatalf("expected no change, got %v, %v", changed, err)
+	}
+	if _, err := enforceTenantLabel(wreq, "tenant_id", "a", TenantLabelEnforcementReject); err == nil {
+		t.Fatal("expected error for series of another tenant")
+	}
+
+	changed, err := enforceTenantLabel(wreq, "tenant_id", "a", TenantLabelEnforcementOverwrite)
+	if err != nil || !changed {
+		t.Fatalf("expected change, got %v, %v", changed, err)
+	}
+	want := newRequest()
+	want.Timeseries[2].Labels[1].Value = "a"
+	if diff := cmp.Diff(want, wreq); diff != "" {
+		t.Fatal(diff)
+	}
+	if _, err := enforceTenantLabel(wreq, "tenant_id", "a", TenantLabelEnforcementReject); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestEnforceOTLPTenantAttribute(t *testing.T) {
+	req := pmetricotlp.NewExportRequest()
+	rm := req.Metrics().ResourceMetrics().AppendEmpty()
+	rm.Resource().Attributes().PutStr("tenant_id", "b")
+	m := rm.ScopeMetrics().AppendEmpty().Metrics().AppendEmpty()
+	m.SetName("up")
+	m.SetEmptyGauge().DataPoints().AppendEmpty().Attributes().PutStr("tenant_id", "c")
+
+	if _, err := enforceOTLPTenantAttribute(req, "tenant_id", "a", TenantLabelEnforcementReject); err == nil {
+		t.Fatal("expected error for resource of another tenant")
+	}
+	changed, err := enforceOTLPTenantAttribute(req, "tenant_id", "a", TenantLabelEnforcementOverwrite)
+	if err != nil || !changed {
+		t.Fatalf("expected change, got %v, %v", changed, err)
+	}
+	if v, _ := rm.Resource().Attributes().Get("tenant_id"); v.AsString() != "a" {
+		t.Fatalf("expected resource tenant a, got %s", v.AsString())
+	}
+	if v, _ := m.Gauge().DataPoints().At(0).Attributes().Get("tenant_id"); v.AsString() != "a" {
+		t.Fatalf("expected data point tenant a, got %s", v.AsString())
+	}
+
+	// Attributes normalized into the tenant label are enforced as well.
+	rm.Resource().Attributes().PutStr("tenant.id", "b")
+	m.Gauge().DataPoints().At(0).Attributes().PutStr("tenant-id", "c")
+	if _, err := enforceOTLPTenantAttribute(req, "tenant_id", "a", TenantLabelEnforcementReject); err == nil {
+		t.Fatal("expected error for a normalized attribute of another tenant")
+	}
+	if _, err := enforceOTLPTenantAttribute(req, "tenant_id", "a", TenantLabelEnforcementOverwrite); err != nil {
+		t.Fatal(err)
+	}
+	for _, attrs := range []pcommon.Map{rm.Resource().Attributes(), m.Gauge().DataPoints().At(0).Attributes()} {
+		if diff := cmp.Diff(map[string]any{"tenant_id": "a"}, attrs.AsRaw()); diff != "" {
+			t.Fatal(diff)
+		}
+	}
+}
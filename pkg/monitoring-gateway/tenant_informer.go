@@ -0,0 +1,138 @@
+package monitoringgateway
+
+import (
+	"context"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/rest"
+	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/WhizardTelemetry/whizard/pkg/api/monitoring/v1alpha1"
+	"github.com/WhizardTelemetry/whizard/pkg/constants"
+)
+
+// TenantInformer watches the Tenant objects of a Service, and sends the tenants to admit whenever they change.
+// Unlike the configuration file rendered by the operator, new tenants are admitted as soon as they are created.
+type TenantInformer struct {
+	logger  log.Logger
+	cache   cache.Cache
+	service string
+
+	tenantsGauge prometheus.Gauge
+}
+
+// NewTenantInformer creates a TenantInformer for the Tenant objects labeled with the Service, given as <namespace>.<name>.
+func NewTenantInformer(logger log.Logger, reg prometheus.Registerer, cfg *rest.Config, service string) (*TenantInformer, error) {
+	scheme := runtime.NewScheme()
+	if err := v1alpha1.AddToScheme(scheme); err != nil {
+		return nil, err
+	}
+	informerCache, err := cache.New(cfg, cache.Options{
+		Scheme: scheme,
+		ByObject: map[client.Object]cache.ByObject{
+			&v1alpha1.Tenant{}: {Label: labels.SelectorFromSet(labels.Set{constants.ServiceLabelKey: service})},
+		},
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "creating informer cache")
+	}
+	return newTenantInformer(logger, reg, informerCache, service), nil
+}
+
+func newTenantInformer(logger log.Logger, reg prometheus.Registerer, informerCache cache.Cache, service string) *TenantInformer {
+	if logger == nil {
+		logger = log.NewNopLogger()
+	}
+	return &TenantInformer{
+		logger:  logger,
+		cache:   informerCache,
+		service: service,
+		tenantsGauge: promauto.With(reg).NewGauge(
+			prometheus.GaugeOpts{
+				Name: "whizard_tenant_admission_tenants",
+				Help: "The number of tenants allowed.",
+			}),
+	}
+}
+
+// Run sends the tenants to admit to updates once the Tenant objects are synced and on every change,
+// until the given context is canceled.
+func (ti *TenantInformer) Run(ctx context.Context, updates chan<- AdmissionControlConfig) error {
+	defer close(updates)
+
+	informer, err := ti.cache.GetInformer(ctx, &v1alpha1.Tenant{})
+	if err != nil {
+		return errors.Wrap(err, "getting tenant informer")
+	}
+
+	// Changes are coalesced, as every update carries all tenants.
+	changed := make(chan struct{}, 1)
+	notify := func() {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	}
+	if _, err := informer.AddEventHandler(toolscache.ResourceEventHandlerFuncs{
+		AddFunc:    func(interface{}) { notify() },
+		UpdateFunc: func(interface{}, interface{}) { notify() },
+		DeleteFunc: func(interface{}) { notify() },
+	}); err != nil {
+		return errors.Wrap(err, "adding tenant event handler")
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- ti.cache.Start(ctx)
+	}()
+	if !ti.cache.WaitForCacheSync(ctx) {
+		return errors.New("failed to sync tenant informer")
+	}
+	notify()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-errc:
+			return errors.Wrap(err, "tenant informer stopped")
+		case <-changed:
+			c, err := ti.admittedTenants(ctx)
+			if err != nil {
+				level.Error(ti.logger).Log("msg", "failed to list tenants", "err", err)
+				continue
+			}
+			ti.tenantsGauge.Set(float64(len(c.Tenants)))
+			select {
+			case updates <- c:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+}
+
+// admittedTenants returns the tenants of the synced Tenant objects of the Service. Tenants that are being deleted are
+// not admitted.
+func (ti *TenantInformer) admittedTenants(ctx context.Context) (AdmissionControlConfig, error) {
+	tenantList := &v1alpha1.TenantList{}
+	if err := ti.cache.List(ctx, tenantList, client.MatchingLabels{constants.ServiceLabelKey: ti.service}); err != nil {
+		return AdmissionControlConfig{}, err
+	}
+
+	c := AdmissionControlConfig{Tenants: []string{}}
+	for _, tenant := range tenantList.Items {
+		if tenant.GetDeletionTimestamp().IsZero() && tenant.Spec.Tenant != "" {
+			c.Tenants = append(c.Tenants, tenant.Spec.Tenant)
+		}
+	}
+	return c, nil
+}
@@ -0,0 +1,108 @@
+package monitoringgateway
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/client_golang/prometheus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache/informertest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/WhizardTelemetry/whizard/pkg/api/monitoring/v1alpha1"
+	"github.com/WhizardTelemetry/whizard/pkg/constants"
+)
+
+// fakeTenantCache serves the objects of a fake client, and the events of fake informers.
+type fakeTenantCache struct {
+	*informertest.FakeInformers
+	client client.Client
+}
+
+func (c *fakeTenantCache) Get(ctx context.Context, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+	return c.client.Get(ctx, key, obj, opts...)
+}
+
+func (c *fakeTenantCache) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	return c.client.List(ctx, list, opts...)
+}
+
+// Start runs until the context is canceled, like the cache does.
+func (c *fakeTenantCache) Start(ctx context.Context) error {
+	<-ctx.Done()
+	return nil
+}
+
+func TestTenantInformer(t *testing.T) {
+	const service = "kubesphere-monitoring-system.central"
+	tenant := func(name, service string) *v1alpha1.Tenant {
+		return &v1alpha1.Tenant{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{constants.ServiceLabelKey: service}},
+			Spec:       v1alpha1.TenantSpec{Tenant: name},
+		}
+	}
+	deleted := tenant("deleted", service)
+	deleted.DeletionTimestamp = &metav1.Time{Time: time.Now()}
+	deleted.Finalizers = []string{"finalizers.monitoring.whizard.io/tenant"}
+
+	scheme := runtime.NewScheme()
+	if err := v1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	c := &fakeTenantCache{
+		FakeInformers: &informertest.FakeInformers{Scheme: scheme},
+		client:        fake.NewClientBuilder().WithScheme(scheme).WithObjects(tenant("a", service), tenant("other", "default.other"), deleted).Build(),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	updates := make(chan AdmissionControlConfig)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- newTenantInformer(nil, prometheus.NewRegistry(), c, service).Run(ctx, updates)
+	}()
+
+	next := func() []string {
+		t.Helper()
+		select {
+		case u := <-updates:
+			slices.Sort(u.Tenants)
+			return u.Tenants
+		case <-time.After(5 * time.Second):
+			t.Fatal("no update of the admitted tenants")
+			return nil
+		}
+	}
+
+	// Tenants of other Services and Tenants being deleted are not admitted.
+	if diff := cmp.Diff([]string{"a"}, next()); diff != "" {
+		t.Fatal(diff)
+	}
+
+	// New Tenants are admitted once they are seen by the informer.
+	created := tenant("b", service)
+	if err := c.client.Create(ctx, created); err != nil {
+		t.Fatal(err)
+	}
+	informer, err := c.FakeInformerFor(ctx, &v1alpha1.Tenant{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	informer.Add(created)
+	if diff := cmp.Diff([]string{"a", "b"}, next()); diff != "" {
+		t.Fatal(diff)
+	}
+
+	cancel()
+	if err := <-errc; err != context.Canceled {
+		t.Fatalf("expected the informer stopped by the context, got %v", err)
+	}
+	if _, ok := <-updates; ok {
+		t.Fatal("expected the updates closed")
+	}
+}
@@ -0,0 +1,304 @@
+package monitoringgateway
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+)
+
+const (
+	epTSDBStatus = "/status/tsdb"
+
+	limitParam              = "limit"
+	defaultTSDBStatusLimit  = 10
+	tenantMatcherTypeGlob   = "glob"
+	receiveTSDBStatusSuffix = "/api/v1/status/tsdb"
+)
+
+// HashringConfig is a hashring of ingesters, as in the hashrings configuration of the router.
+type HashringConfig struct {
+	Hashring string `json:"hashring,omitempty"`
+	// Tenants are the tenants of the hashring, a hashring without tenants is the hashring of all other tenants.
+	Tenants           []string           `json:"tenants,omitempty"`
+	TenantMatcherType string             `json:"tenant_matcher_type,omitempty"`
+	Endpoints         []HashringEndpoint `json:"endpoints"`
+}
+
+// HashringEndpoint is the gRPC address of an ingester of a hashring.
+type HashringEndpoint struct {
+	Address string `json:"address"`
+}
+
+// UnmarshalJSON parses an endpoint given either by its address or as an object.
+func (e *HashringEndpoint) UnmarshalJSON(b []byte) error {
+	if err := json.Unmarshal(b, &e.Address); err == nil {
+		return nil
+	}
+	type plain HashringEndpoint
+	return json.Unmarshal(b, (*plain)(e))
+}
+
+// ParseHashringsConfig parses the hashrings configuration of the router, which the operator renders to the hashrings
+// ConfigMap of the router.
+func ParseHashringsConfig(content []byte) ([]HashringConfig, error) {
+	var hashrings []HashringConfig
+	if err := json.Unmarshal(content, &hashrings); err != nil {
+		return nil, errors.Wrap(err, "parsing hashrings config JSON")
+	}
+	return hashrings, nil
+}
+
+// NewHashringsConfigWatcher creates a new ConfigWatcher for the hashrings configuration of the router.
+func NewHashringsConfigWatcher(logger log.Logger, reg prometheus.Registerer, path string, interval model.Duration) (*ConfigWatcher[[]HashringConfig], error) {
+	return newConfigWatcher(logger, reg, "whizard_gateway_hashrings_config", path, interval, ParseHashringsConfig)
+}
+
+// SetHashrings replaces the hashrings the ingesters of tenants are looked up in.
+func (h *Handler) SetHashrings(c []HashringConfig) error {
+	level.Info(h.logger).Log("msg", "updating hashrings", "hashrings", len(c))
+	h.hashrings.Store(&c)
+	return nil
+}
+
+// ingesterAddresses returns the HTTP addresses of the ingesters of the first hashring of the tenant, like the router
+// picks the hashring of a tenant.
+func (h *Handler) ingesterAddresses(hashrings []HashringConfig, tenant string) []string {
+	for _, hr := range hashrings {
+		if len(hr.Tenants) > 0 && !slices.ContainsFunc(hr.Tenants, func(t string) bool {
+			if hr.TenantMatcherType == tenantMatcherTypeGlob {
+				ok, _ := path.Match(t, tenant)
+				return ok
+			}
+			return t == tenant
+		}) {
+			continue
+		}
+
+		var addresses []string
+		for _, ep := range hr.Endpoints {
+			host, _, err := net.SplitHostPort(ep.Address)
+			if err != nil {
+				host = ep.Address
+			}
+			addresses = append(addresses, net.JoinHostPort(host, strconv.Itoa(h.ingesterHTTPPort)))
+		}
+		return addresses
+	}
+	return nil
+}
+
+// tsdbStat is a statistic of the TSDB status, as in the Prometheus HTTP API.
+type tsdbStat struct {
+	Name  string `json:"name"`
+	Value uint64 `json:"value"`
+}
+
+type headStats struct {
+	NumSeries     uint64 `json:"numSeries"`
+	NumLabelPairs int    `json:"numLabelPairs"`
+	ChunkCount    int64  `json:"chunkCount"`
+	MinTime       int64  `json:"minTime"`
+	MaxTime       int64  `json:"maxTime"`
+}
+
+// tsdbStatus is the TSDB status of the head of a tenant, as in the Prometheus HTTP API.
+type tsdbStatus struct {
+	HeadStats                   headStats  `json:"headStats"`
+	SeriesCountByMetricName     []tsdbStat `json:"seriesCountByMetricName"`
+	LabelValueCountByLabelName  []tsdbStat `json:"labelValueCountByLabelName"`
+	MemoryInBytesByLabelName    []tsdbStat `json:"memoryInBytesByLabelName"`
+	SeriesCountByLabelValuePair []tsdbStat `json:"seriesCountByLabelValuePair"`
+}
+
+// mergeTSDBStatuses merges the TSDB statuses of a tenant on several ingesters. Series, chunks, memory and the series of
+// the top metric names and label value pairs are summed up, so replicated series are counted once per replica. The
+// label pairs and the label values by label name are the maximum of the ingesters, as ingesters share most of them.
+func mergeTSDBStatuses(statuses []*tsdbStatus, limit int) *tsdbStatus {
+	var (
+		merged            = &tsdbStatus{}
+		byMetricName      = make(map[string]uint64)
+		valuesByLabelName = make(map[string]uint64)
+		memoryByLabelName = make(map[string]uint64)
+		byLabelValuePair  = make(map[string]uint64)
+	)
+	for i, s := range statuses {
+		if i == 0 {
+			merged.HeadStats.MinTime, merged.HeadStats.MaxTime = s.HeadStats.MinTime, s.HeadStats.MaxTime
+		}
+		merged.HeadStats.NumSeries += s.HeadStats.NumSeries
+		merged.HeadStats.ChunkCount += s.HeadStats.ChunkCount
+		merged.HeadStats.NumLabelPairs = max(merged.HeadStats.NumLabelPairs, s.HeadStats.NumLabelPairs)
+		merged.HeadStats.MinTime = min(merged.HeadStats.MinTime, s.HeadStats.MinTime)
+		merged.HeadStats.MaxTime = max(merged.HeadStats.MaxTime, s.HeadStats.MaxTime)
+
+		for _, st := range s.SeriesCountByMetricName {
+			byMetricName[st.Name] += st.Value
+		}
+		for _, st := range s.LabelValueCountByLabelName {
+			valuesByLabelName[st.Name] = max(valuesByLabelName[st.Name], st.Value)
+		}
+		for _, st := range s.MemoryInBytesByLabelName {
+			memoryByLabelName[st.Name] += st.Value
+		}
+		for _, st := range s.SeriesCountByLabelValuePair {
+			byLabelValuePair[st.Name] += st.Value
+		}
+	}
+
+	merged.SeriesCountByMetricName = topTSDBStats(byMetricName, limit)
+	merged.LabelValueCountByLabelName = topTSDBStats(valuesByLabelName, limit)
+	merged.MemoryInBytesByLabelName = topTSDBStats(memoryByLabelName, limit)
+	merged.SeriesCountByLabelValuePair = topTSDBStats(byLabelValuePair, limit)
+	return merged
+}
+
+// topTSDBStats returns the statistics with the highest values, ordered by value.
+func topTSDBStats(stats map[string]uint64, limit int) []tsdbStat {
+	result := make([]tsdbStat, 0, len(stats))
+	for name, value := range stats {
+		result = append(result, tsdbStat{Name: name, Value: value})
+	}
+	slices.SortFunc(result, func(a, b tsdbStat) int {
+		if a.Value != b.Value {
+			if a.Value > b.Value {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(a.Name, b.Name)
+	})
+	if len(result) > limit {
+		result = result[:limit]
+	}
+	return result
+}
+
+// addTenantStatusHandler adds the handler of the TSDB status of a tenant.
+func (h *Handler) addTenantStatusHandler() {
+	h.router.Path(apiTenantPrefix + epTSDBStatus).Methods(http.MethodGet).HandlerFunc(h.wrap(withSingleTenant(h.tsdbStatus)))
+}
+
+// tsdbStatus serves the TSDB status of a tenant, merged from the TSDB statuses of the tenant on the ingesters of its
+// hashring. Ingesters that fail to respond are reported in the warnings of the response.
+func (h *Handler) tsdbStatus(w http.ResponseWriter, req *http.Request) {
+	hashrings := h.hashrings.Load()
+	if hashrings == nil {
+		http.Error(w, "The ingester hashrings are not configured for the server", http.StatusNotAcceptable)
+		return
+	}
+
+	requestInfo, _ := requestInfoFrom(req.Context())
+	// The statistics cover all series of the tenant, so they are not served to callers restricted by access policies.
+	policyMatchers, err := h.accessPolicies.Load().policyMatchers(requestInfo.Identity, requestInfo.Tenants)
+	if err == nil && len(policyMatchers) > 0 {
+		err = fmt.Errorf("the TSDB status of tenant %s is not available under an access policy", requestInfo.TenantId)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	limit := defaultTSDBStatusLimit
+	if s := req.URL.Query().Get(limitParam); s != "" {
+		if limit, err = strconv.Atoi(s); err != nil || limit <= 0 {
+			writeAPIError(w, http.StatusBadRequest, errorBadData, fmt.Errorf("the limit must be a positive integer, got %q", s))
+			return
+		}
+	}
+
+	addresses := h.ingesterAddresses(*hashrings, requestInfo.TenantId)
+	if len(addresses) == 0 {
+		writeAPIError(w, http.StatusNotFound, errorBadData, fmt.Errorf("no ingesters found for tenant %s", requestInfo.TenantId))
+		return
+	}
+
+	var (
+		wg       sync.WaitGroup
+		statuses = make([]*tsdbStatus, len(addresses))
+		errs     = make([]error, len(addresses))
+	)
+	for i, address := range addresses {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			statuses[i], errs[i] = h.fetchTSDBStatus(req.Context(), address, requestInfo.TenantId, limit)
+		}()
+	}
+	wg.Wait()
+
+	var (
+		found    []*tsdbStatus
+		warnings = []string{}
+	)
+	for i, err := range errs {
+		if err != nil {
+			level.Warn(h.logger).Log("msg", "failed to get TSDB status", "tenant", requestInfo.TenantId, "ingester", addresses[i], "err", err)
+			warnings = append(warnings, fmt.Sprintf("ingester %s: %s", addresses[i], err))
+			continue
+		}
+		if statuses[i] != nil {
+			found = append(found, statuses[i])
+		}
+	}
+	if len(warnings) == len(addresses) {
+		writeAPIError(w, http.StatusBadGateway, errorUnavailable, errors.New(strings.Join(warnings, "; ")))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":   "success",
+		"data":     mergeTSDBStatuses(found, limit),
+		"warnings": warnings,
+	})
+}
+
+// fetchTSDBStatus gets the TSDB status of the tenant from an ingester, or nil if the ingester has no TSDB of the tenant.
+func (h *Handler) fetchTSDBStatus(ctx context.Context, address, tenant string, limit int) (*tsdbStatus, error) {
+	u := url.URL{Scheme: "http", Host: address, Path: receiveTSDBStatusSuffix, RawQuery: url.Values{limitParam: {strconv.Itoa(limit)}}.Encode()}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(h.upstreams.Load().tenantHeader, tenant)
+
+	resp, err := h.ingesterClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	// Thanos receive responds with the TSDB statuses by tenant.
+	var res struct {
+		Data []struct {
+			Tenant string `json:"tenant"`
+			tsdbStatus
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return nil, errors.Wrap(err, "decoding TSDB status")
+	}
+	for _, s := range res.Data {
+		if s.Tenant == tenant {
+			return &s.tsdbStatus, nil
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,104 @@
+package monitoringgateway
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestTSDBStatus(t *testing.T) {
+	hashrings, err := ParseHashringsConfig([]byte(`[
+		{"hashring": "default/a", "tenants": ["a"], "endpoints": [{"address": "ingester-0:10901"}, "ingester-1:10901"]},
+		{"hashring": "softs", "endpoints": ["ingester-2:10901"]}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	responses := map[string]string{
+		"ingester-0:10902": `{"status":"success","data":[{"tenant":"a","headStats":{"numSeries":30,"numLabelPairs":5,"chunkCount":60,"minTime":100,"maxTime":200},
+			"seriesCountByMetricName":[{"name":"up","value":20},{"name":"requests","value":10}],
+			"labelValueCountByLabelName":[{"name":"pod","value":3}],
+			"memoryInBytesByLabelName":[{"name":"pod","value":100}],
+			"seriesCountByLabelValuePair":[{"name":"job=a","value":30}]}]}`,
+		"ingester-1:10902": `{"status":"success","data":[{"tenant":"a","headStats":{"numSeries":25,"numLabelPairs":4,"chunkCount":50,"minTime":50,"maxTime":150},
+			"seriesCountByMetricName":[{"name":"requests","value":15},{"name":"errors","value":10}],
+			"labelValueCountByLabelName":[{"name":"pod","value":4}],
+			"memoryInBytesByLabelName":[{"name":"pod","value":150}],
+			"seriesCountByLabelValuePair":[{"name":"job=a","value":25}]}]}`,
+	}
+	var (
+		mtx        sync.Mutex
+		gotTenants []string
+	)
+	h := NewHandler(nil, prometheus.NewRegistry(), &Options{TenantHeader: "THANOS-TENANT", TenantLabelName: "tenant_id"})
+	h.ingesterClient = &http.Client{Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		mtx.Lock()
+		gotTenants = append(gotTenants, req.Header.Get("THANOS-TENANT"))
+		mtx.Unlock()
+		rec := httptest.NewRecorder()
+		if body, ok := responses[req.URL.Host]; ok && req.URL.Path == "/api/v1/status/tsdb" && req.URL.Query().Get("limit") == "2" {
+			_, _ = rec.WriteString(body)
+		} else {
+			rec.WriteHeader(http.StatusNotFound)
+		}
+		return rec.Result(), nil
+	})}
+
+	rec := httptest.NewRecorder()
+	h.Router().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/a/api/v1/status/tsdb?limit=2", nil))
+	if rec.Code != http.StatusNotAcceptable {
+		t.Fatalf("expected status %d without hashrings, got %d", http.StatusNotAcceptable, rec.Code)
+	}
+
+	if err := h.SetHashrings(hashrings); err != nil {
+		t.Fatal(err)
+	}
+	rec = httptest.NewRecorder()
+	h.Router().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/a/api/v1/status/tsdb?limit=2", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body)
+	}
+
+	var res struct {
+		Data     tsdbStatus `json:"data"`
+		Warnings []string   `json:"warnings"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	want := tsdbStatus{
+		HeadStats:                   headStats{NumSeries: 55, NumLabelPairs: 5, ChunkCount: 110, MinTime: 50, MaxTime: 200},
+		SeriesCountByMetricName:     []tsdbStat{{Name: "requests", Value: 25}, {Name: "up", Value: 20}},
+		LabelValueCountByLabelName:  []tsdbStat{{Name: "pod", Value: 4}},
+		MemoryInBytesByLabelName:    []tsdbStat{{Name: "pod", Value: 250}},
+		SeriesCountByLabelValuePair: []tsdbStat{{Name: "job=a", Value: 55}},
+	}
+	if diff := cmp.Diff(want, res.Data); diff != "" {
+		t.Fatal(diff)
+	}
+	if len(res.Warnings) != 0 {
+		t.Fatalf("unexpected warnings %v", res.Warnings)
+	}
+	if diff := cmp.Diff([]string{"a", "a"}, gotTenants); diff != "" {
+		t.Fatal(diff)
+	}
+
+	// Tenants of no hashring are on the ingesters of the hashring without tenants, which fail here.
+	rec = httptest.NewRecorder()
+	h.Router().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/b/api/v1/status/tsdb", nil))
+	if rec.Code != http.StatusBadGateway {
+		t.Fatalf("expected status %d, got %d", http.StatusBadGateway, rec.Code)
+	}
+}
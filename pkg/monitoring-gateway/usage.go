@@ -0,0 +1,440 @@
+package monitoringgateway
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"math/bits"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/prompb"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+	"go.opentelemetry.io/collector/pdata/pmetric/pmetricotlp"
+)
+
+const (
+	// DefaultUsageRetention is the default time window the usage of tenants is kept for.
+	DefaultUsageRetention = 24 * time.Hour
+
+	// usageBucket is the resolution of the usage windows.
+	usageBucket = time.Minute
+	// usageSketchPrecision is the number of bits of the series hashes that select the register of the series sketch.
+	usageSketchPrecision = 6
+	usageSketchRegisters = 1 << usageSketchPrecision
+
+	windowParam = "window"
+
+	protocolRemoteWrite = "remote_write"
+	protocolOTLP        = "otlp"
+)
+
+// ingestUsage is the usage of a write request.
+type ingestUsage struct {
+	samples    int
+	histograms int
+	exemplars  int
+	// series are the hashes of the series written to.
+	series []uint64
+}
+
+// remoteWriteUsage returns the usage of a remote write request.
+func remoteWriteUsage(wreq *prompb.WriteRequest) ingestUsage {
+	var (
+		b labels.ScratchBuilder
+		u = ingestUsage{series: make([]uint64, 0, len(wreq.Timeseries))}
+	)
+	u.samples, u.histograms, u.exemplars = countWritten(wreq)
+	for _, ts := range wreq.Timeseries {
+		u.series = append(u.series, ts.ToLabels(&b, nil).Hash())
+	}
+	return u
+}
+
+// otlpUsage returns the usage of an OTLP metrics export request. Every data point of a histogram,
+// exponential histogram or summary counts as one histogram, every other data point as one sample.
+// A series is identified by the resource attributes, the metric name and the data point attributes.
+func otlpUsage(req pmetricotlp.ExportRequest) ingestUsage {
+	var (
+		u ingestUsage
+		b labels.ScratchBuilder
+	)
+	rms := req.Metrics().ResourceMetrics()
+	for i := 0; i < rms.Len(); i++ {
+		rm := rms.At(i)
+		sms := rm.ScopeMetrics()
+		for j := 0; j < sms.Len(); j++ {
+			ms := sms.At(j).Metrics()
+			for k := 0; k < ms.Len(); k++ {
+				m := ms.At(k)
+				series := func(attrs pcommon.Map, exemplars pmetric.ExemplarSlice) {
+					b.Reset()
+					b.Add(labels.MetricName, m.Name())
+					addAttributes(&b, "resource.", rm.Resource().Attributes())
+					addAttributes(&b, "", attrs)
+					b.Sort()
+					u.series = append(u.series, b.Labels().Hash())
+					u.exemplars += exemplars.Len()
+				}
+
+				switch m.Type() {
+				case pmetric.MetricTypeGauge:
+					dps := m.Gauge().DataPoints()
+					for d := 0; d < dps.Len(); d++ {
+						series(dps.At(d).Attributes(), dps.At(d).Exemplars())
+					}
+					u.samples += dps.Len()
+				case pmetric.MetricTypeSum:
+					dps := m.Sum().DataPoints()
+					for d := 0; d < dps.Len(); d++ {
+						series(dps.At(d).Attributes(), dps.At(d).Exemplars())
+					}
+					u.samples += dps.Len()
+				case pmetric.MetricTypeHistogram:
+					dps := m.Histogram().DataPoints()
+					for d := 0; d < dps.Len(); d++ {
+						series(dps.At(d).Attributes(), dps.At(d).Exemplars())
+					}
+					u.histograms += dps.Len()
+				case pmetric.MetricTypeExponentialHistogram:
+					dps := m.ExponentialHistogram().DataPoints()
+					for d := 0; d < dps.Len(); d++ {
+						series(dps.At(d).Attributes(), dps.At(d).Exemplars())
+					}
+					u.histograms += dps.Len()
+				case pmetric.MetricTypeSummary:
+					dps := m.Summary().DataPoints()
+					for d := 0; d < dps.Len(); d++ {
+						series(dps.At(d).Attributes(), pmetric.NewExemplarSlice())
+					}
+					u.histograms += dps.Len()
+				}
+			}
+		}
+	}
+	return u
+}
+
+func addAttributes(b *labels.ScratchBuilder, prefix string, attrs pcommon.Map) {
+	attrs.Range(func(k string, v pcommon.Value) bool {
+		b.Add(prefix+k, v.AsString())
+		return true
+	})
+}
+
+// usageCounts are the counts of a tenant within a usage bucket.
+type usageCounts struct {
+	// bucket is the start of the bucket in units of usageBucket since the epoch.
+	bucket     int64
+	samples    int64
+	histograms int64
+	exemplars  int64
+}
+
+// tenantUsage is the usage of a tenant within the retention.
+type tenantUsage struct {
+	mtx sync.Mutex
+	// buckets is a ring of the counts of the last buckets.
+	buckets []usageCounts
+	// series maps the hash of a series to the last bucket it was written to.
+	series map[uint64]int64
+	// activeSeries and sketch are the number of series within the retention and their HyperLogLog registers, as of
+	// the last pruning.
+	activeSeries int
+	sketch       [usageSketchRegisters]uint8
+	// removed is set once the usage is removed from the tracker, after which it is not recorded to anymore.
+	removed bool
+}
+
+// usageTracker accounts the ingestion of tenants. The counts are exported as metrics,
+// and kept for the retention to serve the rolling totals of the usage API.
+//
+// The counters sum up exactly across gateway replicas. The distinct series are counted per replica, so their sum
+// across replicas overcounts the series written through several replicas. They are exported as a HyperLogLog sketch
+// as well, whose registers are merged across replicas by their maximum, so that the distinct series of a tenant
+// across replicas are estimated by
+//
+//	0.709 * 64^2 / sum by (tenant) (2 ^ -max by (tenant, register) (whizard_gateway_ingested_series_sketch))
+//
+// with a standard error of about 13%. Below about 160 series, 64 * ln(64 / V) estimates them better, where V is the
+// number of registers that are zero.
+type usageTracker struct {
+	retention time.Duration
+	now       func() time.Time
+
+	// mtx guards the tenants, the usage of a tenant is guarded by its own mutex. The mutex of a tenant is never
+	// acquired while holding mtx.
+	mtx     sync.RWMutex
+	tenants map[string]*tenantUsage
+	// lastPruned is the bucket the series were last pruned in.
+	lastPruned atomic.Int64
+
+	samples    *prometheus.CounterVec
+	histograms *prometheus.CounterVec
+	exemplars  *prometheus.CounterVec
+	seriesDesc *prometheus.Desc
+	sketchDesc *prometheus.Desc
+}
+
+func newUsageTracker(reg prometheus.Registerer, retention time.Duration) *usageTracker {
+	if retention < usageBucket {
+		retention = DefaultUsageRetention
+	}
+
+	t := &usageTracker{
+		retention: retention,
+		now:       time.Now,
+		tenants:   make(map[string]*tenantUsage),
+
+		samples: promauto.With(reg).NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "whizard_gateway_ingested_samples_total",
+				Help: "Total number of ingested samples, labeled by tenant and protocol.",
+			},
+			[]string{"tenant", "protocol"},
+		),
+		histograms: promauto.With(reg).NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "whizard_gateway_ingested_histograms_total",
+				Help: "Total number of ingested histograms, labeled by tenant and protocol.",
+			},
+			[]string{"tenant", "protocol"},
+		),
+		exemplars: promauto.With(reg).NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "whizard_gateway_ingested_exemplars_total",
+				Help: "Total number of ingested exemplars, labeled by tenant and protocol.",
+			},
+			[]string{"tenant", "protocol"},
+		),
+		seriesDesc: prometheus.NewDesc(
+			"whizard_gateway_ingested_series",
+			"Number of distinct series ingested by this gateway within the usage retention, labeled by tenant. It does not sum up across gateway replicas.",
+			[]string{"tenant"}, nil,
+		),
+		sketchDesc: prometheus.NewDesc(
+			"whizard_gateway_ingested_series_sketch",
+			"HyperLogLog registers of the series ingested by this gateway within the usage retention, labeled by tenant and register. The maximum of a register across gateway replicas is the register of the series of all replicas.",
+			[]string{"tenant", "register"}, nil,
+		),
+	}
+	t.lastPruned.Store(t.now().UnixNano() / int64(usageBucket))
+	if reg != nil {
+		reg.MustRegister(t)
+	}
+	return t
+}
+
+// record accounts the usage of a write request of the tenant. The series of the usage are hashed by the caller, and
+// the series that were not written to within the retention are pruned in the background, so that concurrent requests
+// of the tenant wait less.
+func (t *usageTracker) record(tenant, protocol string, u ingestUsage) {
+	t.samples.WithLabelValues(tenant, protocol).Add(float64(u.samples))
+	t.histograms.WithLabelValues(tenant, protocol).Add(float64(u.histograms))
+	t.exemplars.WithLabelValues(tenant, protocol).Add(float64(u.exemplars))
+
+	bucket := t.now().UnixNano() / int64(usageBucket)
+	if last := t.lastPruned.Load(); last != bucket && t.lastPruned.CompareAndSwap(last, bucket) {
+		go t.prune(bucket)
+	}
+
+	for {
+		tu := t.tenantUsage(tenant)
+		tu.mtx.Lock()
+		if tu.removed {
+			// The usage was removed by the pruning while this request waited for it.
+			tu.mtx.Unlock()
+			continue
+		}
+
+		c := &tu.buckets[bucket%int64(len(tu.buckets))]
+		if c.bucket != bucket {
+			*c = usageCounts{bucket: bucket}
+		}
+		c.samples += int64(u.samples)
+		c.histograms += int64(u.histograms)
+		c.exemplars += int64(u.exemplars)
+
+		for _, s := range u.series {
+			tu.series[s] = bucket
+		}
+		tu.mtx.Unlock()
+		return
+	}
+}
+
+// tenantUsage returns the usage of the tenant, creating it if needed.
+func (t *usageTracker) tenantUsage(tenant string) *tenantUsage {
+	t.mtx.RLock()
+	tu, ok := t.tenants[tenant]
+	t.mtx.RUnlock()
+	if ok {
+		return tu
+	}
+
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	if tu, ok = t.tenants[tenant]; !ok {
+		tu = &tenantUsage{
+			buckets: make([]usageCounts, t.retention/usageBucket),
+			series:  make(map[uint64]int64),
+		}
+		t.tenants[tenant] = tu
+	}
+	return tu
+}
+
+// snapshot returns the usage of the tenants, so that it is read without holding the mutex of the tracker.
+func (t *usageTracker) snapshot() map[string]*tenantUsage {
+	t.mtx.RLock()
+	defer t.mtx.RUnlock()
+	return maps.Clone(t.tenants)
+}
+
+// prune forgets the series that were not written to within the retention, updates the series count and the sketch of
+// the tenants, and removes the tenants without usage within the retention.
+func (t *usageTracker) prune(bucket int64) {
+	oldest := bucket - int64(t.retention/usageBucket) + 1
+	for tenant, tu := range t.snapshot() {
+		tu.mtx.Lock()
+		var sketch [usageSketchRegisters]uint8
+		for s, last := range tu.series {
+			if last < oldest {
+				delete(tu.series, s)
+				continue
+			}
+			i, rank := sketchRegister(s)
+			sketch[i] = max(sketch[i], rank)
+		}
+		tu.activeSeries, tu.sketch = len(tu.series), sketch
+
+		if len(tu.series) == 0 && !slices.ContainsFunc(tu.buckets, func(c usageCounts) bool { return c.bucket >= oldest }) {
+			t.mtx.Lock()
+			delete(t.tenants, tenant)
+			t.mtx.Unlock()
+			tu.removed = true
+		}
+		tu.mtx.Unlock()
+	}
+}
+
+// sketchRegister returns the HyperLogLog register of the series hash and its rank in the register, that is the
+// position of the first set bit of the bits of the hash following the register.
+func sketchRegister(hash uint64) (int, uint8) {
+	w := hash << usageSketchPrecision
+	return int(hash >> (64 - usageSketchPrecision)), uint8(min(bits.LeadingZeros64(w), 64-usageSketchPrecision) + 1)
+}
+
+// TenantUsage is the usage of a tenant within a time window.
+type TenantUsage struct {
+	Tenant     string `json:"tenant"`
+	Window     string `json:"window"`
+	Samples    int64  `json:"samples"`
+	Histograms int64  `json:"histograms"`
+	Exemplars  int64  `json:"exemplars"`
+	Series     int    `json:"series"`
+}
+
+// usage returns the usage of the tenants within the window, of all tenants if none are given.
+func (t *usageTracker) usage(tenants []string, window time.Duration) []TenantUsage {
+	var (
+		bucket = t.now().UnixNano() / int64(usageBucket)
+		oldest = bucket - int64((window+usageBucket-1)/usageBucket) + 1
+		result []TenantUsage
+	)
+	for tenant, tu := range t.snapshot() {
+		if len(tenants) > 0 && !slices.Contains(tenants, tenant) {
+			continue
+		}
+		u := TenantUsage{Tenant: tenant, Window: model.Duration(window).String()}
+
+		tu.mtx.Lock()
+		for _, c := range tu.buckets {
+			if c.bucket >= oldest && c.bucket <= bucket {
+				u.Samples += c.samples
+				u.Histograms += c.histograms
+				u.Exemplars += c.exemplars
+			}
+		}
+		for _, last := range tu.series {
+			if last >= oldest {
+				u.Series++
+			}
+		}
+		tu.mtx.Unlock()
+		result = append(result, u)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Tenant < result[j].Tenant })
+	return result
+}
+
+func (t *usageTracker) Describe(ch chan<- *prometheus.Desc) {
+	ch <- t.seriesDesc
+	ch <- t.sketchDesc
+}
+
+// Collect exports the series count and the sketch of the tenants as of the last pruning, at most a usage bucket ago.
+func (t *usageTracker) Collect(ch chan<- prometheus.Metric) {
+	for tenant, tu := range t.snapshot() {
+		tu.mtx.Lock()
+		n, sketch := tu.activeSeries, tu.sketch
+		tu.mtx.Unlock()
+
+		ch <- prometheus.MustNewConstMetric(t.seriesDesc, prometheus.GaugeValue, float64(n), tenant)
+		for i, rank := range sketch {
+			ch <- prometheus.MustNewConstMetric(t.sketchDesc, prometheus.GaugeValue, float64(rank), tenant, strconv.Itoa(i))
+		}
+	}
+}
+
+// usage serves the rolling usage totals of the tenants of the request for the requested windows,
+// e.g. /{tenant_id}/api/v1/usage?window=1h&window=24h.
+func (h *Handler) usage(w http.ResponseWriter, req *http.Request) {
+	if err := req.ParseForm(); err != nil {
+		writeAPIError(w, http.StatusBadRequest, errorBadData, err)
+		return
+	}
+
+	requestInfo, found := requestInfoFrom(req.Context())
+	if !found || len(requestInfo.Tenants) == 0 {
+		http.Error(w, "the usage is only served to tenants", http.StatusForbidden)
+		return
+	}
+	tenants := requestInfo.Tenants
+
+	windows := req.Form[windowParam]
+	if len(windows) == 0 {
+		windows = []string{"1h"}
+	}
+
+	data := []TenantUsage{}
+	for _, s := range windows {
+		window, err := parseDuration(s)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, errorBadData, err)
+			return
+		}
+		if window < usageBucket || window > h.usageTracker.retention {
+			err := fmt.Errorf("the window must be between %s and %s, got %s", model.Duration(usageBucket), model.Duration(h.usageTracker.retention), s)
+			writeAPIError(w, http.StatusBadRequest, errorBadData, err)
+			return
+		}
+		data = append(data, h.usageTracker.usage(tenants, window)...)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": "success",
+		"data":   data,
+	})
+}
@@ -0,0 +1,128 @@
+package monitoringgateway
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/prompb"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+	"go.opentelemetry.io/collector/pdata/pmetric/pmetricotlp"
+)
+
+func TestUsageTracker(t *testing.T) {
+	now := time.Unix(3600, 0)
+	tracker := newUsageTracker(prometheus.NewRegistry(), time.Hour)
+	tracker.now = func() time.Time { return now }
+
+	wreq := &prompb.WriteRequest{Timeseries: []prompb.TimeSeries{
+		{Labels: []prompb.Label{{Name: "__name__", Value: "up"}}, Samples: []prompb.Sample{{Value: 1}, {Value: 1}}},
+		{Labels: []prompb.Label{{Name: "__name__", Value: "down"}}, Histograms: []prompb.Histogram{{}}, Exemplars: []prompb.Exemplar{{}}},
+	}}
+	tracker.record("a", protocolRemoteWrite, remoteWriteUsage(wreq))
+
+	now = now.Add(30 * time.Minute)
+	tracker.record("a", protocolRemoteWrite, remoteWriteUsage(&prompb.WriteRequest{Timeseries: wreq.Timeseries[:1]}))
+	tracker.record("b", protocolRemoteWrite, remoteWriteUsage(&prompb.WriteRequest{Timeseries: wreq.Timeseries[:1]}))
+
+	want := []TenantUsage{
+		{Tenant: "a", Window: "5m", Samples: 2, Series: 1},
+		{Tenant: "a", Window: "1h", Samples: 4, Histograms: 1, Exemplars: 1, Series: 2},
+	}
+	got := append(tracker.usage([]string{"a"}, 5*time.Minute), tracker.usage([]string{"a"}, time.Hour)...)
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatal(diff)
+	}
+	if n := len(tracker.usage(nil, time.Hour)); n != 2 {
+		t.Fatalf("expected the usage of 2 tenants, got %d", n)
+	}
+	if got := testutil.ToFloat64(tracker.samples.WithLabelValues("a", protocolRemoteWrite)); got != 4 {
+		t.Fatalf("expected 4 samples, got %v", got)
+	}
+}
+
+func TestUsageSketch(t *testing.T) {
+	// Two replicas are written 6000 distinct series, 2000 of them through both.
+	replicas := []*usageTracker{newUsageTracker(nil, time.Hour), newUsageTracker(nil, time.Hour)}
+	for i := 0; i < 6000; i++ {
+		hash := labels.FromStrings("__name__", "up", "i", strconv.Itoa(i)).Hash()
+		if i < 4000 {
+			replicas[0].record("a", protocolRemoteWrite, ingestUsage{series: []uint64{hash}})
+		}
+		if i >= 2000 {
+			replicas[1].record("a", protocolRemoteWrite, ingestUsage{series: []uint64{hash}})
+		}
+	}
+
+	var merged [usageSketchRegisters]uint8
+	for _, r := range replicas {
+		r.prune(r.now().UnixNano() / int64(usageBucket))
+		tu := r.snapshot()["a"]
+		tu.mtx.Lock()
+		for i, rank := range tu.sketch {
+			merged[i] = max(merged[i], rank)
+		}
+		tu.mtx.Unlock()
+	}
+	var sum float64
+	for _, rank := range merged {
+		sum += math.Pow(2, -float64(rank))
+	}
+	if estimate := 0.709 * usageSketchRegisters * usageSketchRegisters / sum; estimate < 4500 || estimate > 7500 {
+		t.Fatalf("expected about 6000 series estimated, got %.0f", estimate)
+	}
+}
+
+func TestOTLPUsage(t *testing.T) {
+	md := pmetric.NewMetrics()
+	ms := md.ResourceMetrics().AppendEmpty().ScopeMetrics().AppendEmpty().Metrics()
+
+	g := ms.AppendEmpty()
+	g.SetName("temperature")
+	dps := g.SetEmptyGauge().DataPoints()
+	dps.AppendEmpty().Attributes().PutStr("room", "a")
+	dps.AppendEmpty().Attributes().PutStr("room", "b")
+
+	h := ms.AppendEmpty()
+	h.SetName("latency")
+	h.SetEmptyHistogram().DataPoints().AppendEmpty().Exemplars().AppendEmpty()
+
+	u := otlpUsage(pmetricotlp.NewExportRequestFromMetrics(md))
+	if u.samples != 2 || u.histograms != 1 || u.exemplars != 1 || len(u.series) != 3 {
+		t.Fatalf("unexpected usage %+v", u)
+	}
+}
+
+func TestUsageAPI(t *testing.T) {
+	h := NewHandler(nil, prometheus.NewRegistry(), &Options{})
+	h.usageTracker.record("a", protocolRemoteWrite, ingestUsage{samples: 1})
+	h.usageTracker.record("b", protocolRemoteWrite, ingestUsage{samples: 2})
+
+	rec := httptest.NewRecorder()
+	h.Router().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/a/api/v1/usage", nil))
+	var res struct {
+		Data []TenantUsage `json:"data"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Data) != 1 || res.Data[0].Tenant != "a" {
+		t.Fatalf("expected the usage of tenant a only, got %+v", res.Data)
+	}
+
+	// The usage of other tenants is not served without a tenant.
+	rec = httptest.NewRecorder()
+	h.Router().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/usage?tenant=b", nil))
+	if strings.Contains(rec.Body.String(), `"tenant":"b"`) {
+		t.Fatalf("unexpected usage of tenant b served globally: %s", rec.Body)
+	}
+}
@@ -0,0 +1,151 @@
+package monitoringgateway
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// The reasons of discarded samples of invalid series, like the reasons of discarded samples of Cortex.
+const (
+	reasonMissingMetricName      = "missing_metric_name"
+	reasonInvalidMetricName      = "invalid_metric_name"
+	reasonInvalidLabel           = "invalid_label"
+	reasonDuplicateLabelNames    = "duplicate_label_names"
+	reasonMaxLabelNamesPerSeries = "max_label_names_per_series"
+	reasonLabelNameTooLong       = "label_name_too_long"
+	reasonLabelValueTooLong      = "label_value_too_long"
+	reasonSampleTooOld           = "sample_too_old"
+	reasonSampleTooFarInFuture   = "sample_too_far_in_future"
+)
+
+// validationError is returned for a series that fails the validation of the ingestion.
+type validationError struct {
+	reason string
+	msg    string
+}
+
+func (e *validationError) Error() string {
+	return e.msg
+}
+
+// validatesSeries reports whether any of the validations of the series of remote write requests is enabled.
+func (l TenantLimits) validatesSeries() bool {
+	return l.ValidateSeriesFormat || l.MaxLabelNamesPerSeries > 0 || l.MaxLabelNameLength > 0 || l.MaxLabelValueLength > 0 ||
+		l.RejectOldSamplesMaxAge > 0 || l.CreationGracePeriod > 0
+}
+
+// validateLabels validates the labels of a series against the limits of the tenant.
+func validateLabels(limits TenantLimits, lbls []prompb.Label) *validationError {
+	if limits.ValidateSeriesFormat {
+		name := seriesName(lbls)
+		switch {
+		case name == "{}":
+			return &validationError{reason: reasonMissingMetricName, msg: "a series has no metric name"}
+		case !model.IsValidLegacyMetricName(name):
+			return &validationError{reason: reasonInvalidMetricName, msg: fmt.Sprintf("series %s has the invalid metric name %q", seriesName(lbls), name)}
+		}
+	}
+	if limits.MaxLabelNamesPerSeries > 0 && len(lbls) > limits.MaxLabelNamesPerSeries {
+		return &validationError{
+			reason: reasonMaxLabelNamesPerSeries,
+			msg:    fmt.Sprintf("series %s has %d labels, exceeding the limit of %d labels per series", seriesName(lbls), len(lbls), limits.MaxLabelNamesPerSeries),
+		}
+	}
+
+	for i, l := range lbls {
+		if limits.ValidateSeriesFormat {
+			if l.Name != labels.MetricName && !model.LabelName(l.Name).IsValidLegacy() {
+				return &validationError{reason: reasonInvalidLabel, msg: fmt.Sprintf("series %s has the invalid label name %q", seriesName(lbls), l.Name)}
+			}
+			// Series have few labels, which are not necessarily sorted.
+			for _, prev := range lbls[:i] {
+				if prev.Name == l.Name {
+					return &validationError{reason: reasonDuplicateLabelNames, msg: fmt.Sprintf("series %s has the label name %q more than once", seriesName(lbls), l.Name)}
+				}
+			}
+		}
+		if limits.MaxLabelNameLength > 0 && len(l.Name) > limits.MaxLabelNameLength {
+			return &validationError{
+				reason: reasonLabelNameTooLong,
+				msg:    fmt.Sprintf("series %s has the label name %q longer than the limit of %d characters", seriesName(lbls), l.Name, limits.MaxLabelNameLength),
+			}
+		}
+		if limits.MaxLabelValueLength > 0 && len(l.Value) > limits.MaxLabelValueLength {
+			return &validationError{
+				reason: reasonLabelValueTooLong,
+				msg:    fmt.Sprintf("series %s has a value of the label %q longer than the limit of %d characters", seriesName(lbls), l.Name, limits.MaxLabelValueLength),
+			}
+		}
+	}
+	return nil
+}
+
+// validateTimestamp validates the timestamp in milliseconds of a sample of a series against the limits of the tenant.
+func validateTimestamp(limits TenantLimits, now time.Time, lbls []prompb.Label, ts int64) *validationError {
+	t := time.UnixMilli(ts)
+	if limits.RejectOldSamplesMaxAge > 0 && now.Sub(t) > time.Duration(limits.RejectOldSamplesMaxAge) {
+		return &validationError{
+			reason: reasonSampleTooOld,
+			msg:    fmt.Sprintf("series %s has a sample at %s older than the limit of %s", seriesName(lbls), t.UTC().Format(time.RFC3339), limits.RejectOldSamplesMaxAge),
+		}
+	}
+	if limits.CreationGracePeriod > 0 && t.Sub(now) > time.Duration(limits.CreationGracePeriod) {
+		return &validationError{
+			reason: reasonSampleTooFarInFuture,
+			msg:    fmt.Sprintf("series %s has a sample at %s further in the future than the limit of %s", seriesName(lbls), t.UTC().Format(time.RFC3339), limits.CreationGracePeriod),
+		}
+	}
+	return nil
+}
+
+// validateWriteRequest validates the series of the write request against the limits of the tenant. If the limits drop
+// invalid series, invalid series and samples are removed from the request, and it returns the number of discarded
+// samples by reason and reports whether the request was changed. Otherwise, the first validation error is returned.
+func validateWriteRequest(wreq *prompb.WriteRequest, limits TenantLimits, now time.Time) (discarded map[string]int, changed bool, err *validationError) {
+	discarded = make(map[string]int)
+
+	kept := wreq.Timeseries[:0]
+	for _, ts := range wreq.Timeseries {
+		if err := validateLabels(limits, ts.Labels); err != nil {
+			if !limits.DropInvalidSeries {
+				return nil, false, err
+			}
+			discarded[err.reason] += len(ts.Samples) + len(ts.Histograms)
+			changed = true
+			continue
+		}
+
+		var tsErr *validationError
+		invalid := func(timestamp int64) bool {
+			err := validateTimestamp(limits, now, ts.Labels, timestamp)
+			if err == nil {
+				return false
+			}
+			discarded[err.reason]++
+			if tsErr == nil {
+				tsErr = err
+			}
+			return true
+		}
+		ts.Samples = slices.DeleteFunc(ts.Samples, func(s prompb.Sample) bool { return invalid(s.Timestamp) })
+		ts.Histograms = slices.DeleteFunc(ts.Histograms, func(h prompb.Histogram) bool { return invalid(h.Timestamp) })
+		if tsErr != nil {
+			if !limits.DropInvalidSeries {
+				return nil, false, tsErr
+			}
+			changed = true
+			// Series whose samples were all discarded are dropped.
+			if len(ts.Samples) == 0 && len(ts.Histograms) == 0 {
+				continue
+			}
+		}
+		kept = append(kept, ts)
+	}
+	wreq.Timeseries = kept
+	return discarded, changed, nil
+}
@@ -0,0 +1,91 @@
+package monitoringgateway
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+func TestValidateLabels(t *testing.T) {
+	limits := TenantLimits{ValidateSeriesFormat: true, MaxLabelNamesPerSeries: 3, MaxLabelNameLength: 10, MaxLabelValueLength: 10}
+	series := func(lbls ...string) []prompb.Label {
+		var l []prompb.Label
+		for i := 0; i < len(lbls); i += 2 {
+			l = append(l, prompb.Label{Name: lbls[i], Value: lbls[i+1]})
+		}
+		return l
+	}
+
+	for _, tc := range []struct {
+		labels []prompb.Label
+		reason string
+	}{
+		{labels: series("__name__", "up", "job", "a")},
+		{labels: series("job", "a"), reason: reasonMissingMetricName},
+		{labels: series("__name__", "up-1"), reason: reasonInvalidMetricName},
+		{labels: series("__name__", "up", "job-name", "a"), reason: reasonInvalidLabel},
+		{labels: series("__name__", "up", "job", "a", "job", "b"), reason: reasonDuplicateLabelNames},
+		{labels: series("__name__", "up", "a", "a", "b", "b", "c", "c"), reason: reasonMaxLabelNamesPerSeries},
+		{labels: series("__name__", "up", "a_very_long_name", "a"), reason: reasonLabelNameTooLong},
+		{labels: series("__name__", "up", "job", strings.Repeat("a", 11)), reason: reasonLabelValueTooLong},
+	} {
+		err := validateLabels(limits, tc.labels)
+		if tc.reason == "" && err != nil {
+			t.Fatalf("unexpected error for %v: %v", tc.labels, err)
+		}
+		if tc.reason != "" && (err == nil || err.reason != tc.reason) {
+			t.Fatalf("expected %s for %v, got %v", tc.reason, tc.labels, err)
+		}
+	}
+}
+
+func TestValidateWriteRequest(t *testing.T) {
+	now := time.Now()
+	writeRequest := func() *prompb.WriteRequest {
+		return &prompb.WriteRequest{Timeseries: []prompb.TimeSeries{
+			{
+				Labels: []prompb.Label{{Name: "__name__", Value: "up"}},
+				Samples: []prompb.Sample{
+					{Value: 1, Timestamp: now.Add(-2 * time.Hour).UnixMilli()},
+					{Value: 2, Timestamp: now.UnixMilli()},
+					{Value: 3, Timestamp: now.Add(time.Hour).UnixMilli()},
+				},
+			},
+			{
+				Labels:  []prompb.Label{{Name: "__name__", Value: "up"}, {Name: "job", Value: strings.Repeat("a", 11)}},
+				Samples: []prompb.Sample{{Value: 1, Timestamp: now.UnixMilli()}},
+			},
+		}}
+	}
+	limits := TenantLimits{
+		MaxLabelValueLength:    10,
+		RejectOldSamplesMaxAge: model.Duration(time.Hour),
+		CreationGracePeriod:    model.Duration(10 * time.Minute),
+	}
+
+	if _, _, err := validateWriteRequest(writeRequest(), limits, now); err == nil || err.reason != reasonSampleTooOld {
+		t.Fatalf("expected the request rejected for a sample too old, got %v", err)
+	}
+
+	limits.DropInvalidSeries = true
+	wreq := writeRequest()
+	discarded, changed, err := validateWriteRequest(wreq, limits, now)
+	if err != nil || !changed {
+		t.Fatalf("expected the invalid series and samples dropped, got changed %v err %v", changed, err)
+	}
+	want := map[string]int{reasonSampleTooOld: 1, reasonSampleTooFarInFuture: 1, reasonLabelValueTooLong: 1}
+	if diff := cmp.Diff(want, discarded); diff != "" {
+		t.Fatal(diff)
+	}
+	wantSeries := []prompb.TimeSeries{{
+		Labels:  []prompb.Label{{Name: "__name__", Value: "up"}},
+		Samples: []prompb.Sample{{Value: 2, Timestamp: now.UnixMilli()}},
+	}}
+	if diff := cmp.Diff(wantSeries, wreq.Timeseries); diff != "" {
+		t.Fatal(diff)
+	}
+}
@@ -0,0 +1,241 @@
+package monitoringgateway
+
+import (
+	"fmt"
+	"mime"
+
+	"github.com/golang/snappy"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/relabel"
+	"github.com/prometheus/prometheus/prompb"
+	writev2 "github.com/prometheus/prometheus/prompb/io/prometheus/write/v2"
+)
+
+const (
+	remoteWriteVersionHeader  = "X-Prometheus-Remote-Write-Version"
+	remoteWriteVersion1       = "0.1.0"
+	remoteWriteVersion20      = "2.0.0"
+	remoteWriteProtoMediaType = "application/x-protobuf"
+	samplesWrittenHeader      = "X-Prometheus-Remote-Write-Samples-Written"
+	histogramsWrittenHeader   = "X-Prometheus-Remote-Write-Histograms-Written"
+	exemplarsWrittenHeader    = "X-Prometheus-Remote-Write-Exemplars-Written"
+)
+
+// RemoteWriteProtoMsg is the protobuf message of a remote write request.
+type RemoteWriteProtoMsg string
+
+const (
+	// RemoteWriteProtoMsgV1 is the message of the remote write 1.0 protocol.
+	RemoteWriteProtoMsgV1 RemoteWriteProtoMsg = "prometheus.WriteRequest"
+	// RemoteWriteProtoMsgV2 is the message of the remote write 2.0 protocol.
+	RemoteWriteProtoMsgV2 RemoteWriteProtoMsg = "io.prometheus.write.v2.Request"
+)
+
+// Validate returns an error if the message is not supported.
+func (m RemoteWriteProtoMsg) Validate() error {
+	switch m {
+	case RemoteWriteProtoMsgV1, RemoteWriteProtoMsgV2:
+		return nil
+	}
+	return fmt.Errorf("unknown remote write protobuf message %q, supported: %s, %s", m, RemoteWriteProtoMsgV1, RemoteWriteProtoMsgV2)
+}
+
+// ContentType returns the Content-Type header of requests with the message.
+func (m RemoteWriteProtoMsg) ContentType() string {
+	if m == RemoteWriteProtoMsgV2 {
+		return remoteWriteProtoMediaType + ";proto=" + string(m)
+	}
+	// Senders of 1.0 requests use the media type only, which is what 1.0 receivers expect.
+	return remoteWriteProtoMediaType
+}
+
+// Version returns the X-Prometheus-Remote-Write-Version header of requests with the message.
+func (m RemoteWriteProtoMsg) Version() string {
+	if m == RemoteWriteProtoMsgV2 {
+		return remoteWriteVersion20
+	}
+	return remoteWriteVersion1
+}
+
+// parseRemoteWriteProtoMsg returns the message of a remote write request by its Content-Type header.
+// An empty header, or one without the proto parameter, denotes a 1.0 request.
+func parseRemoteWriteProtoMsg(contentType string) (RemoteWriteProtoMsg, error) {
+	if contentType == "" {
+		return RemoteWriteProtoMsgV1, nil
+	}
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "", errors.Wrap(err, "parsing content type")
+	}
+	if mediaType != remoteWriteProtoMediaType {
+		return "", fmt.Errorf("expected %s as the media type, got %s", remoteWriteProtoMediaType, mediaType)
+	}
+	proto, ok := params["proto"]
+	if !ok {
+		return RemoteWriteProtoMsgV1, nil
+	}
+	msg := RemoteWriteProtoMsg(proto)
+	if err := msg.Validate(); err != nil {
+		return "", err
+	}
+	return msg, nil
+}
+
+// decodeWriteRequest decodes a snappy-compressed remote write request body.
+func decodeWriteRequest(body []byte) (*prompb.WriteRequest, error) {
+	reqBuf, err := snappy.Decode(nil, body)
+	if err != nil {
+		return nil, errors.Wrap(err, "decompressing remote write request")
+	}
+
+	var wreq prompb.WriteRequest
+	if err := wreq.Unmarshal(reqBuf); err != nil {
+		return nil, errors.Wrap(err, "unmarshalling remote write request")
+	}
+	return &wreq, nil
+}
+
+// decodeWriteV2Request decodes a snappy-compressed remote write 2.0 request body.
+func decodeWriteV2Request(body []byte) (*writev2.Request, error) {
+	reqBuf, err := snappy.Decode(nil, body)
+	if err != nil {
+		return nil, errors.Wrap(err, "decompressing remote write request")
+	}
+
+	var req writev2.Request
+	if err := req.Unmarshal(reqBuf); err != nil {
+		return nil, errors.Wrap(err, "unmarshalling remote write request")
+	}
+	return &req, nil
+}
+
+// writeV2ToV1 translates a remote write 2.0 request into a 1.0 request.
+// The metadata of the series is kept once per metric family, as 1.0 does not attach it to series.
+func writeV2ToV1(req *writev2.Request) (*prompb.WriteRequest, error) {
+	var (
+		b        labels.ScratchBuilder
+		wreq     = &prompb.WriteRequest{Timeseries: make([]prompb.TimeSeries, 0, len(req.Timeseries))}
+		families = map[string]struct{}{}
+	)
+	for _, ts := range req.Timeseries {
+		if err := validateSymbolRefs(&ts, len(req.Symbols)); err != nil {
+			return nil, err
+		}
+		lbls := ts.ToLabels(&b, req.Symbols)
+
+		series := prompb.TimeSeries{
+			Labels:  prompb.FromLabels(lbls, nil),
+			Samples: make([]prompb.Sample, 0, len(ts.Samples)),
+		}
+		for _, s := range ts.Samples {
+			series.Samples = append(series.Samples, prompb.Sample{Value: s.Value, Timestamp: s.Timestamp})
+		}
+		for _, h := range ts.Histograms {
+			if h.IsFloatHistogram() {
+				series.Histograms = append(series.Histograms, prompb.FromFloatHistogram(h.Timestamp, h.ToFloatHistogram()))
+			} else {
+				series.Histograms = append(series.Histograms, prompb.FromIntHistogram(h.Timestamp, h.ToIntHistogram()))
+			}
+		}
+		for _, e := range ts.Exemplars {
+			ex := e.ToExemplar(&b, req.Symbols)
+			series.Exemplars = append(series.Exemplars, prompb.Exemplar{
+				Labels:    prompb.FromLabels(ex.Labels, nil),
+				Value:     ex.Value,
+				Timestamp: ex.Ts,
+			})
+		}
+		wreq.Timeseries = append(wreq.Timeseries, series)
+
+		name := lbls.Get(labels.MetricName)
+		if _, ok := families[name]; ok || name == "" || ts.Metadata.Type == writev2.Metadata_METRIC_TYPE_UNSPECIFIED {
+			continue
+		}
+		families[name] = struct{}{}
+		md := ts.ToMetadata(req.Symbols)
+		wreq.Metadata = append(wreq.Metadata, prompb.MetricMetadata{
+			Type:             prompb.FromMetadataType(md.Type),
+			MetricFamilyName: name,
+			Help:             md.Help,
+			Unit:             md.Unit,
+		})
+	}
+	return wreq, nil
+}
+
+// validateSymbolRefs returns an error if the series refers to symbols out of the symbol table,
+// which the translation would otherwise panic on.
+func validateSymbolRefs(ts *writev2.TimeSeries, numSymbols int) error {
+	check := func(refs ...uint32) error {
+		for _, ref := range refs {
+			if int(ref) >= numSymbols {
+				return fmt.Errorf("symbol reference %d out of range of %d symbols", ref, numSymbols)
+			}
+		}
+		return nil
+	}
+	if len(ts.LabelsRefs)%2 != 0 {
+		return errors.New("odd number of label references")
+	}
+	if err := check(ts.LabelsRefs...); err != nil {
+		return err
+	}
+	if err := check(ts.Metadata.HelpRef, ts.Metadata.UnitRef); err != nil {
+		return err
+	}
+	for _, e := range ts.Exemplars {
+		if len(e.LabelsRefs)%2 != 0 {
+			return errors.New("odd number of exemplar label references")
+		}
+		if err := check(e.LabelsRefs...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encodeWriteRequest encodes a remote write request into a snappy-compressed body.
+func encodeWriteRequest(wreq *prompb.WriteRequest) ([]byte, error) {
+	data, err := wreq.Marshal()
+	if err != nil {
+		return nil, errors.Wrap(err, "marshalling remote write request")
+	}
+	return snappy.Encode(nil, data), nil
+}
+
+// relabelWriteRequest applies the relabel configs to the series of the request in place,
+// and removes the series that are dropped.
+func relabelWriteRequest(wreq *prompb.WriteRequest, cfgs []*relabel.Config) {
+	var b labels.ScratchBuilder
+
+	series := wreq.Timeseries[:0]
+	for _, ts := range wreq.Timeseries {
+		lbls, keep := relabel.Process(ts.ToLabels(&b, nil), cfgs...)
+		if !keep || lbls.IsEmpty() {
+			continue
+		}
+		ts.Labels = prompb.FromLabels(lbls, nil)
+		series = append(series, ts)
+	}
+	wreq.Timeseries = series
+}
+
+// countWritten returns the number of samples, histograms and exemplars in the request.
+func countWritten(wreq *prompb.WriteRequest) (samples, histograms, exemplars int) {
+	for _, ts := range wreq.Timeseries {
+		samples += len(ts.Samples)
+		histograms += len(ts.Histograms)
+		exemplars += len(ts.Exemplars)
+	}
+	return samples, histograms, exemplars
+}
+
+// countSamples returns the number of samples and histograms in the request.
+func countSamples(wreq *prompb.WriteRequest) int {
+	n := 0
+	for _, ts := range wreq.Timeseries {
+		n += len(ts.Samples) + len(ts.Histograms)
+	}
+	return n
+}